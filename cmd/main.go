@@ -5,20 +5,30 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"tether/src/api"
 	"tether/src/bot"
+	"tether/src/election"
+	"tether/src/lib"
 	"tether/src/logging"
 	"tether/src/middleware"
+	"tether/src/mqtt"
+	"tether/src/replication"
+	"tether/src/rules"
 	"tether/src/store"
 	"tether/src/utils"
 	"tether/src/version"
+	"tether/src/webhooks"
 	ws "tether/src/websocket"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
@@ -29,21 +39,148 @@ func main() {
 	logging.Log.Infof("Starting Tether %s", version.Version)
 
 	port := getenv("PORT", "8080")
+	behindProxy := getenv("BEHIND_PROXY", "false") == "true"
+	origins := middleware.ParseOriginPolicy(getenv("ALLOWED_ORIGINS", "*"))
+	dataDir := getenv("DATA_DIR", "data")
 	st := store.NewPresenceStore()
-	wsServer := ws.NewServer(st)
+	if err := st.LoadPrivacy(filepath.Join(dataDir, "privacy.json")); err != nil {
+		logging.Log.WithError(err).Warn("failed to load privacy settings")
+	}
+	if err := st.LoadKV(filepath.Join(dataDir, "kv.json")); err != nil {
+		logging.Log.WithError(err).Warn("failed to load kv store")
+	}
+	if err := st.LoadAPIKeys(filepath.Join(dataDir, "api_keys.json")); err != nil {
+		logging.Log.WithError(err).Warn("failed to load api keys")
+	}
+	apps := lib.NewApplicationCache(nil)
+	if getenv("APPLICATION_METADATA_FETCH", "false") == "true" {
+		apps = lib.NewApplicationCache(lib.DiscordApplicationFetcher{Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	if err := apps.LoadFile(getenv("APPLICATION_METADATA_FILE", filepath.Join(dataDir, "applications.json"))); err != nil {
+		logging.Log.WithError(err).Warn("failed to load application metadata")
+	}
+	lib.SetApplicationCache(apps)
+	lib.SetMemberAllowlist(strings.Split(os.Getenv("MEMBER_PROFILE_ALLOWLIST"), ","))
+	utils.SetCDNDefaults(cdnDefaults())
+	ruleList, err := rules.LoadFile(getenv("RULES_FILE", filepath.Join(dataDir, "rules.json")))
+	if err != nil {
+		logging.Log.WithError(err).Fatal("failed to load rules")
+	}
+	// RULES_DRY_RUN evaluates the rules against a recorded gateway session,
+	// logs what would have fired and exits without starting the server.
+	if recording := os.Getenv("RULES_DRY_RUN"); recording != "" {
+		dryRunRules(recording, ruleList)
+		return
+	}
+	wsServer := ws.NewServer(st, ws.Config{
+		BehindProxy:      behindProxy,
+		MaxConnsPerIP:    getenvInt("WS_MAX_CONNECTIONS_PER_IP", 10),
+		MaxConns:         getenvInt("WS_MAX_CONNECTIONS", 5000),
+		MaxSubscriptions: getenvInt("WS_MAX_SUBSCRIPTIONS", 100),
+		Origins:          origins,
+	})
+
+	hooks := webhooks.New(st, webhooks.Config{
+		File:           getenv("WEBHOOKS_FILE", filepath.Join(dataDir, "webhooks.json")),
+		DeadLetterFile: getenv("WEBHOOK_DEAD_LETTER_FILE", filepath.Join(dataDir, "webhook_dead_letters.jsonl")),
+		MaxAttempts:    getenvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+	})
+	if err := hooks.Load(); err != nil {
+		logging.Log.WithError(err).Warn("failed to load webhooks")
+	}
+
+	// Replication: REPLICATION_TOKEN lets other nodes stream this store;
+	// REPLICATION_SOURCE follows another node's stream into it.
+	// FOLLOWER_MODE serves REPLICATION_SOURCE's data without a Discord
+	// session; outbound integrations only run on the leader. With ELECTION
+	// set, the nodes pick the leader themselves and followers track it.
+	nodeID := getenv("NODE_ID", replication.DefaultNodeID())
+	follower := getenv("FOLLOWER_MODE", "false") == "true"
+	electionMode := os.Getenv("ELECTION")
+	if follower && os.Getenv("REPLICATION_SOURCE") == "" {
+		logging.Log.Fatal("FOLLOWER_MODE requires REPLICATION_SOURCE")
+	}
+	if electionMode != "" && (follower || os.Getenv("REPLICATION_SOURCE") != "") {
+		logging.Log.Fatal("ELECTION cannot be combined with FOLLOWER_MODE or REPLICATION_SOURCE")
+	}
+	if electionMode != "" && os.Getenv("REPLICATION_TOKEN") == "" {
+		logging.Log.Fatal("ELECTION requires REPLICATION_TOKEN")
+	}
+	var hub *replication.Hub
+	if token := os.Getenv("REPLICATION_TOKEN"); token != "" {
+		hub = replication.NewHub(st, nodeID, token)
+		st.AddReplicator(hub)
+	}
+	var receiver *replication.Receiver
+	if source := os.Getenv("REPLICATION_SOURCE"); source != "" || electionMode != "" {
+		receiver = replication.NewReceiver(st, replication.ReceiverConfig{
+			Source: source,
+			Token:  os.Getenv("REPLICATION_TOKEN"),
+			NodeID: nodeID,
+		})
+		receiver.Start()
+	}
+
+	self := &node{st: st, receiver: receiver, hooks: hooks, launch: launchBot(st)}
+	if broker := os.Getenv("MQTT_BROKER_URL"); broker != "" {
+		discovery := getenv("MQTT_DISCOVERY_PREFIX", "homeassistant")
+		if getenv("MQTT_DISCOVERY", "true") != "true" {
+			discovery = ""
+		}
+		self.mqtt = &mqtt.Config{
+			BrokerURL:       broker,
+			Username:        os.Getenv("MQTT_USERNAME"),
+			Password:        os.Getenv("MQTT_PASSWORD"),
+			ClientID:        getenv("MQTT_CLIENT_ID", "tether"),
+			TopicPrefix:     getenv("MQTT_TOPIC_PREFIX", "tether"),
+			DiscoveryPrefix: discovery,
+		}
+	}
+	engine := rules.New(ruleList, rules.Actions{
+		SendMessage: func(channelID, content string) error { return self.Shards().SendMessage(channelID, content) },
+		Webhooks:    hooks,
+	}, false)
+	if len(ruleList) > 0 {
+		self.engine = engine
+	}
+	elector := newElector(electionMode, nodeID, port, dataDir)
 
 	r := chi.NewRouter()
 
 	// Basic Middleware
-	behindProxy := getenv("BEHIND_PROXY", "false") == "true"
-	middleware.Setup(r, behindProxy)
+	middleware.Setup(r, behindProxy, origins)
 
 	// Routes
 	r.Get("/v1/users/{userID}", api.SnapshotHandler{Store: st}.ServeHTTP)
+	kv := func(w http.ResponseWriter, r *http.Request) {
+		api.KVHandler{Store: st, ReadOnly: !self.Leader()}.ServeHTTP(w, r)
+	}
+	r.Put("/v1/users/{userID}/kv/{key}", kv)
+	r.Delete("/v1/users/{userID}/kv/{key}", kv)
 	// Handle requests with no user ID (e.g. GET /v1/users or /v1/users/)
 	r.Get("/v1/users", api.MissingUserHandler{}.ServeHTTP)
 	r.Get("/v1/users/", api.MissingUserHandler{}.ServeHTTP)
 	r.Get("/healthz", api.HealthHandler{}.ServeHTTP)
+	// The webhook admin API is not mounted on static followers. Elected nodes
+	// mount it so it is in place when they lead.
+	if token := os.Getenv("ADMIN_API_TOKEN"); token != "" && !follower {
+		webhooksHandler := func(w http.ResponseWriter, r *http.Request) {
+			api.WebhooksHandler{Webhooks: hooks, AdminToken: token, ReadOnly: !self.Leader()}.ServeHTTP(w, r)
+		}
+		r.Get("/v1/webhooks", webhooksHandler)
+		r.Post("/v1/webhooks", webhooksHandler)
+		r.Delete("/v1/webhooks/{webhookID}", webhooksHandler)
+		r.Get("/v1/webhooks/{webhookID}/deliveries", webhooksHandler)
+		r.Get("/v1/rules", api.RulesHandler{Rules: engine, AdminToken: token}.ServeHTTP)
+	}
+	if hub != nil {
+		r.Get(replication.StreamPath, hub.ServeHTTP)
+	}
+	if lease, ok := elector.(*election.HTTPLease); ok {
+		r.Get(election.LeasePath, lease.ServeHTTP)
+		r.Post(election.LeasePath, lease.ServeHTTP)
+		r.Delete(election.LeasePath, lease.ServeHTTP)
+	}
 	r.Handle("/socket", wsServer)
 	// Custom 404 handler for API routes
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
@@ -64,10 +201,29 @@ func main() {
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
-	// Launch Discord bot
-	discordSession, err := bot.Launch(os.Getenv("DISCORD_TOKEN"), st)
-	if err != nil {
-		logging.Log.WithError(err).Fatal("failed to start Discord bot")
+	// Readiness follows the current role: the leader's member sync, or a
+	// follower's stream from the leader.
+	maxLag := time.Duration(getenvInt("REPLICATION_MAX_LAG_MS", 10000)) * time.Millisecond
+	r.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		h := api.ReadinessHandler{Chunks: self.Shards().Chunks(), Replication: receiver, MaxLag: maxLag}
+		if electionMode != "" && self.Leader() {
+			h.Replication = nil
+		}
+		h.ServeHTTP(w, r)
+	})
+
+	// Launch Discord bot (one session per configured shard), or replay a
+	// recorded gateway session instead of connecting to Discord, once this
+	// node leads. Followers do neither.
+	stopElection := func() {}
+	switch {
+	case elector != nil:
+		logging.Log.WithFields(logrus.Fields{"election": electionMode, "node_id": nodeID}).Info("campaigning for leadership")
+		stopElection = self.elect(elector)
+	case follower:
+		logging.Log.WithField("leader", os.Getenv("REPLICATION_SOURCE")).Info("running as a read-only follower")
+	default:
+		self.lead()
 	}
 
 	go func() {
@@ -77,29 +233,143 @@ func main() {
 		}
 	}()
 
-	waitForShutdown(srv, discordSession, wsServer)
+	waitForShutdown(srv, self, stopElection, wsServer, receiver)
 }
 
-func waitForShutdown(srv *http.Server, discordSession interface{ Close() error }, wsServer interface{ Close() }) {
+// waitForShutdown blocks until SIGINT or SIGTERM. It stops the leader's
+// work before giving up leadership, so another node never connects while
+// this one is still connected, then stops the server and every closer.
+func waitForShutdown(srv *http.Server, self *node, stopElection func(), closers ...interface{ Close() }) {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
 	logging.Log.Info("shutting down...")
 
+	self.Close()
+	stopElection()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	_ = srv.Shutdown(ctx)
-	if discordSession != nil {
-		_ = discordSession.Close()
+	for _, c := range closers {
+		c.Close()
 	}
-	if wsServer != nil {
-		wsServer.Close()
+}
+
+// launchBot returns the leader's Discord startup: one session per configured
+// shard, or a replay of GATEWAY_REPLAY instead of connecting to Discord.
+func launchBot(st *store.PresenceStore) func(ctx context.Context) (*bot.ShardManager, error) {
+	return func(ctx context.Context) (*bot.ShardManager, error) {
+		replay := os.Getenv("GATEWAY_REPLAY")
+		if replay == "" {
+			return bot.LaunchContext(ctx, os.Getenv("DISCORD_TOKEN"), st)
+		}
+		speed := getenvFloat("GATEWAY_REPLAY_SPEED", 1)
+		go func() {
+			if err := bot.Replay(ctx, replay, st, speed); err != nil && ctx.Err() == nil {
+				logging.Log.WithError(err).Error("gateway replay failed")
+			}
+		}()
+		return nil, nil
 	}
 }
 
+// newElector configures the ELECTION backend: "file" for nodes sharing a
+// lock file on one host, "http" for leases between ELECTION_PEERS. It
+// returns nil when election is disabled.
+func newElector(mode, nodeID, port, dataDir string) election.Elector {
+	advertise := os.Getenv("ADVERTISE_URL")
+	if advertise == "" {
+		host, _ := os.Hostname()
+		advertise = "http://" + host + ":" + port
+	}
+	switch mode {
+	case "":
+		return nil
+	case "file":
+		return election.FileLock{
+			Path:   getenv("ELECTION_LOCK_FILE", filepath.Join(dataDir, "leader.lock")),
+			NodeID: nodeID,
+			URL:    advertise,
+		}
+	case "http":
+		peers := strings.Split(os.Getenv("ELECTION_PEERS"), ",")
+		return election.NewHTTPLease(election.HTTPLeaseConfig{
+			NodeID: nodeID,
+			URL:    advertise,
+			Peers:  peers,
+			Token:  os.Getenv("REPLICATION_TOKEN"),
+			TTL:    time.Duration(getenvInt("ELECTION_TTL_MS", 10000)) * time.Millisecond,
+		})
+	default:
+		logging.Log.WithField("election", mode).Fatal("unknown ELECTION backend; use file or http")
+		return nil
+	}
+}
+
+// dryRunRules replays a recorded gateway session through a dry-run rule
+// engine and logs every match.
+func dryRunRules(recording string, ruleList []rules.Rule) {
+	engine := rules.New(ruleList, rules.Actions{}, true)
+	if err := bot.ReplayPresenceEvents(recording, engine.Observe); err != nil {
+		logging.Log.WithError(err).Fatal("rules dry run failed")
+	}
+	logging.Log.WithField("matches", len(engine.Matches())).Info("rules dry run complete")
+}
+
 func getenv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
 	}
 	return fallback
 }
+
+// cdnDefaults reads the deployment-wide image options. Invalid values are
+// logged and ignored so a typo never takes the API down.
+func cdnDefaults() utils.CDNOptions {
+	opts := utils.CDNOptions{
+		Size:   getenvInt("CDN_IMAGE_SIZE", 0),
+		Format: os.Getenv("CDN_IMAGE_FORMAT"),
+	}
+	if v := os.Getenv("CDN_ANIMATED"); v != "" {
+		if animated, err := strconv.ParseBool(v); err == nil {
+			opts.Animated = &animated
+		} else {
+			logging.Log.WithField("key", "CDN_ANIMATED").Warnf("invalid boolean %q, using default", v)
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		logging.Log.WithError(err).Warn("invalid CDN image options, using defaults")
+		return utils.CDNOptions{}
+	}
+	return opts
+}
+
+// getenvInt parses an integer environment variable, falling back when it is
+// unset or malformed.
+func getenvInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		logging.Log.WithField("key", key).Warnf("invalid integer %q, using default %d", v, fallback)
+		return fallback
+	}
+	return n
+}
+
+// getenvFloat parses a float environment variable, falling back when it is
+// unset or malformed.
+func getenvFloat(key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		logging.Log.WithField("key", key).Warnf("invalid number %q, using default %g", v, fallback)
+		return fallback
+	}
+	return f
+}
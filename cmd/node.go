@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"tether/src/bot"
+	"tether/src/concurrency"
+	"tether/src/election"
+	"tether/src/logging"
+	"tether/src/mqtt"
+	"tether/src/replication"
+	"tether/src/rules"
+	"tether/src/store"
+	"tether/src/webhooks"
+
+	"github.com/sirupsen/logrus"
+)
+
+// node runs the work of this process's role. The leader holds the Discord
+// session (or replays a recording) and runs the outbound integrations:
+// webhooks, rules and the MQTT bridge. Followers serve the leader's
+// replicated data and reject KV writes.
+type node struct {
+	st       *store.PresenceStore
+	receiver *replication.Receiver
+	hooks    *webhooks.Dispatcher
+	engine   *rules.Engine // nil without rules
+	mqtt     *mqtt.Config  // nil without a broker
+	// launch connects to Discord until ctx ends; it may return nil shards.
+	launch func(ctx context.Context) (*bot.ShardManager, error)
+
+	mu     sync.Mutex
+	leader bool
+	closed bool
+	shards *bot.ShardManager
+	bridge *mqtt.Bridge
+	// term is the current leadership's context and endTerm cancels it.
+	term    context.Context
+	endTerm context.CancelFunc
+}
+
+// Leader reports whether this node currently leads. A preempted term no
+// longer counts, even before its work has stopped.
+func (n *node) Leader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader && n.term.Err() == nil
+}
+
+// Shards returns the current Discord session, nil unless leading.
+func (n *node) Shards() *bot.ShardManager {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.shards
+}
+
+// lead starts the leader's work.
+func (n *node) lead() {
+	n.mu.Lock()
+	if n.closed || (n.leader && n.term.Err() == nil) {
+		n.mu.Unlock()
+		return
+	}
+	n.mu.Unlock()
+	// Finish a term that was preempted before its step-down was applied.
+	n.stepDown()
+
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.leader = true
+	// Settings are only changed by the leader and not replicated; pick up
+	// the previous leader's changes from the shared DATA_DIR.
+	if err := n.st.ReloadSettings(); err != nil {
+		logging.Log.WithError(err).Warn("failed to reload settings")
+	}
+	if err := n.hooks.Load(); err != nil {
+		logging.Log.WithError(err).Warn("failed to reload webhooks")
+	}
+	// Versions from this term must win over the previous leader's.
+	n.st.NewEpoch()
+	ctx, cancel := context.WithCancel(context.Background())
+	n.term, n.endTerm = ctx, cancel
+	n.mu.Unlock()
+
+	// Connecting can take a while; requests are served meanwhile, and
+	// preempt ends ctx to abandon it.
+	shards, err := n.launch(ctx)
+	if ctx.Err() != nil {
+		_ = shards.Close()
+		return
+	}
+	if err != nil {
+		logging.Log.WithError(err).Fatal("failed to start Discord bot")
+	}
+	var bridge *mqtt.Bridge
+	if n.mqtt != nil {
+		bridge = mqtt.New(n.st, *n.mqtt)
+		if err := bridge.Start(); err != nil {
+			logging.Log.WithError(err).Error("failed to start mqtt bridge")
+			bridge = nil
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if ctx.Err() != nil {
+		// Preempted or stepped down while starting.
+		_ = shards.Close()
+		bridge.Close()
+		return
+	}
+	n.shards, n.bridge = shards, bridge
+	n.hooks.Start()
+	if n.engine != nil {
+		go n.engine.Run(ctx, n.st)
+	}
+}
+
+// stepDown stops the leader's work, if running.
+func (n *node) stepDown() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.leader {
+		return
+	}
+	n.leader = false
+	n.endTerm()
+	if err := n.shards.Close(); err != nil {
+		logging.Log.WithError(err).Warn("failed to close discord shards")
+	}
+	n.shards = nil
+	n.hooks.Close()
+	n.bridge.Close()
+	n.bridge = nil
+}
+
+// preempt ends the current term's context without waiting for the node's
+// work to stop, so a lead still connecting to Discord gives up at once.
+// The step-down itself is applied afterwards.
+func (n *node) preempt() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.leader {
+		n.endTerm()
+	}
+}
+
+// elect follows elector's decisions, leading or stepping down and following
+// the new leader's stream, until the returned function is called. That
+// function returns once leadership has been released.
+func (n *node) elect(elector election.Elector) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// Starting or stopping the bot can take a while, so changes are applied
+	// on their own goroutine and only the latest pending one is kept.
+	changes := make(chan election.State, 1)
+	concurrency.GoSafe(func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case s := <-changes:
+				n.apply(s)
+			}
+		}
+	})
+	done := make(chan struct{})
+	concurrency.GoSafe(func() {
+		defer close(done)
+		elector.Run(ctx, func(s election.State) {
+			if !s.Leader {
+				// Do not wait for a lead in progress to finish first.
+				n.preempt()
+			}
+			select {
+			case <-changes:
+			default:
+			}
+			changes <- s
+		})
+	})
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (n *node) apply(s election.State) {
+	log := logging.Log.WithFields(logrus.Fields{"leader_id": s.LeaderID, "leader_url": s.LeaderURL})
+	if s.Leader {
+		log.Info("elected leader")
+		n.receiver.Follow("")
+		n.lead()
+		return
+	}
+	if s.LeaderID == "" {
+		log.Info("no leader elected")
+	} else {
+		log.Info("following the elected leader")
+	}
+	n.stepDown()
+	n.receiver.Follow(s.LeaderURL)
+}
+
+// Close stops the leader's work for good.
+func (n *node) Close() {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+	n.stepDown()
+}
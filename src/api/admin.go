@@ -0,0 +1,43 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"tether/src/rules"
+	"tether/src/utils"
+)
+
+// requireAdmin checks the request's Authorization header against the
+// configured admin token, answering 401 when it does not match.
+func requireAdmin(w http.ResponseWriter, r *http.Request, token string) bool {
+	given := apiKeyFromRequest(r)
+	if token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+		return true
+	}
+	utils.WriteJSON(w, http.StatusUnauthorized, utils.ErrorResponse(
+		"UNAUTHORIZED",
+		"A valid admin token is required",
+		http.StatusUnauthorized,
+		false,
+		nil,
+	))
+	return false
+}
+
+// RulesHandler serves GET /v1/rules: the loaded rules and their per-user
+// state. It requires the admin token.
+type RulesHandler struct {
+	Rules      *rules.Engine
+	AdminToken string
+}
+
+func (h RulesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r, h.AdminToken) {
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.SuccessResponse(map[string]any{
+		"rules": h.Rules.Rules(),
+		"state": h.Rules.State(),
+	}))
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"tether/src/store"
+	"tether/src/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// KVHandler serves PUT and DELETE /v1/users/{id}/kv/{key}. Requests must carry
+// the user's API key (issued via the /kv apikey slash command) in the
+// Authorization header; PUT bodies are stored verbatim as the value.
+//
+// ReadOnly nodes (followers) reject every write.
+type KVHandler struct {
+	Store    *store.PresenceStore
+	ReadOnly bool
+}
+
+func (h KVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.ReadOnly {
+		utils.WriteJSON(w, http.StatusServiceUnavailable, utils.ErrorResponse(
+			"READ_ONLY_NODE",
+			"This node is a read-only follower; send writes to the leader",
+			http.StatusServiceUnavailable,
+			false,
+			nil,
+		))
+		return
+	}
+
+	userID := chi.URLParam(r, "userID")
+	if !validUserID(userID) {
+		writeInvalidUserID(w)
+		return
+	}
+
+	owner, ok := h.Store.UserForAPIKey(apiKeyFromRequest(r))
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.ErrorResponse(
+			"UNAUTHORIZED",
+			"A valid API key is required",
+			http.StatusUnauthorized,
+			false,
+			nil,
+		))
+		return
+	}
+	if owner != userID {
+		utils.WriteJSON(w, http.StatusForbidden, utils.ErrorResponse(
+			"FORBIDDEN",
+			"API key does not belong to this user",
+			http.StatusForbidden,
+			false,
+			nil,
+		))
+		return
+	}
+
+	key := chi.URLParam(r, "key")
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		var body []byte
+		body, err = io.ReadAll(io.LimitReader(r.Body, store.MaxKVValueLength+1))
+		if err == nil {
+			err = h.Store.SetKV(userID, key, string(body))
+		}
+	case http.MethodDelete:
+		err = h.Store.DeleteKV(userID, key)
+	default:
+		utils.WriteJSON(w, http.StatusMethodNotAllowed, utils.ErrorResponse(
+			"INVALID_REQUEST",
+			"The request is invalid",
+			http.StatusMethodNotAllowed,
+			false,
+			nil,
+		))
+		return
+	}
+
+	if err != nil {
+		writeKVError(w, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.SuccessResponse(h.Store.GetKV(userID)))
+}
+
+// apiKeyFromRequest accepts either a bare key or a Bearer token.
+func apiKeyFromRequest(r *http.Request) string {
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	if after, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		return strings.TrimSpace(after)
+	}
+	return auth
+}
+
+func writeKVError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrKVKeyNotPresent):
+		utils.WriteJSON(w, http.StatusNotFound, utils.ErrorResponse(
+			"KV_KEY_NOT_FOUND",
+			err.Error(),
+			http.StatusNotFound,
+			false,
+			nil,
+		))
+	case errors.Is(err, store.ErrKVInvalidKey), errors.Is(err, store.ErrKVValueTooLong), errors.Is(err, store.ErrKVTooManyKeys):
+		utils.WriteJSON(w, http.StatusBadRequest, utils.ErrorResponse(
+			"INVALID_KV",
+			err.Error(),
+			http.StatusBadRequest,
+			false,
+			map[string]any{
+				"max_keys":         store.MaxKVKeys,
+				"max_key_length":   store.MaxKVKeyLength,
+				"max_value_length": store.MaxKVValueLength,
+			},
+		))
+	default:
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.ErrorResponse(
+			"INTERNAL_ERROR",
+			"An unexpected error occurred",
+			http.StatusInternalServerError,
+			true,
+			nil,
+		))
+	}
+}
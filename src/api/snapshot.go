@@ -1,8 +1,14 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"tether/src/lib"
+	"tether/src/replication"
 	"tether/src/store"
 	"tether/src/utils"
 
@@ -16,20 +22,36 @@ type SnapshotHandler struct {
 
 func (h SnapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userID")
-	if userID == "" {
+	if !validUserID(userID) {
 		writeInvalidUserID(w)
 		return
 	}
 
-	// Validate that userID consists only of digits (Discord snowflake IDs).
-	for _, ch := range userID {
-		if ch < '0' || ch > '9' {
-			writeInvalidUserID(w)
-			return
-		}
+	query := r.URL.Query()
+	schema := query.Get("activity_schema")
+	if !store.ValidActivitySchema(schema) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.ErrorResponse(
+			"INVALID_ACTIVITY_SCHEMA",
+			"activity_schema must be one of: raw, v1",
+			http.StatusBadRequest,
+			false,
+			nil,
+		))
+		return
+	}
+	cdn, err := parseCDNOptions(query)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.ErrorResponse(
+			"INVALID_IMAGE_OPTIONS",
+			err.Error(),
+			http.StatusBadRequest,
+			false,
+			nil,
+		))
+		return
 	}
 
-	presence, ok := h.Store.GetPresence(userID)
+	presence, ok := h.Store.GetPublicPresence(userID)
 	if !ok {
 		utils.WriteJSON(w, http.StatusNotFound, utils.ErrorResponse(
 			"USER_NOT_FOUND",
@@ -41,14 +63,91 @@ func (h SnapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, utils.SuccessResponse(presence.Public))
+	utils.WriteJSON(w, http.StatusOK, utils.SuccessResponse(presence.Render(store.RenderOptions{Schema: schema, CDN: cdn}, time.Now())))
+}
+
+// parseCDNOptions reads the avatar_size, avatar_format and animated query
+// parameters.
+func parseCDNOptions(query url.Values) (utils.CDNOptions, error) {
+	var opts utils.CDNOptions
+	if v := query.Get("avatar_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, errors.New("avatar_size must be a power of two between 16 and 4096")
+		}
+		opts.Size = size
+	}
+	opts.Format = query.Get("avatar_format")
+	if v := query.Get("animated"); v != "" {
+		animated, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, errors.New("animated must be true or false")
+		}
+		opts.Animated = &animated
+	}
+	return opts, opts.Validate()
 }
 
 // HealthHandler is a simple readiness probe.
 type HealthHandler struct{}
 
 func (HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	utils.WriteJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"status": "ok", "server_time": time.Now().UnixMilli()})
+}
+
+// ReadinessHandler reports whether the initial guild member sync has
+// finished. It answers 503 with per-guild progress until every requested
+// chunk sequence completes; a nil tracker (bot disabled) is always ready.
+//
+// On nodes that follow a leader it also requires the replication stream to
+// be connected, synced and, when MaxLag is set, to have delivered a frame
+// within that long. MaxLag should exceed replication.HeartbeatInterval.
+type ReadinessHandler struct {
+	Chunks      *lib.ChunkTracker
+	Replication *replication.Receiver
+	MaxLag      time.Duration
+}
+
+func (h ReadinessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	progress := h.Chunks.Progress()
+	if progress == nil {
+		progress = []lib.ChunkProgress{}
+	}
+	status, code := "ready", http.StatusOK
+	if !h.Chunks.Ready() {
+		status, code = "syncing", http.StatusServiceUnavailable
+	}
+	body := map[string]any{"guilds": progress}
+	if h.Replication != nil {
+		repl := h.Replication.Status()
+		switch {
+		case !repl.Connected:
+			status, code = "disconnected", http.StatusServiceUnavailable
+		case !repl.Synced:
+			status, code = "syncing", http.StatusServiceUnavailable
+		case h.MaxLag > 0 && repl.Staleness > h.MaxLag:
+			status, code = "lagging", http.StatusServiceUnavailable
+		}
+		leader := map[string]any{
+			"source":       repl.Source,
+			"source_node":  repl.SourceNode,
+			"connected":    repl.Connected,
+			"synced":       repl.Synced,
+			"staleness_ms": repl.Staleness.Milliseconds(),
+			"lag_ms":       repl.Lag.Milliseconds(),
+			"last_message": nil,
+		}
+		if !repl.LastMessage.IsZero() {
+			leader["last_message"] = repl.LastMessage.UTC()
+		}
+		if repl.LastError != "" {
+			leader["last_error"] = repl.LastError
+		}
+		body["leader"] = leader
+	}
+	body["status"] = status
+	body["server_time"] = time.Now().UnixMilli()
+	utils.WriteJSON(w, code, body)
 }
 
 // MissingUserHandler handles requests to /v1/users or /v1/users/ (no user ID provided).
@@ -58,6 +157,19 @@ func (MissingUserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	writeInvalidUserID(w)
 }
 
+// validUserID checks that userID consists only of digits (Discord snowflake IDs).
+func validUserID(userID string) bool {
+	if userID == "" {
+		return false
+	}
+	for _, ch := range userID {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func writeInvalidUserID(w http.ResponseWriter) {
 	utils.WriteJSON(w, http.StatusBadRequest, utils.ErrorResponse(
 		"INVALID_USER_ID",
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"tether/src/logging"
+	"tether/src/utils"
+	"tether/src/webhooks"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxWebhookBody caps POST /v1/webhooks request bodies.
+const maxWebhookBody = 16 << 10
+
+// WebhooksHandler serves the webhook admin API:
+//
+//	GET    /v1/webhooks                           list webhooks (secrets redacted)
+//	POST   /v1/webhooks                           register a webhook
+//	DELETE /v1/webhooks/{webhookID}               remove a webhook
+//	GET    /v1/webhooks/{webhookID}/deliveries    recent delivery status
+//
+// Every request must carry AdminToken in the Authorization header. ReadOnly
+// nodes (followers) reject registrations and removals.
+type WebhooksHandler struct {
+	Webhooks   *webhooks.Dispatcher
+	AdminToken string
+	ReadOnly   bool
+}
+
+func (h WebhooksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r, h.AdminToken) {
+		return
+	}
+
+	id := chi.URLParam(r, "webhookID")
+	if h.ReadOnly && (r.Method == http.MethodPost || r.Method == http.MethodDelete) {
+		utils.WriteJSON(w, http.StatusServiceUnavailable, utils.ErrorResponse(
+			"READ_ONLY_NODE",
+			"This node is a read-only follower; send writes to the leader",
+			http.StatusServiceUnavailable,
+			false,
+			nil,
+		))
+		return
+	}
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		hooks := h.Webhooks.Webhooks()
+		out := make([]webhooks.Webhook, len(hooks))
+		for i, hook := range hooks {
+			out[i] = hook.Redacted()
+		}
+		utils.WriteJSON(w, http.StatusOK, utils.SuccessResponse(out))
+	case id == "" && r.Method == http.MethodPost:
+		h.create(w, r)
+	case id != "" && r.Method == http.MethodDelete:
+		if err := h.Webhooks.Remove(id); err != nil {
+			writeWebhookError(w, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, utils.SuccessResponse(nil))
+	case id != "" && r.Method == http.MethodGet:
+		deliveries, err := h.Webhooks.Deliveries(id)
+		if err != nil {
+			writeWebhookError(w, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, utils.SuccessResponse(deliveries))
+	default:
+		utils.WriteJSON(w, http.StatusMethodNotAllowed, utils.ErrorResponse(
+			"INVALID_REQUEST",
+			"The request is invalid",
+			http.StatusMethodNotAllowed,
+			false,
+			nil,
+		))
+	}
+}
+
+// create registers the webhook in the request body. The response is the only
+// place the generated secret is returned.
+func (h WebhooksHandler) create(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	var hook webhooks.Webhook
+	if err == nil {
+		err = json.Unmarshal(body, &hook)
+	}
+	if err != nil {
+		writeWebhookError(w, errors.New("body must be a JSON webhook object"))
+		return
+	}
+	created, err := h.Webhooks.Register(webhooks.Webhook{
+		URL:     hook.URL,
+		Secret:  hook.Secret,
+		UserIDs: hook.UserIDs,
+		Events:  hook.Events,
+	})
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.SuccessResponse(created))
+}
+
+func writeWebhookError(w http.ResponseWriter, err error) {
+	if errors.Is(err, webhooks.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.ErrorResponse(
+			"WEBHOOK_NOT_FOUND",
+			err.Error(),
+			http.StatusNotFound,
+			false,
+			nil,
+		))
+		return
+	}
+	if errors.Is(err, webhooks.ErrSave) {
+		logging.Log.WithError(err).Error("failed to save webhooks")
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.ErrorResponse(
+			"INTERNAL_ERROR",
+			"An unexpected error occurred",
+			http.StatusInternalServerError,
+			true,
+			nil,
+		))
+		return
+	}
+	utils.WriteJSON(w, http.StatusBadRequest, utils.ErrorResponse(
+		"INVALID_WEBHOOK",
+		err.Error(),
+		http.StatusBadRequest,
+		false,
+		map[string]any{"events": webhooks.EventKinds},
+	))
+}
@@ -0,0 +1,83 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// discordHost is the host discordgo's built-in endpoints point at.
+const discordHost = "discord.com"
+
+// newSession creates a bot session. DISCORD_API_URL redirects discordgo's
+// REST calls (including the gateway lookup) to another Discord-compatible
+// server such as src/fakegateway; DISCORD_GATEWAY_URL pins the websocket
+// URL returned by that lookup.
+func newSession(token string) (*discordgo.Session, error) {
+	sess, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newEndpointTransport(os.Getenv("DISCORD_API_URL"), os.Getenv("DISCORD_GATEWAY_URL"))
+	if err != nil {
+		return nil, err
+	}
+	if transport != nil {
+		sess.Client = &http.Client{Timeout: sess.Client.Timeout, Transport: transport}
+	}
+	return sess, nil
+}
+
+// endpointTransport rewrites requests for discord.com onto api and answers
+// GET /gateway with gateway when set.
+type endpointTransport struct {
+	api     *url.URL
+	gateway string
+	base    http.RoundTripper
+}
+
+func newEndpointTransport(apiURL, gatewayURL string) (*endpointTransport, error) {
+	if apiURL == "" && gatewayURL == "" {
+		return nil, nil
+	}
+	t := &endpointTransport{gateway: gatewayURL, base: http.DefaultTransport}
+	if apiURL != "" {
+		u, err := url.Parse(apiURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid DISCORD_API_URL %q", apiURL)
+		}
+		t.api = u
+	}
+	return t, nil
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != discordHost {
+		return t.base.RoundTrip(req)
+	}
+	if t.gateway != "" && req.Method == http.MethodGet && strings.HasSuffix(req.URL.Path, "/gateway") {
+		body := fmt.Sprintf(`{"url":%q}`, t.gateway)
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Status:        "200 OK",
+			Header:        http.Header{"Content-Type": []string{"application/json"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	if t.api == nil {
+		return t.base.RoundTrip(req)
+	}
+	out := req.Clone(req.Context())
+	out.URL.Scheme = t.api.Scheme
+	out.URL.Host = t.api.Host
+	out.URL.Path = strings.TrimSuffix(t.api.Path, "/") + req.URL.Path
+	out.Host = ""
+	return t.base.RoundTrip(out)
+}
@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"tether/src/lib"
+	"tether/src/logging"
+	"tether/src/store"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// guildTracker filters gateway traffic by the optional guild allowlist and
+// makes sure member chunks are requested once per guild per gateway session,
+// whether the guild arrives in READY or a later GUILD_CREATE (whose handlers
+// may run concurrently). Each request carries a
+// nonce so chunks can be correlated with it by the ChunkTracker.
+type guildTracker struct {
+	allowlist []string
+	allowed   map[string]struct{}
+	// legacy is GUILD_ID when GUILD_IDS is unset. Members are requested and
+	// commands registered there alone, as before GUILD_IDS existed, while
+	// presence from every guild is still tracked.
+	legacy string
+	chunks *lib.ChunkTracker
+	nonces atomic.Uint64
+
+	mu sync.Mutex
+	// requested maps guild ID to the gateway session that requested it.
+	requested map[string]string
+}
+
+func newGuildTracker(allowlist []string, legacy string, chunks *lib.ChunkTracker) *guildTracker {
+	allowed := make(map[string]struct{}, len(allowlist))
+	for _, id := range allowlist {
+		allowed[id] = struct{}{}
+	}
+	if len(allowlist) > 0 {
+		legacy = ""
+	}
+	return &guildTracker{
+		allowlist: allowlist,
+		allowed:   allowed,
+		legacy:    strings.TrimSpace(legacy),
+		chunks:    chunks,
+		requested: make(map[string]string),
+	}
+}
+
+// guildTrackerFromEnv reads GUILD_IDS and the legacy GUILD_ID.
+func guildTrackerFromEnv(chunks *lib.ChunkTracker) *guildTracker {
+	guilds := newGuildTracker(parseGuildIDs(os.Getenv("GUILD_IDS")), os.Getenv("GUILD_ID"), chunks)
+	if guilds.legacy != "" {
+		logging.Log.WithField("guild_id", guilds.legacy).Info("GUILD_ID set without GUILD_IDS: tracking every guild, requesting members from this one")
+	}
+	lib.SetGuildPriority(guilds.configured())
+	return guilds
+}
+
+// parseGuildIDs reads the GUILD_IDS allowlist. Order is preserved because it
+// defines merge priority.
+func parseGuildIDs(list string) []string {
+	var ids []string
+	for _, id := range strings.Split(list, ",") {
+		id = strings.TrimSpace(id)
+		if id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// configured returns the guilds named in the configuration: the allowlist,
+// or the legacy GUILD_ID.
+func (g *guildTracker) configured() []string {
+	if g.legacy != "" {
+		return []string{g.legacy}
+	}
+	return g.allowlist
+}
+
+// allows reports whether guildID passes the allowlist. An empty allowlist
+// tracks every guild the bot is in.
+func (g *guildTracker) allows(guildID string) bool {
+	if len(g.allowed) == 0 {
+		return true
+	}
+	_, ok := g.allowed[guildID]
+	return ok
+}
+
+// allowsEvent checks a raw dispatch's guild_id against the allowlist.
+// Events without a guild_id are not guild-scoped and always pass.
+func (g *guildTracker) allowsEvent(raw json.RawMessage) bool {
+	if len(g.allowed) == 0 || len(raw) == 0 {
+		return true
+	}
+	var probe struct {
+		GuildID string `json:"guild_id"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil || probe.GuildID == "" {
+		return true
+	}
+	return g.allows(probe.GuildID)
+}
+
+// forget clears the requested mark for a guild so the next requestMembers
+// call re-requests it even within the same session.
+func (g *guildTracker) forget(guildID string) {
+	g.mu.Lock()
+	delete(g.requested, guildID)
+	g.mu.Unlock()
+}
+
+// requestMembers asks the gateway for all members (with presences) of
+// guildID unless it is filtered out, is not the legacy GUILD_ID when one is
+// set, or was already requested this session.
+func (g *guildTracker) requestMembers(s *discordgo.Session, guildID string) {
+	if guildID == "" || !g.allows(guildID) || (g.legacy != "" && guildID != g.legacy) {
+		return
+	}
+	session := sessionID(s)
+	g.mu.Lock()
+	if prev, done := g.requested[guildID]; done && prev == session {
+		g.mu.Unlock()
+		return
+	}
+	g.requested[guildID] = session
+	g.mu.Unlock()
+
+	nonce := g.nextNonce()
+	g.chunks.Begin(guildID, nonce)
+	if err := s.RequestGuildMembers(guildID, "", 0, nonce, true); err != nil {
+		logging.Log.WithError(err).WithField("guild_id", guildID).Error("guild member request failed")
+		g.chunks.Cancel(guildID, nonce)
+		g.mu.Lock()
+		delete(g.requested, guildID)
+		g.mu.Unlock()
+		return
+	}
+	logging.Log.WithFields(logrus.Fields{"guild_id": guildID, "nonce": nonce}).Info("requested guild members")
+}
+
+// leave forgets a guild the bot was removed from: its pending member
+// request, chunk sequence and every membership tracked through it.
+func (g *guildTracker) leave(st *store.PresenceStore, guildID string) {
+	g.forget(guildID)
+	g.chunks.RemoveGuild(guildID)
+	removed := lib.RemoveGuild(st, guildID)
+	logging.Log.WithFields(logrus.Fields{"guild_id": guildID, "removed": removed}).Info("left guild")
+}
+
+// resync re-requests members for guildIDs regardless of earlier requests in
+// this session, e.g. after a RESUME when dispatches may have been lost.
+func (g *guildTracker) resync(s *discordgo.Session, guildIDs []string) {
+	for _, id := range guildIDs {
+		g.forget(id)
+		g.requestMembers(s, id)
+	}
+}
+
+// sessionID returns the gateway session a READY established for s.
+func sessionID(s *discordgo.Session) string {
+	if s == nil || s.State == nil {
+		return ""
+	}
+	s.State.RLock()
+	defer s.State.RUnlock()
+	return s.State.SessionID
+}
+
+// nextNonce returns a process-unique request nonce. Discord caps nonces at
+// 32 bytes, so it is a short base-36 counter rather than a UUID.
+func (g *guildTracker) nextNonce() string {
+	return "tether-" + strconv.FormatUint(g.nonces.Add(1), 36)
+}
@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"tether/src/logging"
+	"tether/src/store"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// kvCommand lets any tracked user manage the key/value metadata attached to
+// their public presence and issue an API key for the REST endpoint.
+var kvCommand = &discordgo.ApplicationCommand{
+	Name:        "kv",
+	Description: "Manage custom key/value data on your presence",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "set",
+			Description: "Set a key",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "key",
+					Description: "Letters, digits or underscores",
+					Required:    true,
+					MaxLength:   store.MaxKVKeyLength,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "value",
+					Description: "Value to store",
+					Required:    true,
+					MaxLength:   store.MaxKVValueLength,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "delete",
+			Description: "Delete a key",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "key",
+					Description: "Key to delete",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "List your keys",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "apikey",
+			Description: "Generate a new API key for the KV REST endpoint (revokes the old one)",
+		},
+	},
+}
+
+func handleKVCommand(s *discordgo.Session, ic *discordgo.InteractionCreate, st *store.PresenceStore, userID string) {
+	if st == nil || userID == "" {
+		respondEphemeral(s, ic, "The KV store is unavailable right now.")
+		return
+	}
+	data := ic.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return
+	}
+	sub := data.Options[0]
+	opts := make(map[string]string, len(sub.Options))
+	for _, opt := range sub.Options {
+		opts[opt.Name] = opt.StringValue()
+	}
+
+	switch sub.Name {
+	case "set":
+		if err := st.SetKV(userID, opts["key"], opts["value"]); err != nil {
+			respondEphemeral(s, ic, "Could not set key: "+err.Error())
+			return
+		}
+		logging.Log.WithFields(logrus.Fields{"user_id": userID, "key": opts["key"]}).Info("kv key set")
+		respondEphemeral(s, ic, fmt.Sprintf("Set `%s`.", opts["key"]))
+	case "delete":
+		if err := st.DeleteKV(userID, opts["key"]); err != nil {
+			respondEphemeral(s, ic, "Could not delete key: "+err.Error())
+			return
+		}
+		logging.Log.WithFields(logrus.Fields{"user_id": userID, "key": opts["key"]}).Info("kv key deleted")
+		respondEphemeral(s, ic, fmt.Sprintf("Deleted `%s`.", opts["key"]))
+	case "list":
+		respondEphemeral(s, ic, formatKV(st.GetKV(userID)))
+	case "apikey":
+		key, err := st.IssueAPIKey(userID)
+		if err != nil {
+			logging.Log.WithError(err).WithField("user_id", userID).Error("failed to issue api key")
+			respondEphemeral(s, ic, "Could not generate an API key.")
+			return
+		}
+		respondEphemeral(s, ic, fmt.Sprintf(
+			"Your API key (shown once, any previous key is revoked):\n`%s`\nUse it as the `Authorization` header for `PUT`/`DELETE /v1/users/%s/kv/{key}`.",
+			key, userID,
+		))
+	}
+}
+
+func formatKV(kv map[string]string) string {
+	if len(kv) == 0 {
+		return "You have no keys set."
+	}
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "`%s`: %s\n", k, kv[k])
+	}
+	return b.String()
+}
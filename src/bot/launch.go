@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -33,90 +34,182 @@ var (
 const rawLogLimit int32 = 3
 
 // Launch connects to Discord when a token is provided; otherwise it no-ops.
-// It wires PRESENCE_UPDATE and GUILD_MEMBER handlers to keep cached presence
+// It opens one session per configured shard (SHARD_COUNT / SHARD_IDS) and
+// wires PRESENCE_UPDATE and GUILD_MEMBER handlers to keep cached presence
 // and identity in sync, including guild-scoped fields like primary_guild.
 //
 //	st := store.NewPresenceStore()
-//	sess, _ := bot.Launch(os.Getenv("DISCORD_TOKEN"), st)
+//	shards, _ := bot.Launch(os.Getenv("DISCORD_TOKEN"), st)
+//	defer shards.Close()
 //
 // WebSocket server can subscribe to st.Subscribe() to broadcast updates.
-func Launch(token string, st *store.PresenceStore) (*discordgo.Session, error) {
+func Launch(token string, st *store.PresenceStore) (*ShardManager, error) {
+	return LaunchContext(context.Background(), token, st)
+}
+
+// LaunchContext is Launch, giving up when ctx ends before every shard is
+// open: shards opened so far are closed and ctx's error is returned. Opening
+// shards takes identifyInterval per shard, so a leader that loses its lease
+// meanwhile stops before opening more sessions.
+func LaunchContext(ctx context.Context, token string, st *store.PresenceStore) (*ShardManager, error) {
 	if token == "" {
 		logging.Log.Warn("discord bot disabled: DISCORD_TOKEN not set")
 		return nil, nil
 	}
 	startTime := time.Now()
 
-	guildID := os.Getenv("GUILD_ID")
+	chunks := lib.NewChunkTracker(st)
+	guilds := guildTrackerFromEnv(chunks)
 	adminIDs := parseAdminIDs(os.Getenv("ADMIN_USER_IDS"))
 
-	sess, err := discordgo.New("Bot " + token)
+	count, shardIDs, err := resolveShards(token, os.Getenv("SHARD_COUNT"), os.Getenv("SHARD_IDS"))
+	if err != nil {
+		logging.Log.WithError(err).Error("invalid shard configuration")
+		return nil, err
+	}
+	recorder, err := NewRecorder(os.Getenv("GATEWAY_RECORD"))
 	if err != nil {
-		logging.Log.WithError(err).Error("failed to create discord session")
+		logging.Log.WithError(err).Error("failed to open gateway recording")
 		return nil, err
 	}
+	mgr := &ShardManager{count: count, chunks: chunks, recorder: recorder}
+	lib.SetRoleResolver(mgr.resolveRole)
+
+	for i, shardID := range shardIDs {
+		sess, err := newSession(token)
+		if err != nil {
+			logging.Log.WithError(err).Error("failed to create discord session")
+			_ = mgr.Close()
+			return nil, err
+		}
+		sess.ShardID = shardID
+		sess.ShardCount = count
+		sess.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildPresences | discordgo.IntentsGuildMembers
+		// Request presence updates for all members when possible (requires privileged intents and a guild ID).
+		sess.State.TrackPresences = true
+
+		// Only the first shard in this process registers slash commands.
+		loop := &statusLoop{s: sess, st: st}
+		addHandlers(sess, st, guilds, mgr, loop, adminIDs, startTime, i == 0)
+		addReconnectHandlers(sess, loop, guilds)
+		mgr.addLoop(loop)
+
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(identifyInterval):
+			}
+		}
+		if ctx.Err() != nil {
+			_ = mgr.Close()
+			return nil, ctx.Err()
+		}
+		if err := sess.Open(); err != nil {
+			logging.Log.WithError(err).WithField("shard_id", shardID).Error("failed to open discord session")
+			_ = mgr.Close()
+			return nil, err
+		}
+		mgr.add(sess)
+
+		logging.Log.WithFields(logrus.Fields{"shard_id": shardID, "shard_count": count}).Info("discord shard connected")
+	}
+	if ctx.Err() != nil {
+		_ = mgr.Close()
+		return nil, ctx.Err()
+	}
 
-	sess.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildPresences | discordgo.IntentsGuildMembers
-	// Request presence updates for all members when possible (requires privileged intents and a guild ID).
-	sess.State.TrackPresences = true
+	logging.Log.WithField("shards", len(shardIDs)).Info("discord bot connected")
+	return mgr, nil
+}
 
+// addHandlers wires gateway and interaction handlers onto a shard session.
+func addHandlers(sess *discordgo.Session, st *store.PresenceStore, guilds *guildTracker, mgr *ShardManager, loop *statusLoop, adminIDs map[string]struct{}, startTime time.Time, registerCmds bool) {
 	sess.AddHandler(func(s *discordgo.Session, ev *discordgo.Event) {
 		if ev == nil {
 			return
 		}
-		switch ev.Type {
-		case "PRESENCE_UPDATE":
-			evPresenceUpdates.Add(1)
-			logGatewayEvent("PRESENCE_UPDATE", ev.RawData)
-			handleRawPresence(st, ev.RawData)
-		case "GUILD_MEMBER_ADD", "GUILD_MEMBER_UPDATE":
-			evMemberUpdates.Add(1)
-			logGatewayEvent(ev.Type, ev.RawData)
-			lib.MergeRawUser(st, ev.RawData)
-		case "GUILD_MEMBER_REMOVE":
-			logGatewayEvent(ev.Type, ev.RawData)
-			handleRawMemberRemove(st, ev.RawData)
-		case "GUILD_MEMBERS_CHUNK":
-			evChunkEvents.Add(1)
-			logGatewayEvent(ev.Type, ev.RawData)
-			lib.UpsertChunkPresences(st, ev.RawData)
+		if handleDispatch(st, guilds, ev.Type, ev.RawData) {
+			mgr.recorder.Record(s.ShardID, ev.Type, ev.RawData)
 		}
 	})
 
 	sess.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
 		logging.Log.WithFields(logrus.Fields{
-			"bot":    r.User.Username,
-			"guilds": len(r.Guilds),
+			"bot":      r.User.Username,
+			"guilds":   len(r.Guilds),
+			"shard_id": s.ShardID,
 		}).Info("bot ready")
-		if guildID != "" {
-			if err := s.RequestGuildMembers(guildID, "", 0, "", true); err != nil {
-				logging.Log.WithError(err).WithField("guild_id", guildID).Error("guild member request failed")
-			} else {
-				logging.Log.WithField("guild_id", guildID).Info("requested guild members")
-			}
+		// READY follows every fresh IDENTIFY, including reconnects that could
+		// not resume. Requests are keyed by session, so every guild is
+		// requested again unless its GUILD_CREATE handler got there first.
+		for _, id := range readyGuildIDs(s, r) {
+			guilds.requestMembers(s, id)
 		}
-		if err := registerCommands(s, guildID); err != nil {
-			logging.Log.WithError(err).Warn("failed to register commands")
+		if registerCmds {
+			if err := registerCommands(s, guilds.configured()); err != nil {
+				logging.Log.WithError(err).Warn("failed to register commands")
+			}
 		}
 		updateBotStatus(s, st)
-		recordLatencySample(s)
+		loop.start()
+	})
+
+	sess.AddHandler(func(s *discordgo.Session, g *discordgo.GuildCreate) {
+		if g == nil || g.Guild == nil {
+			return
+		}
+		guilds.requestMembers(s, g.ID)
 	})
 
-	sess.AddHandler(handleInteractions(st, adminIDs, startTime))
+	sess.AddHandler(handleInteractions(st, mgr, adminIDs, startTime))
+}
 
-	if err := sess.Open(); err != nil {
-		logging.Log.WithError(err).Error("failed to open discord session")
-		return nil, err
+// readyGuildIDs lists READY's guilds. The state cache shares those guild
+// structs and rewrites them as GUILD_CREATEs arrive, so they are read under
+// its lock.
+func readyGuildIDs(s *discordgo.Session, r *discordgo.Ready) []string {
+	if s.State != nil {
+		s.State.RLock()
+		defer s.State.RUnlock()
+	}
+	ids := make([]string, 0, len(r.Guilds))
+	for _, g := range r.Guilds {
+		ids = append(ids, g.ID)
 	}
+	return ids
+}
 
-	logging.Log.Info("discord bot connected")
-	stopLoop := startStatusAndLatencyLoop(sess, st)
-	sess.AddHandlerOnce(func(*discordgo.Session, *discordgo.Disconnect) {
-		if stopLoop != nil {
-			stopLoop()
+// handleDispatch routes one raw gateway dispatch to the store. It is shared
+// by live sessions and Replay, and reports whether the event was handled.
+func handleDispatch(st *store.PresenceStore, guilds *guildTracker, eventType string, raw json.RawMessage) bool {
+	if !guilds.allowsEvent(raw) {
+		return false
+	}
+	switch eventType {
+	case "PRESENCE_UPDATE":
+		evPresenceUpdates.Add(1)
+		logGatewayEvent(eventType, raw)
+		handleRawPresence(st, raw)
+	case "GUILD_MEMBER_ADD", "GUILD_MEMBER_UPDATE":
+		evMemberUpdates.Add(1)
+		logGatewayEvent(eventType, raw)
+		lib.MergeRawUser(st, raw)
+	case "GUILD_MEMBER_REMOVE":
+		logGatewayEvent(eventType, raw)
+		handleRawMemberRemove(st, raw)
+	case "GUILD_DELETE":
+		logGatewayEvent(eventType, raw)
+		handleRawGuildDelete(st, guilds, raw)
+	case "GUILD_MEMBERS_CHUNK":
+		evChunkEvents.Add(1)
+		logGatewayEvent(eventType, raw)
+		if info, ok := lib.UpsertChunkPresences(st, raw); ok {
+			guilds.chunks.Record(info)
 		}
-	})
-	return sess, nil
+	default:
+		return false
+	}
+	return true
 }
 
 // handleRawPresence builds a fresh presence snapshot from the raw Gateway payload and stores it.
@@ -138,7 +231,7 @@ func handleRawPresence(st *store.PresenceStore, raw json.RawMessage) {
 	}
 
 	if prev, exists := st.GetPresence(userID); exists {
-		presence.DiscordUser = lib.MergeDiscordUser(prev.DiscordUser, presence.DiscordUser)
+		presence = lib.MergePresenceIdentity(prev, presence)
 	}
 
 	st.SetPresence(userID, presence)
@@ -153,8 +246,21 @@ func handleRawMemberRemove(st *store.PresenceStore, raw json.RawMessage) {
 	if userID == "" {
 		return
 	}
-	st.RemovePresence(userID)
-	logging.Log.WithField("user_id", userID).Info("removed presence from member remove")
+	lib.RemoveGuildMember(st, utils.GetString(payload["guild_id"]), userID)
+}
+
+// handleRawGuildDelete stops tracking a guild the bot left or was removed
+// from. A GUILD_DELETE marked unavailable is an outage, not a removal, and
+// keeps the guild's members.
+func handleRawGuildDelete(st *store.PresenceStore, guilds *guildTracker, raw json.RawMessage) {
+	var payload struct {
+		ID          string `json:"id"`
+		Unavailable bool   `json:"unavailable"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil || payload.ID == "" || payload.Unavailable {
+		return
+	}
+	guilds.leave(st, payload.ID)
 }
 
 func logGatewayEvent(eventType string, raw json.RawMessage) {
@@ -204,7 +310,9 @@ func parseAdminIDs(env string) map[string]struct{} {
 	return admins
 }
 
-func registerCommands(s *discordgo.Session, guildID string) error {
+// registerCommands registers slash commands per configured guild for
+// instant availability, or globally when none is configured.
+func registerCommands(s *discordgo.Session, guildIDs []string) error {
 	if s == nil || s.State == nil || s.State.User == nil {
 		return fmt.Errorf("session not ready")
 	}
@@ -217,11 +325,14 @@ func registerCommands(s *discordgo.Session, guildID string) error {
 			Name:        "lag",
 			Description: "Show gateway latency",
 		},
+		privacyCommand,
+		kvCommand,
 	}
-	// If guildID is set, register as guild commands for instant availability
-	if guildID != "" {
-		if _, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, guildID, commands); err != nil {
-			return err
+	if len(guildIDs) > 0 {
+		for _, guildID := range guildIDs {
+			if _, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, guildID, commands); err != nil {
+				return fmt.Errorf("guild %s: %w", guildID, err)
+			}
 		}
 		return nil
 	}
@@ -229,7 +340,7 @@ func registerCommands(s *discordgo.Session, guildID string) error {
 	return err
 }
 
-func handleInteractions(st *store.PresenceStore, admins map[string]struct{}, start time.Time) func(*discordgo.Session, *discordgo.InteractionCreate) {
+func handleInteractions(st *store.PresenceStore, mgr *ShardManager, admins map[string]struct{}, start time.Time) func(*discordgo.Session, *discordgo.InteractionCreate) {
 	return func(s *discordgo.Session, ic *discordgo.InteractionCreate) {
 		if ic == nil || ic.Type != discordgo.InteractionApplicationCommand {
 			return
@@ -242,14 +353,19 @@ func handleInteractions(st *store.PresenceStore, admins map[string]struct{}, sta
 		} else if ic.User != nil {
 			userID = ic.User.ID
 		}
+
+		// Self-service commands are available to every user.
+		switch data.Name {
+		case "privacy":
+			handlePrivacyCommand(s, ic, st, userID)
+			return
+		case "kv":
+			handleKVCommand(s, ic, st, userID)
+			return
+		}
+
 		if _, ok := admins[userID]; !ok {
-			_ = s.InteractionRespond(ic.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Flags:   discordgo.MessageFlagsEphemeral,
-					Content: "Unauthorized",
-				},
-			})
+			respondEphemeral(s, ic, "Unauthorized")
 			return
 		}
 
@@ -265,35 +381,38 @@ func handleInteractions(st *store.PresenceStore, admins map[string]struct{}, sta
 				"Uptime: %s\nTracked members: %d\nGoroutines: %d\nHeap: %.2f MB\nSys: %.2f MB",
 				uptime, count, rt.goroutines, rt.heapMB, rt.sysMB,
 			)
-			_ = s.InteractionRespond(ic.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Flags:   discordgo.MessageFlagsEphemeral,
-					Content: content,
-				},
-			})
+			content += formatChunkProgress(mgr.Chunks())
+			respondEphemeral(s, ic, content)
 		case "lag":
-			lat := s.HeartbeatLatency().Round(time.Millisecond)
+			lat := heartbeatLatency(s).Round(time.Millisecond)
 			p99 := latencySamples.P99().Round(time.Millisecond)
 			apiP99 := middleware.APIP99().Round(time.Millisecond)
 			wsP99 := wsmetrics.MessageP99().Round(time.Millisecond)
-			_ = s.InteractionRespond(ic.Interaction, &discordgo.InteractionResponse{
-				Type: discordgo.InteractionResponseChannelMessageWithSource,
-				Data: &discordgo.InteractionResponseData{
-					Flags: discordgo.MessageFlagsEphemeral,
-					Content: fmt.Sprintf(
-						"Gateway: %d ms (p99: %d ms) | %d presence / %d member / %d chunk events\nHTTP: p99 %d ms | %d requests total\nWS send: p99 %d ms",
-						lat.Milliseconds(), p99.Milliseconds(),
-						evPresenceUpdates.Load(), evMemberUpdates.Load(), evChunkEvents.Load(),
-						apiP99.Milliseconds(), middleware.APIRequestCount(),
-						wsP99.Milliseconds(),
-					),
-				},
-			})
+			content := fmt.Sprintf(
+				"Gateway: %d ms (p99: %d ms) | %d presence / %d member / %d chunk events\nHTTP: p99 %d ms | %d requests total\nWS send: p99 %d ms | %d connections (%d rejected, %d oversized subscriptions)",
+				lat.Milliseconds(), p99.Milliseconds(),
+				evPresenceUpdates.Load(), evMemberUpdates.Load(), evChunkEvents.Load(),
+				apiP99.Milliseconds(), middleware.APIRequestCount(),
+				wsP99.Milliseconds(), wsmetrics.ActiveConnections(),
+				wsmetrics.RejectedConnections(), wsmetrics.RejectedSubscriptions(),
+			)
+			content += formatShardHealth(mgr)
+			respondEphemeral(s, ic, content)
 		}
 	}
 }
 
+// respondEphemeral replies to an interaction with a message only the caller sees.
+func respondEphemeral(s *discordgo.Session, ic *discordgo.InteractionCreate, content string) {
+	_ = s.InteractionRespond(ic.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: content,
+		},
+	})
+}
+
 type runtimeSnap struct {
 	goroutines int
 	heapMB     float64
@@ -337,12 +456,21 @@ func startStatusAndLatencyLoop(s *discordgo.Session, st *store.PresenceStore) fu
 
 // logMetrics emits a single structured log line with a full system snapshot.
 func logMetrics(s *discordgo.Session, st *store.PresenceStore) {
+	s.RLock()
+	ready := s.DataReady
+	s.RUnlock()
 	logging.Log.WithFields(logrus.Fields{
-		"gateway_latency_ms":  s.HeartbeatLatency().Round(time.Millisecond).Milliseconds(),
+		"shard_id":            s.ShardID,
+		"shard_ready":         ready,
+		"shard_p99_ms":        shardLatencyRing(s.ShardID).P99().Round(time.Millisecond).Milliseconds(),
+		"gateway_latency_ms":  heartbeatLatency(s).Round(time.Millisecond).Milliseconds(),
 		"gateway_p99_ms":      latencySamples.P99().Round(time.Millisecond).Milliseconds(),
 		"http_requests":       middleware.APIRequestCount(),
 		"http_p99_ms":         middleware.APIP99().Round(time.Millisecond).Milliseconds(),
 		"ws_send_p99_ms":      wsmetrics.MessageP99().Round(time.Millisecond).Milliseconds(),
+		"ws_connections":      wsmetrics.ActiveConnections(),
+		"ws_rejected_conns":   wsmetrics.RejectedConnections(),
+		"ws_rejected_subs":    wsmetrics.RejectedSubscriptions(),
 		"tracked_presences":   st.Count(),
 		"ev_presence_updates": evPresenceUpdates.Load(),
 		"ev_member_updates":   evMemberUpdates.Load(),
@@ -350,13 +478,28 @@ func logMetrics(s *discordgo.Session, st *store.PresenceStore) {
 	}).Info("metrics snapshot")
 }
 
+// recordLatencySample is only called from the status loop, never from
+// gateway handlers, which run while a reconnect may be rewriting the
+// session's heartbeat fields.
 func recordLatencySample(s *discordgo.Session) {
 	if s == nil {
 		return
 	}
-	lat := s.HeartbeatLatency()
+	lat := heartbeatLatency(s)
 	if lat <= 0 {
 		return
 	}
 	latencySamples.Record(lat)
+	shardLatencyRing(s.ShardID).Record(lat)
+}
+
+// heartbeatLatency reads the session's heartbeat latency under its lock,
+// which discordgo holds while recording acks (and while reconnecting). The
+// send time is written under a lock discordgo does not export, so a sample
+// can still overlap a heartbeat; the status loop samples rarely enough for
+// that to be harmless.
+func heartbeatLatency(s *discordgo.Session) time.Duration {
+	s.RLock()
+	defer s.RUnlock()
+	return s.HeartbeatLatency()
 }
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"tether/src/logging"
+	"tether/src/store"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// privacyCommand lets any tracked user opt out of parts of their public
+// presence. Unlike the admin commands it is available to everyone.
+var privacyCommand = &discordgo.ApplicationCommand{
+	Name:        "privacy",
+	Description: "Control what Tether shares about you",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "show",
+			Description: "Show your current privacy settings",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "set",
+			Description: "Hide or reveal part of your presence",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "setting",
+					Description: "What to hide",
+					Required:    true,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "Everything (hide me entirely)", Value: "hidden"},
+						{Name: "Activities", Value: "activities"},
+						{Name: "Spotify", Value: "spotify"},
+						{Name: "Client platforms", Value: "clients"},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "hide",
+					Description: "True to hide, false to share again",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "reset",
+			Description: "Share everything again",
+		},
+	},
+}
+
+func handlePrivacyCommand(s *discordgo.Session, ic *discordgo.InteractionCreate, st *store.PresenceStore, userID string) {
+	if st == nil || userID == "" {
+		respondEphemeral(s, ic, "Privacy settings are unavailable right now.")
+		return
+	}
+	data := ic.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return
+	}
+	sub := data.Options[0]
+	settings := st.GetPrivacy(userID)
+
+	switch sub.Name {
+	case "show":
+		respondEphemeral(s, ic, formatPrivacy(settings))
+		return
+	case "reset":
+		settings = store.PrivacySettings{}
+	case "set":
+		var setting string
+		var hide bool
+		for _, opt := range sub.Options {
+			switch opt.Name {
+			case "setting":
+				setting = opt.StringValue()
+			case "hide":
+				hide = opt.BoolValue()
+			}
+		}
+		switch setting {
+		case "hidden":
+			settings.Hidden = hide
+		case "activities":
+			settings.HideActivities = hide
+		case "spotify":
+			settings.HideSpotify = hide
+		case "clients":
+			settings.HideClients = hide
+		default:
+			respondEphemeral(s, ic, "Unknown privacy setting.")
+			return
+		}
+	default:
+		return
+	}
+
+	st.SetPrivacy(userID, settings)
+	logging.Log.WithFields(logrus.Fields{
+		"user_id": userID,
+		"command": sub.Name,
+	}).Info("privacy settings updated")
+	respondEphemeral(s, ic, "Privacy settings updated.\n"+formatPrivacy(settings))
+}
+
+func formatPrivacy(p store.PrivacySettings) string {
+	if p.IsZero() {
+		return "You are sharing your full presence."
+	}
+	if p.Hidden {
+		return "You are hidden: Tether does not share any of your presence."
+	}
+	var hidden []string
+	if p.HideActivities {
+		hidden = append(hidden, "activities")
+	}
+	if p.HideSpotify {
+		hidden = append(hidden, "Spotify")
+	}
+	if p.HideClients {
+		hidden = append(hidden, "client platforms")
+	}
+	return fmt.Sprintf("Hidden: %s", strings.Join(hidden, ", "))
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"sync"
+
+	"tether/src/logging"
+	"tether/src/store"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// statusLoop owns a shard's status/latency loop so it can be stopped on
+// Disconnect and started again once the session is back (READY or RESUMED).
+type statusLoop struct {
+	s  *discordgo.Session
+	st *store.PresenceStore
+
+	mu   sync.Mutex
+	stop func()
+}
+
+// start runs the loop unless it is already running.
+func (l *statusLoop) start() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stop != nil {
+		return
+	}
+	l.stop = startStatusAndLatencyLoop(l.s, l.st)
+}
+
+// halt stops the loop if it is running.
+func (l *statusLoop) halt() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stop != nil {
+		l.stop()
+		l.stop = nil
+	}
+}
+
+// addReconnectHandlers keeps the status loop and presence cache in sync with
+// the gateway connection. A RESUMED session re-requests members for every
+// guild it holds because dispatches may have been lost while disconnected; a
+// fresh READY starts a new session, which re-requests every guild anyway.
+func addReconnectHandlers(sess *discordgo.Session, loop *statusLoop, guilds *guildTracker) {
+	sess.AddHandler(func(s *discordgo.Session, _ *discordgo.Disconnect) {
+		logging.Log.WithField("shard_id", s.ShardID).Warn("discord shard disconnected")
+		loop.halt()
+	})
+
+	sess.AddHandler(func(s *discordgo.Session, _ *discordgo.Resumed) {
+		ids := stateGuildIDs(s)
+		logging.Log.WithFields(logrus.Fields{
+			"shard_id": s.ShardID,
+			"guilds":   len(ids),
+		}).Info("discord shard resumed, resyncing members")
+		loop.start()
+		guilds.resync(s, ids)
+	})
+}
+
+// stateGuildIDs lists the guilds currently cached for a session.
+func stateGuildIDs(s *discordgo.Session) []string {
+	if s == nil || s.State == nil {
+		return nil
+	}
+	s.State.RLock()
+	defer s.State.RUnlock()
+	ids := make([]string, 0, len(s.State.Guilds))
+	for _, g := range s.State.Guilds {
+		ids = append(ids, g.ID)
+	}
+	return ids
+}
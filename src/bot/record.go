@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"tether/src/lib"
+	"tether/src/logging"
+	"tether/src/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RecordedEvent is one line of a gateway recording (JSON Lines).
+type RecordedEvent struct {
+	At      time.Time       `json:"at"`
+	ShardID int             `json:"shard_id"`
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"d"`
+}
+
+// Recorder appends every handled gateway dispatch to a file so it can be
+// fed back through Replay. A nil *Recorder is valid and records nothing.
+type Recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewRecorder opens path for appending. An empty path disables recording
+// and returns a nil recorder.
+func NewRecorder(path string) (*Recorder, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	logging.Log.WithField("path", path).Info("recording gateway traffic")
+	return &Recorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record writes one dispatch with the current time.
+func (r *Recorder) Record(shardID int, eventType string, raw json.RawMessage) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return
+	}
+	ev := RecordedEvent{At: time.Now(), ShardID: shardID, Type: eventType, Data: raw}
+	if err := r.enc.Encode(ev); err != nil {
+		logging.Log.WithError(err).WithField("event", eventType).Warn("failed to record gateway event")
+	}
+}
+
+// Close stops recording and closes the file.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// Replay feeds a recording made with GATEWAY_RECORD through the same
+// handlers as a live session, without connecting to Discord. speed scales
+// the recorded gaps between events: 1 replays in real time, 10 ten times
+// faster, and 0 (or less) as fast as possible. The GUILD_IDS allowlist
+// applies as it would live. Replay returns when the recording is exhausted
+// or ctx is cancelled.
+func Replay(ctx context.Context, path string, st *store.PresenceStore, speed float64) error {
+	guilds := newReplayGuildTracker(st)
+	start := time.Now()
+	var first time.Time
+	var replayed, skipped int
+	err := eachRecordedEvent(path, func(ev RecordedEvent) error {
+		if first.IsZero() {
+			first = ev.At
+		}
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(ev.At.Sub(first)) / speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		if handleDispatch(st, guilds, ev.Type, ev.Data) {
+			replayed++
+		} else {
+			skipped++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logging.Log.WithFields(logrus.Fields{
+		"path":     path,
+		"events":   replayed,
+		"skipped":  skipped,
+		"duration": time.Since(start).Round(time.Millisecond).String(),
+		"members":  st.Count(),
+	}).Info("gateway replay finished")
+	return nil
+}
+
+// replayEventBuffer bounds the presence events one recorded dispatch may
+// produce in ReplayPresenceEvents (a member chunk yields one per member).
+const replayEventBuffer = 1 << 16
+
+// ReplayPresenceEvents feeds a recording through the live handlers into a
+// fresh store, as fast as possible, and calls fn with every presence event
+// that results. Each event carries the recorded time of the dispatch that
+// caused it, so time-based logic (such as rule dry runs) sees the original
+// timeline.
+func ReplayPresenceEvents(path string, fn func(evt store.PresenceEvent, at time.Time)) error {
+	st := store.NewPresenceStore()
+	_, events, unsubscribe := st.SubscribeBuffered(replayEventBuffer)
+	defer unsubscribe()
+	guilds := newReplayGuildTracker(st)
+	return eachRecordedEvent(path, func(ev RecordedEvent) error {
+		handleDispatch(st, guilds, ev.Type, ev.Data)
+		for {
+			select {
+			case evt := <-events:
+				fn(evt, ev.At)
+			default:
+				return nil
+			}
+		}
+	})
+}
+
+// newReplayGuildTracker applies the GUILD_IDS allowlist to a replay as it
+// would to a live session.
+func newReplayGuildTracker(st *store.PresenceStore) *guildTracker {
+	return guildTrackerFromEnv(lib.NewChunkTracker(st))
+}
+
+// eachRecordedEvent decodes the recording at path and calls fn for every
+// event in order, stopping at the first error.
+func eachRecordedEvent(path string, fn func(RecordedEvent) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for n := 0; ; n++ {
+		var ev RecordedEvent
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read recording after %d events: %w", n, err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tether/src/lib"
+	"tether/src/store"
+	"tether/src/utils"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// identifyInterval spaces out shard IDENTIFYs to respect Discord's
+// max_concurrency=1 session start limit.
+const identifyInterval = 5 * time.Second
+
+// shardLatency holds a latency ring per shard ID, alongside the aggregate
+// latencySamples ring.
+var shardLatency sync.Map // int -> *utils.LatencyRing
+
+// ShardManager owns one discordgo session per gateway shard handled by this
+// process. A nil *ShardManager is valid and represents a disabled bot.
+type ShardManager struct {
+	count    int
+	chunks   *lib.ChunkTracker
+	recorder *Recorder
+	mu       sync.Mutex
+	sessions []*discordgo.Session
+	loops    []*statusLoop
+}
+
+// ShardHealth is a point-in-time view of a single shard.
+type ShardHealth struct {
+	ShardID    int
+	Ready      bool
+	Latency    time.Duration
+	LatencyP99 time.Duration
+	LastAck    time.Time
+}
+
+// Count returns the total shard count used for IDENTIFY.
+func (m *ShardManager) Count() int {
+	if m == nil {
+		return 0
+	}
+	return m.count
+}
+
+// Chunks returns the member chunk tracker shared by every shard.
+func (m *ShardManager) Chunks() *lib.ChunkTracker {
+	if m == nil {
+		return nil
+	}
+	return m.chunks
+}
+
+// Sessions returns the sessions owned by this manager.
+func (m *ShardManager) Sessions() []*discordgo.Session {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*discordgo.Session(nil), m.sessions...)
+}
+
+// SendMessage posts content to a Discord channel through the first session.
+// It backs rule engine discord actions.
+func (m *ShardManager) SendMessage(channelID, content string) error {
+	sessions := m.Sessions()
+	if len(sessions) == 0 {
+		return errors.New("discord bot is not connected")
+	}
+	_, err := sessions[0].ChannelMessageSend(channelID, content)
+	return err
+}
+
+// resolveRole looks a guild role up in the state of whichever shard holds
+// the guild. It backs lib.SetRoleResolver.
+func (m *ShardManager) resolveRole(guildID, roleID string) (store.GuildRole, bool) {
+	for _, s := range m.Sessions() {
+		if role, err := s.State.Role(guildID, roleID); err == nil {
+			return store.GuildRole{ID: role.ID, Name: role.Name, Color: role.Color}, true
+		}
+	}
+	return store.GuildRole{}, false
+}
+
+func (m *ShardManager) addLoop(loop *statusLoop) {
+	m.mu.Lock()
+	m.loops = append(m.loops, loop)
+	m.mu.Unlock()
+}
+
+func (m *ShardManager) add(sess *discordgo.Session) {
+	m.mu.Lock()
+	m.sessions = append(m.sessions, sess)
+	m.mu.Unlock()
+}
+
+// Health reports readiness and latency for every shard.
+func (m *ShardManager) Health() []ShardHealth {
+	sessions := m.Sessions()
+	out := make([]ShardHealth, 0, len(sessions))
+	for _, s := range sessions {
+		h := ShardHealth{ShardID: s.ShardID}
+		s.RLock()
+		h.Latency = s.HeartbeatLatency()
+		h.Ready = s.DataReady
+		h.LastAck = s.LastHeartbeatAck
+		s.RUnlock()
+		h.LatencyP99 = shardLatencyRing(s.ShardID).P99()
+		out = append(out, h)
+	}
+	return out
+}
+
+// shardLatencyRing returns (creating on first use) the latency ring for a shard.
+func shardLatencyRing(shardID int) *utils.LatencyRing {
+	ring, _ := shardLatency.LoadOrStore(shardID, &utils.LatencyRing{})
+	return ring.(*utils.LatencyRing)
+}
+
+// formatShardHealth renders one line per shard for the /lag command.
+func formatShardHealth(m *ShardManager) string {
+	health := m.Health()
+	if len(health) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nShards (%d total):", m.Count())
+	for _, h := range health {
+		state := "ready"
+		if !h.Ready {
+			state = "not ready"
+		}
+		fmt.Fprintf(&b, "\n  #%d %s: %d ms (p99: %d ms)",
+			h.ShardID, state,
+			h.Latency.Round(time.Millisecond).Milliseconds(),
+			h.LatencyP99.Round(time.Millisecond).Milliseconds(),
+		)
+	}
+	return b.String()
+}
+
+// formatChunkProgress summarises member sync state for the /status command.
+func formatChunkProgress(t *lib.ChunkTracker) string {
+	progress := t.Progress()
+	if len(progress) == 0 {
+		return ""
+	}
+	done := 0
+	var b strings.Builder
+	for _, p := range progress {
+		if p.Complete {
+			done++
+			continue
+		}
+		fmt.Fprintf(&b, "\n  %s: %d/%d chunks, %d members", p.GuildID, p.Received, p.Expected, p.Members)
+	}
+	return fmt.Sprintf("\nMember sync: %d/%d guilds complete", done, len(progress)) + b.String()
+}
+
+// Close stops every shard's status loop, shuts down every shard, finishes
+// any gateway recording and returns the joined close errors.
+func (m *ShardManager) Close() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	loops := append([]*statusLoop(nil), m.loops...)
+	m.mu.Unlock()
+	for _, loop := range loops {
+		loop.halt()
+	}
+	var errs []error
+	for _, s := range m.Sessions() {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("shard %d: %w", s.ShardID, err))
+		}
+	}
+	if err := m.recorder.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("gateway recording: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// resolveShards turns SHARD_COUNT and SHARD_IDS into the total shard count
+// and the IDs this process should run. SHARD_COUNT may be a number or
+// "auto" to use Discord's recommended count; SHARD_IDS accepts a
+// comma-separated list with ranges ("0,2-3") and defaults to all shards.
+func resolveShards(token, countEnv, idsEnv string) (int, []int, error) {
+	countEnv = strings.TrimSpace(countEnv)
+	count := 1
+	switch {
+	case countEnv == "":
+	case strings.EqualFold(countEnv, "auto"):
+		probe, err := newSession(token)
+		if err != nil {
+			return 0, nil, err
+		}
+		gw, err := probe.GatewayBot()
+		if err != nil {
+			return 0, nil, fmt.Errorf("fetch recommended shard count: %w", err)
+		}
+		count = max(gw.Shards, 1)
+	default:
+		n, err := strconv.Atoi(countEnv)
+		if err != nil || n < 1 {
+			return 0, nil, fmt.Errorf("invalid SHARD_COUNT %q", countEnv)
+		}
+		count = n
+	}
+
+	ids, err := parseShardIDs(idsEnv, count)
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, ids, nil
+}
+
+func parseShardIDs(spec string, count int) ([]int, error) {
+	if strings.TrimSpace(spec) == "" {
+		ids := make([]int, count)
+		for i := range ids {
+			ids[i] = i
+		}
+		return ids, nil
+	}
+	seen := make(map[int]struct{})
+	var ids []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi := part, part
+		if a, b, ok := strings.Cut(part, "-"); ok {
+			lo, hi = a, b
+		}
+		start, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		end, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil || start < 0 || end < start || end >= count {
+			return nil, fmt.Errorf("invalid SHARD_IDS entry %q for %d shards", part, count)
+		}
+		for id := start; id <= end; id++ {
+			if _, dup := seen[id]; !dup {
+				seen[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("SHARD_IDS %q selects no shards", spec)
+	}
+	return ids, nil
+}
@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"slices"
+	"testing"
+
+	"tether/src/fakegateway"
+)
+
+func TestParseShardIDs(t *testing.T) {
+	cases := []struct {
+		spec  string
+		count int
+		want  []int
+		err   bool
+	}{
+		{spec: "", count: 3, want: []int{0, 1, 2}},
+		{spec: "  ", count: 2, want: []int{0, 1}},
+		{spec: "0-3,5", count: 6, want: []int{0, 1, 2, 3, 5}},
+		{spec: " 1 , 2 - 3 ", count: 4, want: []int{1, 2, 3}},
+		{spec: "2,0-2,2", count: 3, want: []int{2, 0, 1}},
+		{spec: "4", count: 4, err: true},
+		{spec: "0-4", count: 4, err: true},
+		{spec: "-1", count: 4, err: true},
+		{spec: "3-1", count: 4, err: true},
+		{spec: "a", count: 4, err: true},
+		{spec: ", ,", count: 4, err: true},
+	}
+	for _, c := range cases {
+		got, err := parseShardIDs(c.spec, c.count)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseShardIDs(%q, %d) = %v, want an error", c.spec, c.count, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, c.want) {
+			t.Errorf("parseShardIDs(%q, %d) = %v, %v, want %v", c.spec, c.count, got, err, c.want)
+		}
+	}
+}
+
+func TestResolveShards(t *testing.T) {
+	gw := fakegateway.New()
+	gw.Shards = 4
+	t.Cleanup(gw.Close)
+	t.Setenv("DISCORD_API_URL", gw.URL)
+	t.Setenv("DISCORD_GATEWAY_URL", "")
+
+	cases := []struct {
+		count, ids string
+		wantCount  int
+		wantIDs    []int
+		err        bool
+	}{
+		{wantCount: 1, wantIDs: []int{0}},
+		{count: "2", wantCount: 2, wantIDs: []int{0, 1}},
+		{count: "auto", wantCount: 4, wantIDs: []int{0, 1, 2, 3}},
+		{count: " AUTO ", ids: "1-2", wantCount: 4, wantIDs: []int{1, 2}},
+		{count: "0", err: true},
+		{count: "-2", err: true},
+		{count: "many", err: true},
+		{count: "2", ids: "2", err: true},
+	}
+	for _, c := range cases {
+		count, ids, err := resolveShards("fake-token", c.count, c.ids)
+		if c.err {
+			if err == nil {
+				t.Errorf("resolveShards(%q, %q) = %d, %v, want an error", c.count, c.ids, count, ids)
+			}
+			continue
+		}
+		if err != nil || count != c.wantCount || !slices.Equal(ids, c.wantIDs) {
+			t.Errorf("resolveShards(%q, %q) = %d, %v, %v, want %d, %v", c.count, c.ids, count, ids, err, c.wantCount, c.wantIDs)
+		}
+	}
+}
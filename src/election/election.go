@@ -0,0 +1,57 @@
+// Package election picks which of several Tether nodes holds the Discord
+// session. Every other node follows the leader's replication stream, and
+// takes over when the leader goes away.
+//
+// Two backends are provided: FileLock, for nodes on one host sharing a lock
+// file, and HTTPLease, where peers grant each other time-limited leases over
+// HTTP and a node leads while a majority of the cluster has granted it one.
+package election
+
+import (
+	"context"
+	"time"
+)
+
+// State is a node's view of the election.
+type State struct {
+	// Leader is true while this node leads.
+	Leader bool `json:"leader"`
+	// LeaderID and LeaderURL identify the current leader, when known.
+	LeaderID  string `json:"leader_id,omitempty"`
+	LeaderURL string `json:"leader_url,omitempty"`
+}
+
+// Elector is a leader election backend.
+type Elector interface {
+	// Run campaigns until ctx is cancelled, calling onChange from a single
+	// goroutine whenever the state changes. onChange should return quickly,
+	// as leadership is not renewed while it runs. Leadership is released
+	// before Run returns.
+	Run(ctx context.Context, onChange func(State))
+}
+
+// reporter calls onChange only when the state differs from the last one.
+type reporter struct {
+	onChange func(State)
+	last     State
+}
+
+func (r *reporter) report(s State) {
+	if s == r.last {
+		return
+	}
+	r.last = s
+	r.onChange(s)
+}
+
+// sleep waits for d, reporting false if ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,99 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tether/src/logging"
+)
+
+// FileLock elects the node holding an exclusive lock on a file. The lock is
+// released by the operating system when the holder exits, so it only works
+// between processes on one host (or a file system with working flock).
+type FileLock struct {
+	// Path is the lock file. It is created if missing.
+	Path string
+	// NodeID and URL are written into the file while this node leads, so
+	// the others know whom to follow.
+	NodeID string
+	URL    string
+	// Interval is how often a follower retries the lock (default 1s).
+	Interval time.Duration
+}
+
+// holder is the lock file's contents.
+type holder struct {
+	NodeID string `json:"node_id"`
+	URL    string `json:"url"`
+}
+
+// Run implements Elector.
+func (l FileLock) Run(ctx context.Context, onChange func(State)) {
+	interval := l.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	rep := &reporter{onChange: onChange}
+	for {
+		f, err := l.tryLock()
+		if err != nil {
+			logging.Log.WithError(err).WithField("path", l.Path).Warn("failed to take the leader lock")
+		}
+		if f != nil {
+			l.lead(ctx, f, rep)
+			return
+		}
+		if data, err := os.ReadFile(l.Path); err == nil {
+			var h holder
+			// The leader may be writing; a partial file is read next time.
+			if json.Unmarshal(data, &h) == nil && h.NodeID != "" {
+				rep.report(State{LeaderID: h.NodeID, LeaderURL: h.URL})
+			}
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// lead holds the lock until ctx ends.
+func (l FileLock) lead(ctx context.Context, f *os.File, rep *reporter) {
+	defer f.Close()
+	data, _ := json.Marshal(holder{NodeID: l.NodeID, URL: l.URL})
+	if err := f.Truncate(0); err == nil {
+		_, err = f.WriteAt(data, 0)
+		if err == nil {
+			err = f.Sync()
+		}
+		if err != nil {
+			logging.Log.WithError(err).Warn("failed to write the leader lock file")
+		}
+	}
+	rep.report(State{Leader: true, LeaderID: l.NodeID, LeaderURL: l.URL})
+	<-ctx.Done()
+	// Clear the file so followers stop pointing at this node; the lock
+	// itself is released by closing it.
+	_ = f.Truncate(0)
+	_ = unlockFile(f)
+}
+
+// tryLock opens the lock file and takes the lock without blocking. It
+// returns a nil file if another process holds it.
+func (l FileLock) tryLock() (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := lockFile(f)
+	if err != nil || !ok {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
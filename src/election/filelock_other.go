@@ -0,0 +1,18 @@
+//go:build !unix
+
+package election
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoFileLock = errors.New("file lock election is not supported on this platform")
+
+func lockFile(*os.File) (bool, error) {
+	return false, errNoFileLock
+}
+
+func unlockFile(*os.File) error {
+	return errNoFileLock
+}
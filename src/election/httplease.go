@@ -0,0 +1,334 @@
+package election
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"tether/src/logging"
+	"tether/src/utils"
+)
+
+// LeasePath is where an HTTPLease's handler is mounted.
+const LeasePath = "/v1/election/lease"
+
+// HTTPLeaseConfig configures an HTTPLease.
+type HTTPLeaseConfig struct {
+	// NodeID and URL identify this node; URL is the base URL peers and
+	// followers reach it at.
+	NodeID string
+	URL    string
+	// Peers are the base URLs of the other nodes. Every node must list the
+	// same cluster.
+	Peers []string
+	// Token is the shared secret peers present as a bearer token.
+	Token string
+	// TTL is how long a granted lease lasts (default 10s). Leaders renew
+	// every TTL/3 and step down 3/4 of a TTL after their last renewal
+	// began; peers grant the lease to another node only TTL/4 after it
+	// expires.
+	TTL time.Duration
+	// Client sends lease requests (default: a client with a TTL/3 timeout).
+	Client *http.Client
+}
+
+// Lease is a node's grant of leadership to a candidate.
+type Lease struct {
+	NodeID string `json:"node_id"`
+	URL    string `json:"url"`
+	// ExpiresInMS is how long the lease has left, so peers need not agree
+	// on the time. It is 0 during the grace period after it expires.
+	ExpiresInMS int64 `json:"expires_in_ms"`
+
+	expires time.Time
+}
+
+// leaseRequest is the body of POST and DELETE requests.
+type leaseRequest struct {
+	NodeID string `json:"node_id"`
+	URL    string `json:"url"`
+}
+
+// HTTPLease elects the node holding leases from a majority of the cluster.
+// Each node grants one lease at a time, to the first candidate that asks
+// once the previous lease has expired or been released, so at most one node
+// can hold a majority. A leader that cannot renew with a majority steps down
+// well before its leases run out, and peers wait a grace period after they
+// do, so a slow or paused old leader has stopped before a new one starts.
+// Clusters need at least three nodes to survive the loss of one.
+type HTTPLease struct {
+	cfg HTTPLeaseConfig
+
+	mu    sync.Mutex
+	lease *Lease
+}
+
+// NewHTTPLease creates a lease elector. Mount it at LeasePath for GET, POST
+// and DELETE.
+func NewHTTPLease(cfg HTTPLeaseConfig) *HTTPLease {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 10 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.TTL / 3}
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	peers := make([]string, 0, len(cfg.Peers))
+	for _, p := range cfg.Peers {
+		if p = strings.TrimSuffix(strings.TrimSpace(p), "/"); p != "" {
+			peers = append(peers, p)
+		}
+	}
+	cfg.Peers = peers
+	return &HTTPLease{cfg: cfg}
+}
+
+// Run implements Elector.
+func (e *HTTPLease) Run(ctx context.Context, onChange func(State)) {
+	rep := &reporter{onChange: onChange}
+	interval := e.cfg.TTL / 3
+	defer e.releaseAll()
+
+	var leaseUntil time.Time
+	for {
+		leading := time.Now().Before(leaseUntil)
+		if !leading {
+			if rep.last.Leader {
+				logging.Log.Warn("leader lease expired without renewal; stepping down")
+				rep.report(State{})
+			}
+			if cur, ok := e.current(); ok && cur.NodeID != e.cfg.NodeID {
+				rep.report(State{LeaderID: cur.NodeID, LeaderURL: cur.URL})
+				if !sleep(ctx, interval) {
+					return
+				}
+				continue
+			}
+			// Campaign after a random delay so candidates that start
+			// together do not keep splitting the vote.
+			if !sleep(ctx, rand.N(interval)) {
+				return
+			}
+			if cur, ok := e.current(); ok && cur.NodeID != e.cfg.NodeID {
+				continue
+			}
+		}
+
+		start := time.Now()
+		deadline := start.Add(interval)
+		if leading && leaseUntil.Before(deadline) {
+			// A renewal must settle while the current leases last.
+			deadline = leaseUntil
+		}
+		reqCtx, cancel := context.WithDeadline(ctx, deadline)
+		granted, other := e.requestAll(reqCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case len(granted)*2 > len(e.cfg.Peers)+1:
+			// Peers count their leases from when they received the
+			// request, so this ends a quarter TTL before any of them
+			// expire, and half a TTL before another node can win.
+			leaseUntil = start.Add(e.cfg.TTL - e.cfg.TTL/4)
+			rep.report(State{Leader: true, LeaderID: e.cfg.NodeID, LeaderURL: e.cfg.URL})
+		case !leading:
+			// Give partial grants back so another candidate can win.
+			e.release(granted)
+			rep.report(State{LeaderID: other.NodeID, LeaderURL: other.URL})
+		}
+		wait := interval
+		if d := time.Until(leaseUntil); d > 0 && d < wait {
+			wait = d
+		}
+		if !sleep(ctx, wait) {
+			return
+		}
+	}
+}
+
+// ServeHTTP answers peers: GET returns the lease this node has granted,
+// POST asks for (or renews) a lease and DELETE releases one.
+func (e *HTTPLease) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if e.cfg.Token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(e.cfg.Token)) != 1 {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.ErrorResponse(
+			"UNAUTHORIZED",
+			"A valid replication token is required",
+			http.StatusUnauthorized,
+			false,
+			nil,
+		))
+		return
+	}
+	if r.Method == http.MethodGet {
+		cur, ok := e.current()
+		var lease *Lease
+		if ok {
+			lease = &cur
+		}
+		utils.WriteJSON(w, http.StatusOK, utils.SuccessResponse(map[string]any{"node_id": e.cfg.NodeID, "lease": lease}))
+		return
+	}
+
+	var req leaseRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.NodeID == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.ErrorResponse(
+			"INVALID_LEASE_REQUEST",
+			"Lease requests need a JSON body with node_id",
+			http.StatusBadRequest,
+			false,
+			nil,
+		))
+		return
+	}
+	if r.Method == http.MethodDelete {
+		e.releaseLocal(req.NodeID)
+		utils.WriteJSON(w, http.StatusOK, utils.SuccessResponse(map[string]any{"released": true}))
+		return
+	}
+	lease, granted := e.grant(req)
+	utils.WriteJSON(w, http.StatusOK, utils.SuccessResponse(map[string]any{"granted": granted, "lease": lease}))
+}
+
+// grace is how long after a lease expires this node still refuses to grant
+// it to another candidate.
+func (e *HTTPLease) grace() time.Duration {
+	return e.cfg.TTL / 4
+}
+
+// current returns the lease this node has granted, if any, until its grace
+// period ends.
+func (e *HTTPLease) current() (Lease, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lease == nil || !time.Now().Before(e.lease.expires.Add(e.grace())) {
+		return Lease{}, false
+	}
+	out := *e.lease
+	out.ExpiresInMS = max(time.Until(out.expires).Milliseconds(), 0)
+	return out, true
+}
+
+// grant gives req the lease unless another node holds it or held it within
+// the grace period. It returns the lease in force afterwards.
+func (e *HTTPLease) grant(req leaseRequest) (Lease, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	granted := e.lease == nil || !now.Before(e.lease.expires.Add(e.grace())) || e.lease.NodeID == req.NodeID
+	if granted {
+		e.lease = &Lease{NodeID: req.NodeID, URL: req.URL, expires: now.Add(e.cfg.TTL)}
+	}
+	out := *e.lease
+	out.ExpiresInMS = max(out.expires.Sub(now).Milliseconds(), 0)
+	return out, granted
+}
+
+func (e *HTTPLease) releaseLocal(nodeID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lease != nil && e.lease.NodeID == nodeID {
+		e.lease = nil
+	}
+}
+
+// requestAll asks this node and every peer for a lease. It returns the
+// nodes that granted one ("" for this node) and a lease held by another
+// node, if any refused.
+func (e *HTTPLease) requestAll(ctx context.Context) ([]string, Lease) {
+	req := leaseRequest{NodeID: e.cfg.NodeID, URL: e.cfg.URL}
+	var (
+		mu      sync.Mutex
+		granted []string
+		other   Lease
+		wg      sync.WaitGroup
+	)
+	record := func(peer string, lease Lease, ok bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ok {
+			granted = append(granted, peer)
+		} else if other.NodeID == "" {
+			other = lease
+		}
+	}
+	lease, ok := e.grant(req)
+	record("", lease, ok)
+
+	for _, peer := range e.cfg.Peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out struct {
+				Data struct {
+					Granted bool  `json:"granted"`
+					Lease   Lease `json:"lease"`
+				} `json:"data"`
+			}
+			if err := e.call(ctx, http.MethodPost, peer, req, &out); err != nil {
+				logging.Log.WithError(err).WithField("peer", peer).Debug("lease request failed")
+				return
+			}
+			record(peer, out.Data.Lease, out.Data.Granted)
+		}()
+	}
+	wg.Wait()
+	return granted, other
+}
+
+// release gives back the leases granted by peers ("" for this node).
+func (e *HTTPLease) release(peers []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.TTL/3)
+	defer cancel()
+	req := leaseRequest{NodeID: e.cfg.NodeID, URL: e.cfg.URL}
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		if peer == "" {
+			e.releaseLocal(e.cfg.NodeID)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := e.call(ctx, http.MethodDelete, peer, req, nil); err != nil {
+				logging.Log.WithError(err).WithField("peer", peer).Debug("lease release failed")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (e *HTTPLease) releaseAll() {
+	e.release(append([]string{""}, e.cfg.Peers...))
+}
+
+func (e *HTTPLease) call(ctx context.Context, method, peer string, body leaseRequest, out any) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, method, peer+LeasePath, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+e.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,423 @@
+// Package fakegateway is an in-process stand-in for Discord's REST API and
+// gateway. It speaks enough of the protocol (HELLO, IDENTIFY, READY,
+// heartbeats, RESUME and member chunk requests) for discordgo to connect to
+// it, so bot.Launch can be exercised end to end without a network.
+//
+//	gw := fakegateway.New(fakegateway.Guild{ID: "100", Members: members})
+//	defer gw.Close()
+//	os.Setenv("DISCORD_API_URL", gw.URL)
+package fakegateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Gateway opcodes used by the fake.
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opResume         = 6
+	opReconnect      = 7
+	opRequestMembers = 8
+	opHello          = 10
+	opHeartbeatAck   = 11
+)
+
+// BotUserID is the user ID the fake reports for the connected bot.
+const BotUserID = "1000"
+
+// Guild describes a guild the fake bot is in. Members are raw member
+// objects (with a nested "user"); Presences are raw presence objects.
+type Guild struct {
+	ID        string
+	Name      string
+	Members   []map[string]any
+	Presences []map[string]any
+}
+
+// Request is a REST call received by the fake API.
+type Request struct {
+	Method string
+	Path   string
+	Body   string
+}
+
+// MemberRequest is a REQUEST_GUILD_MEMBERS (op 8) sent by a client.
+type MemberRequest struct {
+	GuildID   string
+	Nonce     string
+	Presences bool
+}
+
+// Server is a fake Discord API and gateway. URL is the REST base to use as
+// DISCORD_API_URL; GatewayURL is the websocket endpoint it advertises.
+type Server struct {
+	URL        string
+	GatewayURL string
+
+	// HeartbeatInterval is sent in HELLO. Defaults to one second.
+	HeartbeatInterval time.Duration
+	// ChunkSize caps members per GUILD_MEMBERS_CHUNK. Defaults to 1000.
+	ChunkSize int
+	// Shards is the shard count recommended by /gateway/bot. Defaults to 1.
+	Shards int
+
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu             sync.Mutex
+	guilds         []Guild
+	conns          map[*conn]struct{}
+	sessions       int
+	identifies     int
+	resumes        int
+	heartbeats     int
+	requests       []Request
+	memberRequests []MemberRequest
+}
+
+type conn struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+	seq     int64
+}
+
+// New starts a fake gateway serving guilds.
+func New(guilds ...Guild) *Server {
+	s := &Server{
+		HeartbeatInterval: time.Second,
+		ChunkSize:         1000,
+		Shards:            1,
+		guilds:            guilds,
+		conns:             make(map[*conn]struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gateway", s.serveGateway)
+	mux.HandleFunc("/gateway/", s.serveGateway)
+	mux.HandleFunc("/", s.serveREST)
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	s.GatewayURL = "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/gateway"
+	return s
+}
+
+// Close disconnects every client and stops the server.
+func (s *Server) Close() {
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.ws.Close()
+	}
+	s.mu.Unlock()
+	s.srv.Close()
+}
+
+// Dispatch sends an op 0 event to every connected client.
+func (s *Server) Dispatch(eventType string, data any) error {
+	for _, c := range s.connections() {
+		if err := c.dispatch(eventType, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reconnect asks every client to reconnect (op 7); discordgo then resumes.
+func (s *Server) Reconnect() error {
+	for _, c := range s.connections() {
+		if err := c.write(map[string]any{"op": opReconnect, "d": nil}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drop closes every client connection without a close frame, as a network
+// failure would.
+func (s *Server) Drop() {
+	for _, c := range s.connections() {
+		_ = c.ws.UnderlyingConn().Close()
+	}
+}
+
+// Connections returns the number of open gateway connections.
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Identifies returns how many IDENTIFY payloads were received.
+func (s *Server) Identifies() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.identifies
+}
+
+// Resumes returns how many RESUME payloads were received.
+func (s *Server) Resumes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resumes
+}
+
+// Heartbeats returns how many heartbeats were received.
+func (s *Server) Heartbeats() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.heartbeats
+}
+
+// Requests returns the REST calls received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// MemberRequests returns the op 8 requests received so far.
+func (s *Server) MemberRequests() []MemberRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MemberRequest(nil), s.memberRequests...)
+}
+
+func (s *Server) connections() []*conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		out = append(out, c)
+	}
+	return out
+}
+
+// serveREST answers the gateway lookup and records every other call. Write
+// calls echo their body back, which satisfies discordgo's decoding of
+// command registrations and similar endpoints.
+func (s *Server) serveREST(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+
+	if strings.HasSuffix(r.URL.Path, "/gateway") || strings.HasSuffix(r.URL.Path, "/gateway/bot") {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"url":    s.GatewayURL,
+			"shards": s.Shards,
+			"session_start_limit": map[string]any{
+				"total": 1000, "remaining": 1000, "reset_after": 0, "max_concurrency": 1,
+			},
+		})
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Body: string(body)})
+	s.mu.Unlock()
+
+	if len(body) > 0 {
+		_, _ = w.Write(body)
+		return
+	}
+	_, _ = io.WriteString(w, "{}")
+}
+
+func (s *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &conn{ws: ws}
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		_ = ws.Close()
+	}()
+
+	hello := map[string]any{"heartbeat_interval": s.HeartbeatInterval.Milliseconds()}
+	if err := c.write(map[string]any{"op": opHello, "d": hello}); err != nil {
+		return
+	}
+
+	for {
+		var msg struct {
+			Op int             `json:"op"`
+			D  json.RawMessage `json:"d"`
+		}
+		if err := ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Op {
+		case opHeartbeat:
+			s.mu.Lock()
+			s.heartbeats++
+			s.mu.Unlock()
+			err = c.write(map[string]any{"op": opHeartbeatAck, "d": nil})
+		case opIdentify:
+			err = s.identify(c)
+		case opResume:
+			s.mu.Lock()
+			s.resumes++
+			s.mu.Unlock()
+			err = c.dispatch("RESUMED", map[string]any{})
+		case opRequestMembers:
+			for _, req := range decodeMemberRequests(msg.D) {
+				if err = s.sendChunks(c, req); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// identify answers IDENTIFY with READY followed by GUILD_CREATE per guild.
+func (s *Server) identify(c *conn) error {
+	s.mu.Lock()
+	s.identifies++
+	s.sessions++
+	sessionID := fmt.Sprintf("fake-session-%d", s.sessions)
+	guilds := append([]Guild(nil), s.guilds...)
+	s.mu.Unlock()
+
+	unavailable := make([]map[string]any, 0, len(guilds))
+	for _, g := range guilds {
+		unavailable = append(unavailable, map[string]any{"id": g.ID, "unavailable": true})
+	}
+	ready := map[string]any{
+		"v":                  10,
+		"session_id":         sessionID,
+		"resume_gateway_url": s.GatewayURL,
+		"user":               map[string]any{"id": BotUserID, "username": "tether-test", "bot": true},
+		"guilds":             unavailable,
+		"application":        map[string]any{"id": BotUserID, "flags": 0},
+	}
+	if err := c.dispatch("READY", ready); err != nil {
+		return err
+	}
+	for _, g := range guilds {
+		name := g.Name
+		if name == "" {
+			name = "guild " + g.ID
+		}
+		create := map[string]any{
+			"id":           g.ID,
+			"name":         name,
+			"member_count": len(g.Members),
+			"members":      []any{},
+			"presences":    []any{},
+			"channels":     []any{},
+			"roles":        []any{},
+		}
+		if err := c.dispatch("GUILD_CREATE", create); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendChunks answers a member request with the guild's members split into
+// GUILD_MEMBERS_CHUNK events carrying the request's nonce.
+func (s *Server) sendChunks(c *conn, req MemberRequest) error {
+	s.mu.Lock()
+	s.memberRequests = append(s.memberRequests, req)
+	var guild *Guild
+	for i := range s.guilds {
+		if s.guilds[i].ID == req.GuildID {
+			g := s.guilds[i]
+			guild = &g
+			break
+		}
+	}
+	size := max(s.ChunkSize, 1)
+	s.mu.Unlock()
+	if guild == nil {
+		return nil
+	}
+
+	presences := make(map[string]map[string]any, len(guild.Presences))
+	for _, p := range guild.Presences {
+		if user, ok := p["user"].(map[string]any); ok {
+			presences[fmt.Sprint(user["id"])] = p
+		}
+	}
+	count := max((len(guild.Members)+size-1)/size, 1)
+	for i := range count {
+		members := guild.Members[min(i*size, len(guild.Members)):min((i+1)*size, len(guild.Members))]
+		chunk := map[string]any{
+			"guild_id":    guild.ID,
+			"members":     members,
+			"chunk_index": i,
+			"chunk_count": count,
+			"nonce":       req.Nonce,
+		}
+		if req.Presences {
+			var ps []map[string]any
+			for _, m := range members {
+				if user, ok := m["user"].(map[string]any); ok {
+					if p, ok := presences[fmt.Sprint(user["id"])]; ok {
+						ps = append(ps, p)
+					}
+				}
+			}
+			chunk["presences"] = ps
+		}
+		if err := c.dispatch("GUILD_MEMBERS_CHUNK", chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeMemberRequests splits an op 8 payload into one request per guild.
+// Discord accepts guild_id as a string or, as discordgo sends it, an array.
+func decodeMemberRequests(raw json.RawMessage) []MemberRequest {
+	var payload struct {
+		GuildID   json.RawMessage `json:"guild_id"`
+		Nonce     string          `json:"nonce"`
+		Presences bool            `json:"presences"`
+	}
+	if json.Unmarshal(raw, &payload) != nil {
+		return nil
+	}
+	var ids []string
+	if json.Unmarshal(payload.GuildID, &ids) != nil {
+		var id string
+		if json.Unmarshal(payload.GuildID, &id) != nil {
+			return nil
+		}
+		ids = []string{id}
+	}
+	reqs := make([]MemberRequest, 0, len(ids))
+	for _, id := range ids {
+		reqs = append(reqs, MemberRequest{GuildID: id, Nonce: payload.Nonce, Presences: payload.Presences})
+	}
+	return reqs
+}
+
+func (c *conn) dispatch(eventType string, data any) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.seq++
+	return c.ws.WriteJSON(map[string]any{"op": opDispatch, "s": c.seq, "t": eventType, "d": data})
+}
+
+func (c *conn) write(v any) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteJSON(v)
+}
@@ -2,6 +2,7 @@ package lib
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"tether/src/store"
 	"tether/src/utils"
@@ -14,6 +15,7 @@ func patchActivitiesFromRaw(prev store.PresenceData, rawActivities []any) store.
 	}
 
 	acts := make([]store.Activity, 0, len(rawActivities))
+	typed := make([]store.PublicActivity, 0, len(rawActivities))
 	for _, rawItem := range rawActivities {
 		if m, ok := rawItem.(map[string]any); ok {
 			// Enrich emoji with CDN link if present
@@ -26,27 +28,156 @@ func patchActivitiesFromRaw(prev store.PresenceData, rawActivities []any) store.
 					m = em
 				}
 			}
+			m["type_name"] = ActivityTypeName(int(utils.GetInt64(m["type"])))
+			if app, ok := applications.Load().Lookup(utils.GetString(m["application_id"])); ok {
+				m["application"] = map[string]any{
+					"id":              app.ID,
+					"name":            app.Name,
+					"icon_url":        app.IconURL(),
+					"cover_image_url": app.CoverImageURL(),
+				}
+			}
 			acts = append(acts, store.Activity(m))
+			typed = append(typed, typedActivity(m))
 		}
 	}
 
 	if len(acts) > 0 {
 		prev.Activities = acts
+		prev.TypedActivities = typed
 	}
 
 	return prev
 }
 
+// activityTypeCustom is the activity type Discord uses for custom statuses.
+const activityTypeCustom = 4
+
+// patchCustomStatusFromRaw lifts the type-4 custom status activity into
+// PresenceData.CustomStatus. Activities must already be enriched so custom
+// emoji carry emoji_url.
+func patchCustomStatusFromRaw(prev store.PresenceData, rawActivities []any) store.PresenceData {
+	for _, item := range rawActivities {
+		act, ok := item.(map[string]any)
+		if !ok || utils.GetInt64(act["type"]) != activityTypeCustom {
+			continue
+		}
+		typed := typedActivity(act)
+		status := &store.CustomStatus{Text: typed.State}
+		if e := typed.Emoji; e != nil {
+			status.Emoji = &store.CustomStatusEmoji{ID: e.ID, Name: e.Name, Animated: e.Animated, EmojiURL: e.URL}
+		}
+		if typed.Timestamps != nil {
+			status.ExpiresAt = typed.Timestamps.End
+		}
+		if status.Text == "" && status.Emoji == nil {
+			continue
+		}
+		prev.CustomStatus = status
+		return prev
+	}
+	return prev
+}
+
+// activityTypeNames maps Discord activity types to their public names.
+var activityTypeNames = [...]string{"playing", "streaming", "listening", "watching", "custom", "competing"}
+
+// ActivityTypeName returns the public name for a Discord activity type, or
+// "unknown" for types Tether does not know yet.
+func ActivityTypeName(t int) string {
+	if t >= 0 && t < len(activityTypeNames) {
+		return activityTypeNames[t]
+	}
+	return "unknown"
+}
+
+// typedActivity converts an enriched raw activity into the v1 typed model.
+func typedActivity(m map[string]any) store.PublicActivity {
+	actType := int(utils.GetInt64(m["type"]))
+	act := store.PublicActivity{
+		Type:          actType,
+		TypeName:      ActivityTypeName(actType),
+		Name:          utils.GetString(m["name"]),
+		Details:       utils.GetString(m["details"]),
+		State:         utils.GetString(m["state"]),
+		URL:           utils.GetString(m["url"]),
+		ApplicationID: utils.GetString(m["application_id"]),
+		CreatedAt:     utils.GetInt64(m["created_at"]),
+	}
+	if start, end := utils.ExtractTimestamps(m); start != 0 || end != 0 {
+		act.Timestamps = &store.Timestamps{Start: start, End: end}
+	}
+	if assets, ok := m["assets"].(map[string]any); ok {
+		act.Assets = &store.ActivityAssets{
+			LargeImage:    utils.GetString(assets["large_image"]),
+			LargeImageURL: utils.GetString(assets["large_image_url"]),
+			LargeText:     utils.GetString(assets["large_text"]),
+			SmallImage:    utils.GetString(assets["small_image"]),
+			SmallImageURL: utils.GetString(assets["small_image_url"]),
+			SmallText:     utils.GetString(assets["small_text"]),
+		}
+	}
+	if party, ok := m["party"].(map[string]any); ok {
+		act.Party = &store.ActivityParty{ID: utils.GetString(party["id"])}
+		if size, ok := party["size"].([]any); ok && len(size) == 2 {
+			act.Party.CurrentSize = int(utils.GetInt64(size[0]))
+			act.Party.MaxSize = int(utils.GetInt64(size[1]))
+		}
+	}
+	if buttons, ok := m["buttons"].([]any); ok {
+		for _, b := range buttons {
+			// Presences carry button labels only; URLs are never shared.
+			if label := utils.GetString(b); label != "" {
+				act.Buttons = append(act.Buttons, label)
+			}
+		}
+	}
+	if app, ok := m["application"].(map[string]any); ok {
+		act.Application = &store.ActivityApplication{
+			ID:            utils.GetString(app["id"]),
+			Name:          utils.GetString(app["name"]),
+			IconURL:       utils.GetString(app["icon_url"]),
+			CoverImageURL: utils.GetString(app["cover_image_url"]),
+		}
+	}
+	if emoji, ok := m["emoji"].(map[string]any); ok {
+		act.Emoji = &store.ActivityEmoji{
+			ID:       utils.GetString(emoji["id"]),
+			Name:     utils.GetString(emoji["name"]),
+			Animated: utils.GetBool(emoji["animated"]),
+			URL:      utils.GetString(emoji["emoji_url"]),
+		}
+	}
+	return act
+}
+
 // UpsertChunkPresences replaces presence snapshots from a GUILD_MEMBERS_CHUNK raw payload.
 // It builds presences directly from raw maps to retain all fields and avoids discordgo structs.
 // Offline members (present in members[] but absent from presences[]) get default offline snapshots.
-func UpsertChunkPresences(st *store.PresenceStore, raw json.RawMessage) {
+// It returns the chunk's sequence metadata for ChunkTracker.
+func UpsertChunkPresences(st *store.PresenceStore, raw json.RawMessage) (ChunkInfo, bool) {
 	payload, ok := utils.UnmarshalToMap(raw)
 	if !ok {
-		return
+		return ChunkInfo{}, false
 	}
 
+	guildID := utils.GetString(payload["guild_id"])
+	info := ChunkInfo{
+		GuildID:    guildID,
+		Nonce:      utils.GetString(payload["nonce"]),
+		ChunkIndex: utils.ExtractIntField(payload, "chunk_index"),
+		ChunkCount: utils.ExtractIntField(payload, "chunk_count"),
+	}
+	if notFound, ok := payload["not_found"].([]any); ok {
+		for _, id := range notFound {
+			info.NotFound = append(info.NotFound, fmt.Sprintf("%v", id))
+		}
+	}
 	memberLookup := buildMemberLookup(payload)
+	info.UserIDs = make([]string, 0, len(memberLookup))
+	for userID := range memberLookup {
+		info.UserIDs = append(info.UserIDs, userID)
+	}
 	rawPresences, ok := payload["presences"].([]any)
 	if !ok {
 		rawPresences = []any{}
@@ -67,6 +198,10 @@ func UpsertChunkPresences(st *store.PresenceStore, raw json.RawMessage) {
 		}
 		userID := utils.ExtractStringField(userMap, "id")
 		member := memberLookup[userID]
+		// Chunk presences omit guild_id; inherit it from the chunk.
+		if guildID != "" {
+			pres["guild_id"] = guildID
+		}
 		presence, userID, ok := BuildPresenceFromRaw(pres, userMap, member)
 		if !ok {
 			if userID != "" {
@@ -75,6 +210,9 @@ func UpsertChunkPresences(st *store.PresenceStore, raw json.RawMessage) {
 			continue
 		}
 
+		if prev, exists := st.GetPresence(userID); exists {
+			presence = MergePresenceIdentity(prev, presence)
+		}
 		st.SetPresenceQuiet(userID, presence)
 		st.BroadcastPresence(userID)
 		processedUserIDs[userID] = struct{}{}
@@ -90,12 +228,23 @@ func UpsertChunkPresences(st *store.PresenceStore, raw json.RawMessage) {
 			// Create minimal offline presence from member data
 			offlinePresence := store.PresenceData{
 				DiscordStatus: "offline",
-				DiscordUser:   discordUserFromRaw(userMap, member),
+				BaseUser:      userFromRaw(userMap),
+			}
+			if guildID != "" {
+				offlinePresence.GuildMembers = map[string]map[string]any{guildID: memberWithoutUser(member)}
+			}
+			if prev, exists := st.GetPresence(userID); exists {
+				offlinePresence = MergePresenceIdentity(prev, offlinePresence)
+			} else {
+				offlinePresence.DiscordUser = applyMemberOverrides(offlinePresence.BaseUser, guildID, member)
+				offlinePresence.Member = memberProfile(userID, guildID, memberWithoutUser(member))
 			}
 			st.SetPresenceQuiet(userID, offlinePresence)
 			st.BroadcastPresence(userID)
 		}
 	}
+
+	return info, true
 }
 
 func buildMemberLookup(payload map[string]any) map[string]map[string]any {
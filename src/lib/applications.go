@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tether/src/concurrency"
+	"tether/src/logging"
+	"tether/src/utils"
+)
+
+// Application is the subset of Discord's application object Tether uses to
+// enrich activities. The JSON shape matches Discord's, so responses from
+// /applications/{id}/rpc can be pasted into a seed file as-is.
+type Application struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Icon       string `json:"icon,omitempty"`
+	CoverImage string `json:"cover_image,omitempty"`
+}
+
+// IconURL returns the application's icon on the CDN, or "".
+func (a Application) IconURL() string {
+	if a.Icon == "" {
+		return ""
+	}
+	return utils.CDNURL("app-icons/"+a.ID+"/"+a.Icon, "png", 0)
+}
+
+// CoverImageURL returns the application's cover image on the CDN, or "".
+func (a Application) CoverImageURL() string {
+	if a.CoverImage == "" {
+		return ""
+	}
+	return utils.CDNURL("app-icons/"+a.ID+"/"+a.CoverImage, "png", 0)
+}
+
+// ApplicationFetcher resolves applications missing from the cache.
+type ApplicationFetcher interface {
+	FetchApplication(ctx context.Context, id string) (Application, error)
+}
+
+// applicationRetryAfter is how long a failed lookup is remembered before the
+// fetcher is asked again.
+const applicationRetryAfter = time.Hour
+
+// ApplicationCache maps application IDs to metadata. Lookups never block on
+// the network: a miss schedules a background fetch (when a fetcher is set)
+// and the metadata appears on the user's next presence update.
+type ApplicationCache struct {
+	fetcher ApplicationFetcher
+
+	mu     sync.RWMutex
+	apps   map[string]Application
+	failed map[string]time.Time
+	queued map[string]struct{}
+}
+
+// NewApplicationCache creates a cache; fetcher may be nil to rely on seed
+// data only.
+func NewApplicationCache(fetcher ApplicationFetcher) *ApplicationCache {
+	return &ApplicationCache{
+		fetcher: fetcher,
+		apps:    make(map[string]Application),
+		failed:  make(map[string]time.Time),
+		queued:  make(map[string]struct{}),
+	}
+}
+
+// LoadFile seeds the cache from a JSON array of applications. A missing
+// file is not an error.
+func (c *ApplicationCache) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var apps []Application
+	if err := json.Unmarshal(data, &apps); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, app := range apps {
+		if app.ID != "" {
+			c.apps[app.ID] = app
+		}
+	}
+	return nil
+}
+
+// Add stores or replaces an application.
+func (c *ApplicationCache) Add(app Application) {
+	c.mu.Lock()
+	c.apps[app.ID] = app
+	delete(c.failed, app.ID)
+	c.mu.Unlock()
+}
+
+// Len returns the number of cached applications.
+func (c *ApplicationCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.apps)
+}
+
+// Lookup returns cached metadata for id, scheduling a fetch on a miss.
+func (c *ApplicationCache) Lookup(id string) (Application, bool) {
+	if c == nil || id == "" {
+		return Application{}, false
+	}
+	c.mu.RLock()
+	app, ok := c.apps[id]
+	c.mu.RUnlock()
+	if !ok {
+		c.schedule(id)
+	}
+	return app, ok
+}
+
+func (c *ApplicationCache) schedule(id string) {
+	if c.fetcher == nil {
+		return
+	}
+	c.mu.Lock()
+	if _, busy := c.queued[id]; busy {
+		c.mu.Unlock()
+		return
+	}
+	if at, failed := c.failed[id]; failed && time.Since(at) < applicationRetryAfter {
+		c.mu.Unlock()
+		return
+	}
+	c.queued[id] = struct{}{}
+	c.mu.Unlock()
+
+	concurrency.GoSafe(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		app, err := c.fetcher.FetchApplication(ctx, id)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.queued, id)
+		if err != nil {
+			c.failed[id] = time.Now()
+			logging.Log.WithError(err).WithField("application_id", id).Debug("application lookup failed")
+			return
+		}
+		app.ID = id
+		c.apps[id] = app
+		delete(c.failed, id)
+	})
+}
+
+// applications is the cache consulted while building activities.
+var applications atomic.Pointer[ApplicationCache]
+
+// SetApplicationCache configures the cache used to enrich activities; nil
+// disables enrichment.
+func SetApplicationCache(c *ApplicationCache) {
+	applications.Store(c)
+}
+
+// DiscordApplicationFetcher looks applications up via Discord's public
+// /applications/{id}/rpc endpoint, which needs no authentication.
+type DiscordApplicationFetcher struct {
+	Client  *http.Client
+	BaseURL string // defaults to https://discord.com/api/v10
+}
+
+func (f DiscordApplicationFetcher) FetchApplication(ctx context.Context, id string) (Application, error) {
+	base := f.BaseURL
+	if base == "" {
+		base = "https://discord.com/api/v10"
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/applications/"+id+"/rpc", nil)
+	if err != nil {
+		return Application{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Application{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Application{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var app Application
+	if err := json.NewDecoder(resp.Body).Decode(&app); err != nil {
+		return Application{}, err
+	}
+	return app, nil
+}
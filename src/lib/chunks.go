@@ -0,0 +1,227 @@
+package lib
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"tether/src/logging"
+	"tether/src/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultChunkTimeout is how long a member request may go without a chunk
+// before its sequence is given up.
+const DefaultChunkTimeout = 2 * time.Minute
+
+// ChunkInfo describes one GUILD_MEMBERS_CHUNK within its sequence.
+type ChunkInfo struct {
+	GuildID    string
+	Nonce      string
+	ChunkIndex int
+	ChunkCount int
+	// NotFound lists user IDs Discord could not resolve (user_ids queries).
+	NotFound []string
+	// UserIDs lists the members carried by this chunk.
+	UserIDs []string
+}
+
+// ChunkProgress is the public view of a member request's chunk sequence.
+type ChunkProgress struct {
+	GuildID  string `json:"guild_id"`
+	Nonce    string `json:"nonce"`
+	Received int    `json:"chunks_received"`
+	Expected int    `json:"chunks_expected"`
+	Members  int    `json:"members"`
+	NotFound int    `json:"not_found"`
+	Complete bool   `json:"complete"`
+	// TimedOut is set when no chunk arrived within the tracker's timeout,
+	// e.g. because the request was lost in a disconnect. The sequence will
+	// not complete, and no stale members are removed for it.
+	TimedOut    bool       `json:"timed_out,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+type chunkSequence struct {
+	progress ChunkProgress
+	indexes  map[int]struct{}
+	seen     map[string]struct{}
+	// lastChunk is when the request was sent or its latest chunk arrived.
+	lastChunk time.Time
+}
+
+// ChunkTracker correlates GUILD_MEMBERS_CHUNK events with the member request
+// that produced them (by nonce), reports progress, and on completion removes
+// users still tracked for the guild who were absent from every chunk and not
+// updated since the request — they left while Tether was not listening.
+type ChunkTracker struct {
+	st *store.PresenceStore
+	// Timeout gives up on a sequence when no chunk arrives for this long
+	// (default DefaultChunkTimeout). Set it before use.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	byNonce map[string]*chunkSequence
+	byGuild map[string]*chunkSequence
+}
+
+func NewChunkTracker(st *store.PresenceStore) *ChunkTracker {
+	return &ChunkTracker{
+		st:      st,
+		byNonce: make(map[string]*chunkSequence),
+		byGuild: make(map[string]*chunkSequence),
+	}
+}
+
+// Begin registers a member request for guildID identified by nonce,
+// replacing any earlier sequence for the same guild.
+func (t *ChunkTracker) Begin(guildID, nonce string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if prev, ok := t.byGuild[guildID]; ok {
+		delete(t.byNonce, prev.progress.Nonce)
+	}
+	now := time.Now()
+	seq := &chunkSequence{
+		progress:  ChunkProgress{GuildID: guildID, Nonce: nonce, StartedAt: now},
+		indexes:   make(map[int]struct{}),
+		seen:      make(map[string]struct{}),
+		lastChunk: now,
+	}
+	t.byNonce[nonce] = seq
+	t.byGuild[guildID] = seq
+}
+
+// Cancel forgets a sequence whose request could not be sent.
+func (t *ChunkTracker) Cancel(guildID, nonce string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if seq, ok := t.byNonce[nonce]; ok && seq.progress.GuildID == guildID {
+		delete(t.byNonce, nonce)
+		delete(t.byGuild, guildID)
+	}
+}
+
+// RemoveGuild forgets guildID's sequence, e.g. when the bot leaves the
+// guild.
+func (t *ChunkTracker) RemoveGuild(guildID string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if seq, ok := t.byGuild[guildID]; ok {
+		delete(t.byNonce, seq.progress.Nonce)
+		delete(t.byGuild, guildID)
+	}
+}
+
+// Record accounts for a received chunk. Chunks whose nonce does not match a
+// pending request (e.g. unsolicited or superseded) are ignored. When the last
+// missing chunk arrives the sequence completes and stale users are removed.
+func (t *ChunkTracker) Record(info ChunkInfo) {
+	if t == nil || info.Nonce == "" {
+		return
+	}
+	t.mu.Lock()
+	t.expireLocked(time.Now())
+	seq, ok := t.byNonce[info.Nonce]
+	if !ok || seq.progress.Complete || seq.progress.GuildID != info.GuildID {
+		t.mu.Unlock()
+		return
+	}
+	seq.lastChunk = time.Now()
+	if _, dup := seq.indexes[info.ChunkIndex]; !dup {
+		seq.indexes[info.ChunkIndex] = struct{}{}
+		seq.progress.Received++
+	}
+	seq.progress.Expected = info.ChunkCount
+	seq.progress.NotFound += len(info.NotFound)
+	for _, id := range info.UserIDs {
+		seq.seen[id] = struct{}{}
+	}
+	seq.progress.Members = len(seq.seen)
+
+	if seq.progress.Expected == 0 || seq.progress.Received < seq.progress.Expected {
+		t.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	seq.progress.Complete = true
+	seq.progress.CompletedAt = &now
+	seen := seq.seen
+	seq.seen = nil
+	seq.indexes = nil
+	delete(t.byNonce, info.Nonce)
+	progress := seq.progress
+	t.mu.Unlock()
+
+	removed := removeStaleMembers(t.st, info.GuildID, progress.StartedAt, seen)
+	logging.Log.WithFields(logrus.Fields{
+		"guild_id": progress.GuildID,
+		"nonce":    progress.Nonce,
+		"chunks":   progress.Received,
+		"members":  progress.Members,
+		"removed":  removed,
+		"duration": now.Sub(progress.StartedAt).Round(time.Millisecond).String(),
+	}).Info("guild member sync complete")
+}
+
+// Progress returns the latest sequence per guild, ordered by guild ID.
+func (t *ChunkTracker) Progress() []ChunkProgress {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expireLocked(time.Now())
+	out := make([]ChunkProgress, 0, len(t.byGuild))
+	for _, id := range slices.Sorted(maps.Keys(t.byGuild)) {
+		out = append(out, t.byGuild[id].progress)
+	}
+	return out
+}
+
+// Ready reports whether every requested guild has finished syncing or
+// timed out.
+func (t *ChunkTracker) Ready() bool {
+	for _, p := range t.Progress() {
+		if !p.Complete && !p.TimedOut {
+			return false
+		}
+	}
+	return true
+}
+
+// expireLocked times out pending sequences that have gone without a chunk
+// for longer than the timeout. Callers must hold t.mu.
+func (t *ChunkTracker) expireLocked(now time.Time) {
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = DefaultChunkTimeout
+	}
+	for nonce, seq := range t.byNonce {
+		if now.Sub(seq.lastChunk) < timeout {
+			continue
+		}
+		seq.progress.TimedOut = true
+		seq.seen = nil
+		seq.indexes = nil
+		delete(t.byNonce, nonce)
+		logging.Log.WithFields(logrus.Fields{
+			"guild_id": seq.progress.GuildID,
+			"nonce":    nonce,
+			"chunks":   seq.progress.Received,
+			"expected": seq.progress.Expected,
+		}).Warn("guild member sync timed out")
+	}
+}
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"maps"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"tether/src/logging"
+	"tether/src/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// guildPriority orders guilds whose member data wins when a user is tracked
+// in several guilds. Stored atomically because the bot configures it once at
+// startup while gateway handlers may already be reading it.
+var guildPriority atomic.Pointer[[]string]
+
+// SetGuildPriority configures the merge policy for users seen in several
+// guilds: guild-scoped fields (member avatar, decorations, ...) come from the
+// first guild in ids the user belongs to. Users in none of the listed guilds
+// fall back to their oldest guild (lowest snowflake).
+func SetGuildPriority(ids []string) {
+	cp := slices.Clone(ids)
+	guildPriority.Store(&cp)
+}
+
+// PreferredGuild picks the guild whose member data is exposed publicly.
+func PreferredGuild(members map[string]map[string]any) string {
+	if len(members) == 0 {
+		return ""
+	}
+	if prio := guildPriority.Load(); prio != nil {
+		for _, id := range *prio {
+			if _, ok := members[id]; ok {
+				return id
+			}
+		}
+	}
+	ids := slices.Collect(maps.Keys(members))
+	slices.SortFunc(ids, compareSnowflakes)
+	return ids[0]
+}
+
+// compareSnowflakes orders numeric IDs by value without parsing them.
+func compareSnowflakes(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ResolveDiscordUser applies the preferred guild's member overrides to the
+// user-level identity.
+func ResolveDiscordUser(base store.DiscordUser, members map[string]map[string]any) store.DiscordUser {
+	guildID := PreferredGuild(members)
+	return applyMemberOverrides(base, guildID, members[guildID])
+}
+
+// MergePresenceIdentity folds next's identity into prev's: user-level fields
+// merge with "present wins" semantics, guild memberships accumulate (a nil
+// member payload never erases known data for that guild), and DiscordUser is
+// re-resolved. All non-identity fields are taken from next.
+func MergePresenceIdentity(prev, next store.PresenceData) store.PresenceData {
+	next.BaseUser = MergeDiscordUser(prev.BaseUser, next.BaseUser)
+
+	guilds := maps.Clone(prev.GuildMembers)
+	if guilds == nil {
+		guilds = make(map[string]map[string]any, len(next.GuildMembers))
+	}
+	for guildID, member := range next.GuildMembers {
+		if _, known := guilds[guildID]; member != nil || !known {
+			guilds[guildID] = member
+		}
+	}
+	next.GuildMembers = guilds
+	next.DiscordUser = ResolveDiscordUser(next.BaseUser, next.GuildMembers)
+	next.Member = ResolveMember(next.BaseUser.ID, next.GuildMembers)
+	return next
+}
+
+// RemoveGuildMember drops a user's membership in one guild. The presence is
+// removed entirely once the user is no longer in any tracked guild;
+// otherwise identity is re-resolved from the remaining guilds.
+func RemoveGuildMember(st *store.PresenceStore, guildID, userID string) {
+	prev, ok := st.GetPresence(userID)
+	if !ok {
+		return
+	}
+	remaining := len(prev.GuildMembers)
+	if _, member := prev.GuildMembers[guildID]; member {
+		remaining--
+	}
+	if guildID == "" || remaining <= 0 {
+		st.RemovePresence(userID)
+		logging.Log.WithFields(logrus.Fields{"user_id": userID, "guild_id": guildID}).Info("removed presence from member remove")
+		return
+	}
+
+	st.UpdatePresenceQuiet(userID, func(p store.PresenceData) store.PresenceData {
+		p.GuildMembers = maps.Clone(p.GuildMembers)
+		delete(p.GuildMembers, guildID)
+		p.DiscordUser = ResolveDiscordUser(p.BaseUser, p.GuildMembers)
+		p.Member = ResolveMember(userID, p.GuildMembers)
+		return p
+	})
+	st.BroadcastPresence(userID)
+	logging.Log.WithFields(logrus.Fields{"user_id": userID, "guild_id": guildID}).Info("removed guild membership")
+}
+
+// RemoveGuild drops guildID membership for every tracked user, removing
+// users who were only tracked through it. It returns how many users lost
+// the membership.
+func RemoveGuild(st *store.PresenceStore, guildID string) int {
+	return removeStaleMembers(st, guildID, time.Now(), nil)
+}
+
+// memberWithoutUser copies a member payload minus its nested user object,
+// which is tracked separately as BaseUser.
+func memberWithoutUser(member map[string]any) map[string]any {
+	if member == nil {
+		return nil
+	}
+	m := maps.Clone(member)
+	delete(m, "user")
+	return m
+}
@@ -21,6 +21,10 @@ func MergeDiscordUser(base store.DiscordUser, incoming store.DiscordUser) store.
 	base.Username = utils.MergeStringField(base.Username, incoming.Username)
 	base.GlobalName = utils.MergeStringField(base.GlobalName, incoming.GlobalName)
 	base.Avatar = utils.MergeStringField(base.Avatar, incoming.Avatar)
+	base.Banner = utils.MergeStringField(base.Banner, incoming.Banner)
+	if incoming.AccentColor != nil {
+		base.AccentColor = incoming.AccentColor
+	}
 	base.AvatarDecorationData = utils.MergeAnyField(base.AvatarDecorationData, incoming.AvatarDecorationData)
 	base.PrimaryGuild = utils.MergeAnyField(base.PrimaryGuild, incoming.PrimaryGuild)
 	base.Collectibles = utils.MergeAnyField(base.Collectibles, incoming.Collectibles)
@@ -31,18 +35,27 @@ func MergeDiscordUser(base store.DiscordUser, incoming store.DiscordUser) store.
 		base.PublicFlagsPresent = true
 	}
 	base.PublicFlags = utils.PublicFlagsToNames(base.PublicFlagsRaw)
+	base.Badges = utils.PublicFlagsToBadges(base.PublicFlagsRaw)
 
-	// Regenerate avatar URL after merging avatar fields
+	// Regenerate image URLs after merging the fields they derive from
 	base.AvatarURL = BuildAvatarURL(base.ID, base.Avatar, "")
+	base.BannerURL = utils.BuildBannerURL(base.ID, base.Banner)
+	base.NameplateURL = utils.BuildNameplateURL(base.Collectibles)
 
 	return base
 }
 
 // MergeRawUser overlays identity fields onto a tracked user's presence entry.
 // Called on GUILD_MEMBER_ADD/UPDATE when Discord sends updated user data
-// without an accompanying presence event.
+// without an accompanying presence event. The member payload is recorded
+// against its guild so guild-scoped overrides follow the merge policy.
 func MergeRawUser(st *store.PresenceStore, raw json.RawMessage) {
-	userMap, memberMap := utils.ExtractRawIdentity(raw)
+	payload, ok := utils.UnmarshalToMap(raw)
+	if !ok {
+		logging.Log.Warn("MergeRawUser: failed to unmarshal payload")
+		return
+	}
+	userMap, memberMap := utils.ExtractRawIdentityFromPayload(payload)
 	if userMap == nil {
 		logging.Log.Warn("MergeRawUser: failed to extract user identity from raw JSON")
 		return
@@ -53,20 +66,27 @@ func MergeRawUser(st *store.PresenceStore, raw json.RawMessage) {
 		return
 	}
 
-	incomingUser := discordUserFromRaw(userMap, memberMap)
+	incoming := store.PresenceData{BaseUser: userFromRaw(userMap)}
+	if guildID := utils.GetString(payload["guild_id"]); guildID != "" {
+		incoming.GuildMembers = map[string]map[string]any{guildID: memberWithoutUser(memberMap)}
+	}
 	st.UpdatePresenceQuiet(userID, func(prev store.PresenceData) store.PresenceData {
-		prev.DiscordUser = MergeDiscordUser(prev.DiscordUser, incomingUser)
+		merged := MergePresenceIdentity(prev, incoming)
+		prev.BaseUser = merged.BaseUser
+		prev.GuildMembers = merged.GuildMembers
+		prev.DiscordUser = merged.DiscordUser
+		prev.Member = merged.Member
 		return prev
 	})
 
 	logging.Log.WithFields(logrus.Fields{
 		"user_id":  userID,
-		"username": incomingUser.Username,
+		"username": incoming.BaseUser.Username,
 	}).Info("user identity updated from member event")
 }
 
-// discordUserFromRaw builds DiscordUser from raw JSON maps
-func discordUserFromRaw(user map[string]any, member map[string]any) store.DiscordUser {
+// userFromRaw builds the user-level DiscordUser without guild overrides.
+func userFromRaw(user map[string]any) store.DiscordUser {
 	userID := utils.ExtractStringField(user, "id")
 	logging.Log.WithField("user_id", userID).Debug("Building Discord user from raw JSON")
 
@@ -75,6 +95,7 @@ func discordUserFromRaw(user map[string]any, member map[string]any) store.Discor
 		Username:             utils.GetString(user["username"]),
 		GlobalName:           utils.GetString(user["global_name"]),
 		Avatar:               utils.GetString(user["avatar"]),
+		Banner:               utils.GetString(user["banner"]),
 		PublicFlagsRaw:       utils.ExtractIntField(user, "public_flags"),
 		AvatarDecorationData: EnrichAvatarDecorationData(user["avatar_decoration_data"]),
 		PrimaryGuild:         EnrichPrimaryGuildData(user["primary_guild"]),
@@ -83,22 +104,43 @@ func discordUserFromRaw(user map[string]any, member map[string]any) store.Discor
 	}
 	_, userData.PublicFlagsPresent = user["public_flags"]
 	userData.PublicFlags = utils.PublicFlagsToNames(userData.PublicFlagsRaw)
+	userData.Badges = utils.PublicFlagsToBadges(userData.PublicFlagsRaw)
+	if color, ok := user["accent_color"]; ok && color != nil {
+		c := int(utils.GetInt64(color))
+		userData.AccentColor = &c
+	}
+	userData.AvatarURL = BuildAvatarURL(userData.ID, userData.Avatar, "")
+	userData.BannerURL = utils.BuildBannerURL(userData.ID, userData.Banner)
+	userData.NameplateURL = utils.BuildNameplateURL(userData.Collectibles)
 
-	// Member-level overrides
-	if member != nil {
-		logging.Log.WithField("user_id", userID).Debug("Applying member-level overrides")
-		// Check for member-level avatar override
-		if memberAvatar := utils.GetString(member["avatar"]); memberAvatar != "" {
-			userData.Avatar = memberAvatar
-		}
-		userData.AvatarDecorationData = utils.MergeAnyField(userData.AvatarDecorationData, EnrichAvatarDecorationData(member["avatar_decoration_data"]))
-		userData.PrimaryGuild = utils.MergeAnyField(userData.PrimaryGuild, EnrichPrimaryGuildData(member["primary_guild"]))
-		userData.Collectibles = utils.MergeAnyField(userData.Collectibles, member["collectibles"])
-		userData.DisplayNameStyles = utils.MergeAnyField(userData.DisplayNameStyles, member["display_name_styles"])
+	return userData
+}
+
+// applyMemberOverrides layers guild-scoped member fields over a user-level
+// identity. guildID locates guild-specific images and may be empty when the
+// member's guild is unknown.
+func applyMemberOverrides(userData store.DiscordUser, guildID string, member map[string]any) store.DiscordUser {
+	if member == nil {
+		return userData
+	}
+	logging.Log.WithField("user_id", userData.ID).Debug("Applying member-level overrides")
+	// Check for member-level avatar override
+	if memberAvatar := utils.GetString(member["avatar"]); memberAvatar != "" {
+		userData.Avatar = memberAvatar
+	}
+	// Member banners live under the guild, so they need its ID for a URL.
+	if memberBanner := utils.GetString(member["banner"]); memberBanner != "" && guildID != "" {
+		userData.Banner = memberBanner
+		userData.BannerURL = utils.BuildMemberBannerURL(guildID, userData.ID, memberBanner)
 	}
+	userData.AvatarDecorationData = utils.MergeAnyField(userData.AvatarDecorationData, EnrichAvatarDecorationData(member["avatar_decoration_data"]))
+	userData.PrimaryGuild = utils.MergeAnyField(userData.PrimaryGuild, EnrichPrimaryGuildData(member["primary_guild"]))
+	userData.Collectibles = utils.MergeAnyField(userData.Collectibles, member["collectibles"])
+	userData.DisplayNameStyles = utils.MergeAnyField(userData.DisplayNameStyles, member["display_name_styles"])
 
-	// Generate avatar URL after all overrides are applied
+	// Regenerate derived URLs after all overrides are applied
 	userData.AvatarURL = BuildAvatarURL(userData.ID, userData.Avatar, "")
+	userData.NameplateURL = utils.BuildNameplateURL(userData.Collectibles)
 
 	return userData
 }
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"tether/src/store"
+	"tether/src/utils"
+)
+
+// memberAllowlist holds the users whose guild member profile may be exposed.
+// nil exposes nobody; a "*" entry exposes everyone.
+var memberAllowlist atomic.Pointer[map[string]struct{}]
+
+// SetMemberAllowlist configures which users get a public member object.
+// "*" allows every tracked user. Entries are trimmed, so a list split from
+// "1, 2" works.
+func SetMemberAllowlist(ids []string) {
+	set := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			set[id] = struct{}{}
+		}
+	}
+	memberAllowlist.Store(&set)
+}
+
+// memberAllowed reports whether userID's member profile may be exposed.
+func memberAllowed(userID string) bool {
+	set := memberAllowlist.Load()
+	if set == nil {
+		return false
+	}
+	if _, all := (*set)["*"]; all {
+		return true
+	}
+	_, ok := (*set)[userID]
+	return ok
+}
+
+// RoleResolver looks up a guild role's name and colour.
+type RoleResolver func(guildID, roleID string) (store.GuildRole, bool)
+
+var roleResolver atomic.Pointer[RoleResolver]
+
+// SetRoleResolver installs the lookup used to name member roles. Without one
+// (or when a role is unknown) roles carry only their ID.
+func SetRoleResolver(r RoleResolver) {
+	roleResolver.Store(&r)
+}
+
+// ResolveMember builds the preferred guild's member profile, or nil when the
+// user is not allowlisted or has no member data.
+func ResolveMember(userID string, members map[string]map[string]any) *store.GuildMember {
+	guildID := PreferredGuild(members)
+	return memberProfile(userID, guildID, members[guildID])
+}
+
+// memberProfile converts a raw member payload into the public member object.
+func memberProfile(userID, guildID string, member map[string]any) *store.GuildMember {
+	if member == nil || guildID == "" || !memberAllowed(userID) {
+		return nil
+	}
+	profile := &store.GuildMember{
+		GuildID:                    guildID,
+		Nick:                       utils.GetString(member["nick"]),
+		AvatarURL:                  utils.BuildMemberAvatarURL(guildID, userID, utils.GetString(member["avatar"])),
+		Roles:                      []store.GuildRole{},
+		JoinedAt:                   utils.GetString(member["joined_at"]),
+		PremiumSince:               utils.GetString(member["premium_since"]),
+		CommunicationDisabledUntil: utils.GetString(member["communication_disabled_until"]),
+	}
+	profile.Boosting = profile.PremiumSince != ""
+
+	var resolve RoleResolver
+	if r := roleResolver.Load(); r != nil {
+		resolve = *r
+	}
+	roles, _ := member["roles"].([]any)
+	for _, item := range roles {
+		roleID := utils.GetString(item)
+		if roleID == "" {
+			continue
+		}
+		role := store.GuildRole{ID: roleID}
+		if resolve != nil {
+			if resolved, ok := resolve(guildID, roleID); ok {
+				role = resolved
+				role.ID = roleID
+			}
+		}
+		profile.Roles = append(profile.Roles, role)
+	}
+	return profile
+}
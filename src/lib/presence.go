@@ -10,6 +10,8 @@ import (
 
 // BuildPresenceFromRaw constructs a PresenceData snapshot directly from a raw Gateway payload.
 // It avoids discordgo structs so fields that discordgo omits (sync_id, etc.) remain intact.
+// The payload's guild_id, when present, records guild membership for the
+// multi-guild merge policy (see MergePresenceIdentity).
 // Returns presence, userID, ok (false when user identity is missing).
 func BuildPresenceFromRaw(payload map[string]any, user map[string]any, member map[string]any) (store.PresenceData, string, bool) {
 	userID := utils.ExtractUserID(payload)
@@ -71,6 +73,8 @@ func BuildPresenceFromRaw(payload map[string]any, user map[string]any, member ma
 
 	presence = patchActivitiesFromRaw(presence, rawActivities)
 	presence = patchSpotifyFromRaw(presence, rawActivities)
+	presence = patchCustomStatusFromRaw(presence, rawActivities)
+	presence = patchStreamingFromRaw(presence, rawActivities)
 
 	user = pickUserMap(user, member)
 	if user == nil || member == nil {
@@ -84,7 +88,13 @@ func BuildPresenceFromRaw(payload map[string]any, user map[string]any, member ma
 		user = pickUserMap(user, member)
 	}
 
-	presence.DiscordUser = discordUserFromRaw(user, member)
+	presence.BaseUser = userFromRaw(user)
+	guildID := utils.GetString(payload["guild_id"])
+	if guildID != "" {
+		presence.GuildMembers = map[string]map[string]any{guildID: memberWithoutUser(member)}
+	}
+	presence.DiscordUser = applyMemberOverrides(presence.BaseUser, guildID, member)
+	presence.Member = memberProfile(userID, guildID, member)
 
 	return presence, userID, true
 }
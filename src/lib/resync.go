@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"time"
+
+	"tether/src/store"
+)
+
+// removeStaleMembers drops guildID membership for users a member resync did
+// not refresh. It runs once the chunk sequence requested at since completes,
+// so members who left while the gateway was down do not linger: a user is
+// stale when absent from every chunk (seen) and not updated since the
+// request, which spares members who joined while the chunks were arriving.
+// Users tracked only through guildID are removed. It returns how many users
+// lost the membership.
+func removeStaleMembers(st *store.PresenceStore, guildID string, since time.Time, seen map[string]struct{}) int {
+	if st == nil {
+		return 0
+	}
+	stale := 0
+	for userID, p := range st.GetAllPresences() {
+		if _, member := p.GuildMembers[guildID]; !member || !p.UpdatedAt.Before(since) {
+			continue
+		}
+		if _, ok := seen[userID]; ok {
+			continue
+		}
+		RemoveGuildMember(st, guildID, userID)
+		stale++
+	}
+	return stale
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"net/url"
+	"strings"
+
+	"tether/src/store"
+	"tether/src/utils"
+)
+
+// activityTypeStreaming is the activity type Discord uses for live streams.
+const activityTypeStreaming = 1
+
+// patchStreamingFromRaw lifts the first type-1 streaming activity into
+// PresenceData.Streaming. Activities must already be enriched so asset URLs
+// are available as a thumbnail fallback.
+func patchStreamingFromRaw(prev store.PresenceData, rawActivities []any) store.PresenceData {
+	for _, item := range rawActivities {
+		act, ok := item.(map[string]any)
+		if !ok || utils.GetInt64(act["type"]) != activityTypeStreaming {
+			continue
+		}
+		streamURL := utils.GetString(act["url"])
+		if streamURL == "" {
+			continue
+		}
+		platform, channel, thumbnail := StreamPlatform(streamURL)
+		if thumbnail == "" {
+			if assets, ok := act["assets"].(map[string]any); ok {
+				thumbnail = utils.GetString(assets["large_image_url"])
+			}
+		}
+		stream := &store.Streaming{
+			URL:          streamURL,
+			Platform:     platform,
+			Channel:      channel,
+			Title:        utils.GetString(act["details"]),
+			Game:         utils.GetString(act["state"]),
+			ThumbnailURL: thumbnail,
+		}
+		if start, end := utils.ExtractTimestamps(act); start != 0 || end != 0 {
+			stream.Timestamps = &store.Timestamps{Start: start, End: end}
+		}
+		prev.Streaming = stream
+		return prev
+	}
+	return prev
+}
+
+// StreamPlatform identifies the streaming platform behind a stream URL and
+// derives the channel name and a thumbnail URL where the platform exposes a
+// stable pattern. Unrecognised URLs report platform "unknown".
+func StreamPlatform(streamURL string) (platform, channel, thumbnail string) {
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return "unknown", "", ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	host = strings.TrimPrefix(host, "m.")
+	segments := strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' })
+
+	switch host {
+	case "twitch.tv":
+		if len(segments) > 0 {
+			channel = strings.ToLower(segments[0])
+			thumbnail = "https://static-cdn.jtvnw.net/previews-ttv/live_user_" + channel + "-1280x720.jpg"
+		}
+		return "twitch", channel, thumbnail
+	case "kick.com":
+		if len(segments) > 0 {
+			channel = strings.ToLower(segments[0])
+		}
+		return "kick", channel, ""
+	case "youtube.com", "youtu.be":
+		videoID := u.Query().Get("v")
+		switch {
+		case host == "youtu.be" && len(segments) > 0:
+			videoID = segments[0]
+		case len(segments) > 1 && (segments[0] == "live" || segments[0] == "shorts"):
+			videoID = segments[1]
+		case len(segments) > 0 && strings.HasPrefix(segments[0], "@"):
+			channel = strings.TrimPrefix(segments[0], "@")
+		case len(segments) > 1 && (segments[0] == "channel" || segments[0] == "c"):
+			channel = segments[1]
+		}
+		if videoID != "" {
+			thumbnail = "https://i.ytimg.com/vi/" + videoID + "/hqdefault_live.jpg"
+		}
+		return "youtube", channel, thumbnail
+	}
+	return "unknown", "", ""
+}
@@ -1,19 +1,45 @@
 package middleware
 
-import "net/http"
+import (
+	"net/http"
 
-// CORS sets permissive CORS headers for the public API and handles
-// preflight OPTIONS requests.
-func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	"tether/src/utils"
+)
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+// CORS sets CORS headers according to the origin policy and handles
+// preflight OPTIONS requests. Browser requests from origins outside the
+// policy are rejected with 403.
+func CORS(origins OriginPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if !origins.Allows(origin) {
+				utils.WriteJSON(w, http.StatusForbidden, utils.ErrorResponse(
+					"ORIGIN_NOT_ALLOWED",
+					"Origin is not allowed to access this API",
+					http.StatusForbidden,
+					false,
+					nil,
+				))
+				return
+			}
+
+			if origins.AllowsAny() {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Add("Vary", "Origin")
+				if origin != "" {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
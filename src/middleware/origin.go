@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy decides which browser origins may call the API and open
+// WebSocket connections. The zero value allows any origin.
+type OriginPolicy struct {
+	anyOrigin bool
+	exact     map[string]struct{}
+	wildcards []originWildcard
+}
+
+// originWildcard matches subdomains of host, optionally pinned to a scheme.
+type originWildcard struct {
+	scheme string
+	suffix string
+}
+
+// ParseOriginPolicy builds a policy from a comma-separated list. Entries may
+// be exact origins ("https://example.com"), wildcard subdomains
+// ("https://*.example.com" or "*.example.com"), or "*" for any origin. An
+// empty spec allows any origin.
+func ParseOriginPolicy(spec string) OriginPolicy {
+	p := OriginPolicy{exact: make(map[string]struct{})}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		entry = strings.TrimSuffix(entry, "/")
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		scheme, host, hasScheme := strings.Cut(entry, "://")
+		if !hasScheme {
+			scheme, host = "", entry
+		}
+		if after, ok := strings.CutPrefix(host, "*."); ok {
+			p.wildcards = append(p.wildcards, originWildcard{scheme: scheme, suffix: "." + after})
+			continue
+		}
+		p.exact[entry] = struct{}{}
+	}
+	return p
+}
+
+// AllowsAny reports whether the policy accepts every origin.
+func (p OriginPolicy) AllowsAny() bool {
+	return p.anyOrigin || (len(p.exact) == 0 && len(p.wildcards) == 0)
+}
+
+// Allows reports whether origin may access the API. Requests without an
+// Origin header (curl, server-to-server) are not browser-initiated and are
+// always allowed.
+func (p OriginPolicy) Allows(origin string) bool {
+	if origin == "" || p.AllowsAny() {
+		return true
+	}
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	if _, ok := p.exact[u.Scheme+"://"+u.Host]; ok {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if w.scheme != "" && w.scheme != u.Scheme {
+			continue
+		}
+		if strings.HasSuffix(u.Host, w.suffix) {
+			return true
+		}
+	}
+	return false
+}
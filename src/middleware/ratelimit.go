@@ -47,7 +47,7 @@ func RateLimitMiddleware(requestsPerSecond int, behindProxy bool) func(http.Hand
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := getClientIP(r, behindProxy)
+			ip := ClientIP(r, behindProxy)
 
 			mu.Lock()
 			c, exists := clients[ip]
@@ -77,8 +77,9 @@ func RateLimitMiddleware(requestsPerSecond int, behindProxy bool) func(http.Hand
 	}
 }
 
-// getClientIP extracts the real client IP, checking proxy headers if behindProxy is true
-func getClientIP(r *http.Request, behindProxy bool) string {
+// ClientIP extracts the real client IP, checking proxy headers if behindProxy is true.
+// It is shared with the WebSocket server so connection limits key on the same IP.
+func ClientIP(r *http.Request, behindProxy bool) string {
 	if behindProxy {
 		// Check Cloudflare-specific header (most reliable)
 		if ip := r.Header.Get("CF-Connecting-IP"); ip != "" {
@@ -0,0 +1,316 @@
+// Package mqtt publishes presence to an MQTT broker as retained topics, for
+// home automation that reacts to, say, whether someone is gaming.
+//
+// For every presence event the Bridge publishes, under its topic prefix:
+//
+//	<prefix>/<user_id>/presence   the public snapshot as JSON
+//	<prefix>/<user_id>/status     online, idle, dnd or offline
+//	<prefix>/<user_id>/game       the name of the game being played, or "none"
+//	<prefix>/<user_id>/spotify    "<artist> - <song>", or "none"
+//
+// <prefix>/bridge/state is "online" while the bridge is connected and is set
+// to "offline" by the broker (last will) if the bridge drops. Home Assistant
+// discovery messages describe one device per user with a sensor per topic.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"tether/src/concurrency"
+	"tether/src/logging"
+	"tether/src/store"
+	"tether/src/utils"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Bridge state payloads.
+const (
+	StateOnline  = "online"
+	StateOffline = "offline"
+)
+
+// none is published to derived topics that have no value; an empty retained
+// payload would delete the topic instead.
+const none = "none"
+
+// Config configures a Bridge.
+type Config struct {
+	// BrokerURL is the broker address, e.g. tcp://localhost:1883.
+	BrokerURL string
+	Username  string
+	Password  string
+	// ClientID defaults to "tether". It also namespaces discovery IDs.
+	ClientID string
+	// TopicPrefix defaults to "tether".
+	TopicPrefix string
+	// DiscoveryPrefix is the Home Assistant discovery prefix. Empty disables
+	// discovery messages.
+	DiscoveryPrefix string
+}
+
+// sensor is one derived topic and its Home Assistant entity.
+type sensor struct {
+	field string
+	name  string
+	icon  string
+}
+
+var sensors = []sensor{
+	{"status", "Status", "mdi:account-circle"},
+	{"game", "Game", "mdi:gamepad-variant"},
+	{"spotify", "Spotify", "mdi:spotify"},
+}
+
+// eventBuffer is how many presence events the bridge queues before the store
+// drops them; after a drop it republishes everything.
+const eventBuffer = 1024
+
+// Bridge publishes store events to MQTT.
+type Bridge struct {
+	st     *store.PresenceStore
+	cfg    Config
+	client paho.Client
+
+	// resync asks the publisher goroutine to republish everything after a
+	// (re)connect.
+	resync chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// discovered maps each user to the device name last sent in discovery,
+	// and published holds the users that may have retained topics: a clear
+	// sent while the connection is failing can be lost, so users leave it
+	// only once publishAll has cleared them. Only the publisher goroutine
+	// touches them.
+	discovered map[string]string
+	published  map[string]struct{}
+}
+
+// New creates a bridge for st. Call Start to connect.
+func New(st *store.PresenceStore, cfg Config) *Bridge {
+	if cfg.ClientID == "" {
+		cfg.ClientID = "tether"
+	}
+	if cfg.TopicPrefix == "" {
+		cfg.TopicPrefix = "tether"
+	}
+	cfg.TopicPrefix = strings.TrimSuffix(cfg.TopicPrefix, "/")
+	cfg.DiscoveryPrefix = strings.TrimSuffix(cfg.DiscoveryPrefix, "/")
+	b := &Bridge{
+		st:         st,
+		cfg:        cfg,
+		resync:     make(chan struct{}, 1),
+		discovered: make(map[string]string),
+		published:  make(map[string]struct{}),
+	}
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.BrokerURL).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetWill(b.stateTopic(), StateOffline, 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetOnConnectHandler(func(paho.Client) {
+			logging.Log.WithField("broker", cfg.BrokerURL).Info("mqtt bridge connected")
+			select {
+			case b.resync <- struct{}{}:
+			default:
+			}
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logging.Log.WithError(err).Warn("mqtt bridge connection lost")
+		})
+	b.client = paho.NewClient(opts)
+	return b
+}
+
+// Start subscribes to the store and connects in the background, retrying
+// until the broker is reachable.
+func (b *Bridge) Start() error {
+	if b.cfg.BrokerURL == "" {
+		return errors.New("mqtt broker url is required")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	id, events, unsubscribe := b.st.SubscribeBuffered(eventBuffer)
+
+	b.wg.Add(1)
+	concurrency.GoSafe(func() {
+		defer b.wg.Done()
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.resync:
+				b.publishAll()
+			case evt, ok := <-events:
+				if !ok {
+					return
+				}
+				if evt.Removed {
+					b.clearUser(evt.UserID)
+				} else {
+					b.publishUser(evt.UserID, evt.Presence.Public)
+				}
+				if n := b.st.Dropped(id); n > 0 {
+					logging.Log.WithField("dropped", n).Warn("mqtt bridge fell behind presence events; republishing")
+					b.publishAll()
+				}
+			}
+		}
+	})
+	b.client.Connect()
+	return nil
+}
+
+// Close marks the bridge offline and disconnects.
+func (b *Bridge) Close() {
+	if b == nil || b.cancel == nil {
+		return
+	}
+	b.cancel()
+	b.wg.Wait()
+	if b.client.IsConnectionOpen() {
+		b.client.Publish(b.stateTopic(), 1, true, StateOffline).WaitTimeout(2 * time.Second)
+	}
+	b.client.Disconnect(250)
+}
+
+// publishAll republishes the bridge state and every visible user, as after a
+// reconnect the broker may have lost its retained messages. Users published
+// earlier that are now gone or hidden are cleared, since their removal may
+// have been missed while disconnected.
+func (b *Bridge) publishAll() {
+	b.publish(b.stateTopic(), StateOnline)
+	clear(b.discovered)
+	all := b.st.GetAllPresences()
+	for userID := range b.published {
+		if p, ok := all[userID]; !ok || p.Hidden {
+			b.clearUser(userID)
+			delete(b.published, userID)
+		}
+	}
+	for userID, p := range all {
+		if !p.Hidden {
+			b.publishUser(userID, p.Public)
+		}
+	}
+}
+
+func (b *Bridge) publishUser(userID string, p store.PublicPresence) {
+	if b.cfg.DiscoveryPrefix != "" {
+		if name := deviceName(userID, p); b.discovered[userID] != name {
+			b.publishDiscovery(userID, name)
+			b.discovered[userID] = name
+		}
+	}
+	body, err := json.Marshal(p.Render(store.RenderOptions{}, time.Now()))
+	if err != nil {
+		logging.Log.WithError(err).Warn("failed to encode mqtt presence")
+		return
+	}
+	b.publish(b.userTopic(userID, "presence"), string(body))
+	b.publish(b.userTopic(userID, "status"), p.Status)
+	b.publish(b.userTopic(userID, "game"), currentGame(p))
+	b.publish(b.userTopic(userID, "spotify"), spotifyTrack(p))
+	b.published[userID] = struct{}{}
+}
+
+// clearUser deletes a hidden or removed user's retained topics and
+// discovery entities.
+func (b *Bridge) clearUser(userID string) {
+	b.publish(b.userTopic(userID, "presence"), "")
+	for _, s := range sensors {
+		b.publish(b.userTopic(userID, s.field), "")
+		if b.cfg.DiscoveryPrefix != "" {
+			b.publish(b.discoveryTopic(userID, s.field), "")
+		}
+	}
+	delete(b.discovered, userID)
+}
+
+func (b *Bridge) publishDiscovery(userID, name string) {
+	nodeID := b.cfg.ClientID + "_" + userID
+	for _, s := range sensors {
+		config := map[string]any{
+			"name":               s.name,
+			"unique_id":          nodeID + "_" + s.field,
+			"state_topic":        b.userTopic(userID, s.field),
+			"availability_topic": b.stateTopic(),
+			"icon":               s.icon,
+			"device": map[string]any{
+				"identifiers":  []string{nodeID},
+				"name":         name,
+				"manufacturer": "Tether",
+				"model":        "Discord presence",
+			},
+		}
+		if s.field == "status" {
+			config["json_attributes_topic"] = b.userTopic(userID, "presence")
+		}
+		body, _ := json.Marshal(config)
+		b.publish(b.discoveryTopic(userID, s.field), string(body))
+	}
+}
+
+// publish sends a retained QoS 1 message without waiting for the broker.
+// Messages published while disconnected are dropped; publishAll catches up
+// after the reconnect.
+func (b *Bridge) publish(topic, payload string) {
+	if !b.client.IsConnectionOpen() {
+		return
+	}
+	b.client.Publish(topic, 1, true, payload)
+}
+
+func (b *Bridge) stateTopic() string {
+	return b.cfg.TopicPrefix + "/bridge/state"
+}
+
+func (b *Bridge) userTopic(userID, field string) string {
+	return b.cfg.TopicPrefix + "/" + userID + "/" + field
+}
+
+func (b *Bridge) discoveryTopic(userID, field string) string {
+	return b.cfg.DiscoveryPrefix + "/sensor/" + b.cfg.ClientID + "_" + userID + "/" + field + "/config"
+}
+
+func deviceName(userID string, p store.PublicPresence) string {
+	switch {
+	case p.DiscordUser.GlobalName != "":
+		return p.DiscordUser.GlobalName
+	case p.DiscordUser.Username != "":
+		return p.DiscordUser.Username
+	}
+	return userID
+}
+
+// currentGame is the name of the first "playing" activity.
+func currentGame(p store.PublicPresence) string {
+	for _, a := range p.Activities {
+		if name, _ := a["name"].(string); name != "" && utils.GetInt64(a["type"]) == 0 {
+			return name
+		}
+	}
+	return none
+}
+
+func spotifyTrack(p store.PublicPresence) string {
+	s := p.Spotify
+	if s == nil || s.Song == nil {
+		return none
+	}
+	if s.Artist == nil {
+		return *s.Song
+	}
+	return *s.Artist + " - " + *s.Song
+}
@@ -0,0 +1,164 @@
+package replication
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tether/src/logging"
+	"tether/src/store"
+	"tether/src/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HeartbeatInterval is how often an idle stream carries a heartbeat frame.
+const HeartbeatInterval = 5 * time.Second
+
+// streamBuffer is how many events a stream may fall behind before it is
+// closed; the receiver then reconnects and re-bootstraps.
+const streamBuffer = 4096
+
+// Hub streams a store's mutations to receivers. It implements
+// store.Replicator and serves StreamPath.
+type Hub struct {
+	st     *store.PresenceStore
+	nodeID string
+	token  string
+
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	nodeID   string
+	events   chan store.PresenceEvent
+	overflow chan struct{}
+	once     sync.Once
+}
+
+// NewHub creates a hub for st. Receivers must present token as a bearer
+// token. Register the hub with st.AddReplicator.
+func NewHub(st *store.PresenceStore, nodeID, token string) *Hub {
+	return &Hub{st: st, nodeID: nodeID, token: token, subs: make(map[*subscriber]struct{})}
+}
+
+// Publish queues evt for every stream, except those of nodes it already
+// passed through.
+func (h *Hub) Publish(evt store.PresenceEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if slices.Contains(evt.Via, sub.nodeID) {
+			continue
+		}
+		select {
+		case sub.events <- evt:
+		default:
+			sub.once.Do(func() { close(sub.overflow) })
+		}
+	}
+	return nil
+}
+
+// Streams returns the number of connected receivers.
+func (h *Hub) Streams() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(h.token)) != 1 {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.ErrorResponse(
+			"UNAUTHORIZED",
+			"A valid replication token is required",
+			http.StatusUnauthorized,
+			false,
+			nil,
+		))
+		return
+	}
+	node := r.Header.Get(NodeHeader)
+	if node == h.nodeID {
+		utils.WriteJSON(w, http.StatusConflict, utils.ErrorResponse(
+			"REPLICATION_LOOP",
+			"A node cannot replicate from itself",
+			http.StatusConflict,
+			false,
+			map[string]any{"node_id": h.nodeID},
+		))
+		return
+	}
+
+	// Subscribe before taking the snapshot so no mutation falls between
+	// them; events the snapshot already covers are dropped by version.
+	sub := &subscriber{nodeID: node, events: make(chan store.PresenceEvent, streamBuffer), overflow: make(chan struct{})}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.subs, sub)
+		h.mu.Unlock()
+	}()
+	log := logging.Log.WithFields(logrus.Fields{"node_id": node, "remote": r.RemoteAddr})
+	log.Info("replication stream opened")
+	defer log.Info("replication stream closed")
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	write := func(f Frame) bool {
+		f.SentAt = nowMillis()
+		_ = rc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := enc.Encode(f); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	if !write(Frame{Type: FrameHello, NodeID: h.nodeID}) {
+		return
+	}
+	version := h.st.CurrentVersion()
+	for userID, p := range h.st.GetAllPresences() {
+		if p.Hidden {
+			continue
+		}
+		evt := fromStore(store.PresenceEvent{UserID: userID, Presence: p, Version: p.Version}, h.nodeID)
+		if !write(Frame{Type: FrameEvent, Event: &evt}) {
+			return
+		}
+	}
+	if !write(Frame{Type: FrameSynced, Version: &version}) {
+		return
+	}
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.overflow:
+			log.Warn("replication stream fell behind; closing it")
+			return
+		case evt := <-sub.events:
+			out := fromStore(evt, h.nodeID)
+			if !write(Frame{Type: FrameEvent, Event: &out}) {
+				return
+			}
+		case <-heartbeat.C:
+			if !write(Frame{Type: FrameHeartbeat}) {
+				return
+			}
+		}
+	}
+}
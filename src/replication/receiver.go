@@ -0,0 +1,320 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tether/src/concurrency"
+	"tether/src/logging"
+	"tether/src/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxFrame caps one stream line; a snapshot event is one user's presence.
+const maxFrame = 4 << 20
+
+// ReceiverConfig configures a Receiver.
+type ReceiverConfig struct {
+	// Source is the base URL of the node to follow, e.g. http://leader:8080.
+	// It may be empty and set later with Follow.
+	Source string
+	// Token is the source's replication token.
+	Token string
+	// NodeID identifies this node to the source.
+	NodeID string
+	// StallTimeout reconnects when the stream is silent for this long
+	// (default three heartbeats).
+	StallTimeout time.Duration
+	// Client opens the stream (default: a client without a timeout).
+	Client *http.Client
+}
+
+// Status describes a receiver's connection to its source.
+type Status struct {
+	Source     string `json:"source"`
+	SourceNode string `json:"source_node,omitempty"`
+	Connected  bool   `json:"connected"`
+	// Synced is true once the current stream's snapshot has been applied.
+	Synced bool `json:"synced"`
+	// LastMessage is when the last frame arrived.
+	LastMessage time.Time `json:"last_message"`
+	// Staleness is how long ago LastMessage was, on this node's clock. It
+	// keeps growing while the stream stalls; heartbeats reset it every
+	// HeartbeatInterval on an idle stream.
+	Staleness time.Duration `json:"staleness"`
+	// Lag is how long the last frame took from source to receiver, as
+	// measured by the two clocks. It is informational: clock skew shifts
+	// it, and it is not updated while no frames arrive.
+	Lag time.Duration `json:"lag"`
+	// LastError is why the previous stream ended.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Receiver follows a source node's replication stream into a local store.
+type Receiver struct {
+	st  *store.PresenceStore
+	cfg ReceiverConfig
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	status Status
+	source string
+	// stopStream ends the current stream when the source changes.
+	stopStream context.CancelFunc
+	// adopt makes the next stream own every user already in the store, so
+	// its snapshot replaces data this node produced itself.
+	adopt bool
+	wake  chan struct{}
+
+	// owned is every user received from the source; only the stream
+	// goroutine touches it.
+	owned map[string]struct{}
+}
+
+// NewReceiver creates a receiver for st. Call Start to connect.
+func NewReceiver(st *store.PresenceStore, cfg ReceiverConfig) *Receiver {
+	cfg.Source = strings.TrimSuffix(cfg.Source, "/")
+	if cfg.StallTimeout <= 0 {
+		cfg.StallTimeout = 3 * HeartbeatInterval
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{}
+	}
+	return &Receiver{
+		st:     st,
+		cfg:    cfg,
+		status: Status{Source: cfg.Source},
+		source: cfg.Source,
+		wake:   make(chan struct{}, 1),
+		owned:  make(map[string]struct{}),
+	}
+}
+
+// Start follows the source in the background, reconnecting with backoff.
+func (r *Receiver) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.wg.Add(1)
+	concurrency.GoSafe(func() {
+		defer r.wg.Done()
+		backoff := time.Second
+		for ctx.Err() == nil {
+			r.mu.Lock()
+			source := r.source
+			r.mu.Unlock()
+			if source == "" {
+				select {
+				case <-ctx.Done():
+					return
+				case <-r.wake:
+					continue
+				}
+			}
+
+			synced, err := r.stream(ctx, source)
+			r.mu.Lock()
+			switched := r.source != source
+			if !switched {
+				r.status.Connected, r.status.Synced = false, false
+				if err != nil {
+					r.status.LastError = err.Error()
+				}
+			}
+			r.mu.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+			if synced || switched {
+				backoff = time.Second
+			}
+			if switched {
+				continue
+			}
+			logging.Log.WithError(err).WithField("source", source).Warn("replication stream ended; reconnecting")
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.wake:
+				backoff = time.Second
+			case <-time.After(backoff):
+				backoff = min(backoff*2, 30*time.Second)
+			}
+		}
+	})
+}
+
+// Follow switches to a new source, or stops following when source is
+// empty. The new source's snapshot replaces every user in the store,
+// including ones this node added itself (e.g. while it was the leader).
+func (r *Receiver) Follow(source string) {
+	source = strings.TrimSuffix(source, "/")
+	r.mu.Lock()
+	if source == r.source {
+		r.mu.Unlock()
+		return
+	}
+	r.source = source
+	r.status = Status{Source: source}
+	r.adopt = source != ""
+	if r.stopStream != nil {
+		r.stopStream()
+	}
+	r.mu.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops following the source.
+func (r *Receiver) Close() {
+	if r == nil || r.cancel == nil {
+		return
+	}
+	r.cancel()
+	r.wg.Wait()
+}
+
+// Status returns the current connection status. A nil receiver reports a
+// zero Status.
+func (r *Receiver) Status() Status {
+	if r == nil {
+		return Status{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.status
+	if !out.LastMessage.IsZero() {
+		out.Staleness = time.Since(out.LastMessage)
+	}
+	return out
+}
+
+// stream reads one connection until it fails. It reports whether the
+// snapshot was applied.
+func (r *Receiver) stream(ctx context.Context, source string) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r.mu.Lock()
+	if r.source != source {
+		r.mu.Unlock()
+		return false, nil
+	}
+	r.stopStream = cancel
+	if r.adopt {
+		r.adopt = false
+		for userID := range r.st.GetAllPresences() {
+			r.owned[userID] = struct{}{}
+		}
+	}
+	r.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source+StreamPath, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+	req.Header.Set(NodeHeader, r.cfg.NodeID)
+	resp, err := r.cfg.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return false, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	// A silent stream is treated as broken.
+	stall := time.AfterFunc(r.cfg.StallTimeout, cancel)
+	defer stall.Stop()
+
+	r.mu.Lock()
+	if r.source == source {
+		r.status.Connected, r.status.LastError = true, ""
+	}
+	r.mu.Unlock()
+
+	synced := false
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), maxFrame)
+	for scanner.Scan() {
+		stall.Reset(r.cfg.StallTimeout)
+		var f Frame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			return synced, fmt.Errorf("decode frame: %w", err)
+		}
+		r.received(f)
+
+		switch f.Type {
+		case FrameHello:
+			if f.NodeID == r.cfg.NodeID {
+				return false, errors.New("source is this node")
+			}
+			r.mu.Lock()
+			r.status.SourceNode = f.NodeID
+			r.mu.Unlock()
+		case FrameEvent:
+			if f.Event == nil || slices.Contains(f.Event.Via, r.cfg.NodeID) {
+				continue
+			}
+			evt := f.Event.toStore()
+			r.st.ApplyReplicated(evt)
+			if evt.Removed {
+				delete(r.owned, evt.UserID)
+			} else {
+				r.owned[evt.UserID] = struct{}{}
+			}
+			if !synced {
+				seen[evt.UserID] = struct{}{}
+			}
+		case FrameSynced:
+			if f.Version != nil {
+				r.dropMissing(seen, *f.Version)
+			}
+			synced = true
+			r.mu.Lock()
+			r.status.Synced = true
+			r.mu.Unlock()
+			logging.Log.WithFields(logrus.Fields{"source": source, "users": len(seen)}).Info("replication snapshot applied")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return synced, err
+	}
+	return synced, io.ErrUnexpectedEOF
+}
+
+// dropMissing removes users received earlier that the new snapshot no
+// longer contains.
+func (r *Receiver) dropMissing(seen map[string]struct{}, version store.Version) {
+	for userID := range r.owned {
+		if _, ok := seen[userID]; ok {
+			continue
+		}
+		r.st.ApplyReplicated(store.PresenceEvent{UserID: userID, Removed: true, Version: version})
+		delete(r.owned, userID)
+	}
+}
+
+func (r *Receiver) received(f Frame) {
+	now := time.Now()
+	lag := now.Sub(time.UnixMilli(f.SentAt))
+	r.mu.Lock()
+	r.status.LastMessage = now
+	r.status.Lag = max(lag, 0)
+	r.mu.Unlock()
+}
@@ -0,0 +1,105 @@
+// Package replication keeps read-only Tether nodes in sync with a node that
+// holds the Discord session.
+//
+// A Hub is registered as the source store's Replicator and serves
+// GET /v1/replication/stream: newline-delimited JSON frames made of a hello,
+// a full snapshot of the store as event frames, a synced marker, then live
+// events and periodic heartbeats. A Receiver follows one source's stream and
+// applies events to its own store with ApplyReplicated, reconnecting (and so
+// re-bootstrapping) whenever the stream breaks.
+//
+// Events carry their per-user version, so late or duplicated events are
+// ignored, and the IDs of the nodes they passed through, so nodes that both
+// serve and follow streams never send an event back where it came from.
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"slices"
+	"time"
+
+	"tether/src/store"
+)
+
+// Frame types.
+const (
+	FrameHello     = "hello"
+	FrameEvent     = "event"
+	FrameSynced    = "synced"
+	FrameHeartbeat = "heartbeat"
+)
+
+// NodeHeader carries the ID of the node opening a stream.
+const NodeHeader = "X-Tether-Node"
+
+// StreamPath is where a Hub is mounted.
+const StreamPath = "/v1/replication/stream"
+
+// Frame is one line of a replication stream.
+type Frame struct {
+	Type string `json:"type"`
+	// SentAt is when the source wrote the frame, in Unix milliseconds.
+	SentAt int64 `json:"sent_at"`
+	// NodeID is the source node's ID (hello).
+	NodeID string `json:"node_id,omitempty"`
+	// Version is the source store's version when the snapshot was taken
+	// (synced). Users missing from the snapshot are removed at it.
+	Version *store.Version `json:"version,omitempty"`
+	Event   *Event         `json:"event,omitempty"`
+}
+
+// Event is a replicated store mutation.
+type Event struct {
+	UserID   string        `json:"user_id"`
+	Removed  bool          `json:"removed,omitempty"`
+	Version  store.Version `json:"version"`
+	Via      []string      `json:"via"`
+	Presence *Presence     `json:"presence,omitempty"`
+}
+
+// Presence is the public snapshot as computed by the origin node.
+type Presence struct {
+	Public store.PublicPresence `json:"public"`
+	// TypedActivities is not part of the snapshot's JSON, so it travels
+	// separately.
+	TypedActivities []store.PublicActivity `json:"typed_activities,omitempty"`
+}
+
+// DefaultNodeID is the host name, or a random ID when it is unavailable.
+func DefaultNodeID() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	buf := make([]byte, 4)
+	_, _ = rand.Read(buf)
+	return "tether-" + hex.EncodeToString(buf)
+}
+
+// fromStore converts a store event for sending from nodeID.
+func fromStore(evt store.PresenceEvent, nodeID string) Event {
+	via := slices.Clone(evt.Via)
+	if len(via) == 0 || via[len(via)-1] != nodeID {
+		via = append(via, nodeID)
+	}
+	out := Event{UserID: evt.UserID, Removed: evt.Removed, Version: evt.Version, Via: via}
+	if !evt.Removed {
+		out.Presence = &Presence{Public: evt.Presence.Public, TypedActivities: evt.Presence.Public.TypedActivities}
+	}
+	return out
+}
+
+// toStore converts a received event for ApplyReplicated.
+func (e Event) toStore() store.PresenceEvent {
+	out := store.PresenceEvent{UserID: e.UserID, Removed: e.Removed || e.Presence == nil, Version: e.Version, Via: e.Via}
+	if !out.Removed {
+		out.Presence.Public = e.Presence.Public
+		out.Presence.Public.TypedActivities = e.Presence.TypedActivities
+	}
+	return out
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
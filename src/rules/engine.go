@@ -0,0 +1,298 @@
+package rules
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tether/src/logging"
+	"tether/src/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EventRuleMatch is the webhook event kind sent by webhook actions.
+const EventRuleMatch = "rule_match"
+
+// Match describes one rule firing. It is the data for message templates and
+// the payload of webhook actions.
+type Match struct {
+	Rule     string    `json:"rule"`
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username,omitempty"`
+	Status   string    `json:"status"`
+	Activity string    `json:"activity,omitempty"`
+	Since    time.Time `json:"since"`
+	At       time.Time `json:"at"`
+}
+
+// Notifier delivers an event to a registered webhook; see
+// webhooks.Dispatcher.Notify.
+type Notifier interface {
+	Notify(webhookID, userID, event string, data any) error
+}
+
+// Actions are the side effects available to rules. Nil members make the
+// corresponding action types log an error instead.
+type Actions struct {
+	// SendMessage posts content to a Discord channel.
+	SendMessage func(channelID, content string) error
+	Webhooks    Notifier
+}
+
+// State is one rule's state for one user.
+type State struct {
+	Rule      string     `json:"rule"`
+	UserID    string     `json:"user_id"`
+	Matching  bool       `json:"matching"`
+	Since     *time.Time `json:"since,omitempty"`
+	Fired     bool       `json:"fired"`
+	LastFired *time.Time `json:"last_fired,omitempty"`
+}
+
+type stateKey struct{ rule, user string }
+
+type ruleState struct {
+	matching  bool
+	since     time.Time
+	fired     bool
+	lastFired time.Time
+	match     Match
+}
+
+// Engine evaluates rules. Time is explicit: Observe records snapshots at a
+// given time and Advance fires matches whose debounce has elapsed, so the
+// same engine runs live (Run) and over recordings (dry run).
+type Engine struct {
+	rules   []Rule
+	actions Actions
+	dryRun  bool
+
+	mu      sync.Mutex
+	state   map[stateKey]*ruleState
+	matches []Match
+}
+
+// New creates an engine for rules loaded with LoadFile. In dry-run mode
+// matches are only recorded (see Matches); actions never run.
+func New(rules []Rule, actions Actions, dryRun bool) *Engine {
+	return &Engine{
+		rules:   rules,
+		actions: actions,
+		dryRun:  dryRun,
+		state:   make(map[stateKey]*ruleState),
+	}
+}
+
+// Rules returns the loaded rules.
+func (e *Engine) Rules() []Rule {
+	return slices.Clone(e.rules)
+}
+
+// Observe evaluates every rule against one presence event at time at.
+// Matches that became due before the event fire first, so an event that ends
+// a match never hides an elapsed debounce.
+func (e *Engine) Observe(evt store.PresenceEvent, at time.Time) {
+	e.Advance(at)
+	e.mu.Lock()
+	for _, r := range e.rules {
+		if !r.appliesTo(evt.UserID) {
+			continue
+		}
+		key := stateKey{r.ID, evt.UserID}
+		ok, activity := false, ""
+		if !evt.Removed {
+			ok, activity = r.When.matches(evt.Presence.Public)
+		}
+		st := e.state[key]
+		if st == nil {
+			if !ok {
+				continue
+			}
+			st = &ruleState{}
+			e.state[key] = st
+		}
+		switch {
+		case ok && !st.matching:
+			st.matching, st.since, st.fired = true, at, false
+		case !ok:
+			st.matching = false
+		}
+		st.match = Match{
+			Rule:     r.ID,
+			UserID:   evt.UserID,
+			Username: evt.Presence.Public.DiscordUser.Username,
+			Status:   evt.Presence.Public.Status,
+			Activity: activity,
+			Since:    st.since,
+		}
+	}
+	e.mu.Unlock()
+	e.Advance(at)
+}
+
+// Advance fires every match whose condition has held for its debounce by
+// now, unless the user's cooldown for that rule is still running. A match
+// fires at since+debounce, however late Advance is called.
+func (e *Engine) Advance(now time.Time) {
+	var due []struct {
+		rule  Rule
+		match Match
+	}
+	e.mu.Lock()
+	keys := slices.Collect(maps.Keys(e.state))
+	slices.SortFunc(keys, func(a, b stateKey) int { return strings.Compare(a.user, b.user) })
+	for _, r := range e.rules {
+		for _, key := range keys {
+			st := e.state[key]
+			if key.rule != r.ID || !st.matching || st.fired {
+				continue
+			}
+			at := st.since.Add(time.Duration(r.Debounce))
+			if now.Before(at) {
+				continue
+			}
+			// A match is evaluated once; one suppressed by the cooldown is
+			// skipped rather than fired when the cooldown ends.
+			st.fired = true
+			if !st.lastFired.IsZero() && at.Sub(st.lastFired) < time.Duration(r.Cooldown) {
+				continue
+			}
+			st.lastFired = at
+			match := st.match
+			match.At = at
+			e.matches = append(e.matches, match)
+			due = append(due, struct {
+				rule  Rule
+				match Match
+			}{r, match})
+		}
+	}
+	e.mu.Unlock()
+
+	for _, d := range due {
+		e.fire(d.rule, d.match)
+	}
+}
+
+// Matches returns every match fired so far, in order.
+func (e *Engine) Matches() []Match {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.matches)
+}
+
+// State returns the per-user state of every rule, sorted by rule and user.
+func (e *Engine) State() []State {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]State, 0, len(e.state))
+	for key, st := range e.state {
+		s := State{Rule: key.rule, UserID: key.user, Matching: st.matching, Fired: st.fired}
+		if st.matching {
+			since := st.since
+			s.Since = &since
+		}
+		if !st.lastFired.IsZero() {
+			last := st.lastFired
+			s.LastFired = &last
+		}
+		out = append(out, s)
+	}
+	slices.SortFunc(out, func(a, b State) int {
+		if c := strings.Compare(a.Rule, b.Rule); c != 0 {
+			return c
+		}
+		return strings.Compare(a.UserID, b.UserID)
+	})
+	return out
+}
+
+// eventBuffer is how many presence events Run queues before the store drops
+// them; after a drop Run re-evaluates every user from the store.
+const eventBuffer = 1024
+
+// Run evaluates rules against st until ctx is cancelled, checking debounces
+// once per second.
+func (e *Engine) Run(ctx context.Context, st *store.PresenceStore) {
+	id, events, unsubscribe := st.SubscribeBuffered(eventBuffer)
+	defer unsubscribe()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-events:
+			if !ok {
+				return
+			}
+			e.Observe(evt, time.Now())
+			if n := st.Dropped(id); n > 0 {
+				logging.Log.WithField("dropped", n).Warn("rules fell behind presence events; re-evaluating every user")
+				e.resync(st, time.Now())
+			}
+		case now := <-ticker.C:
+			e.Advance(now)
+		}
+	}
+}
+
+// resync observes every user's current snapshot in st, and a removal for
+// users the engine tracks that st no longer has, after events were missed.
+func (e *Engine) resync(st *store.PresenceStore, now time.Time) {
+	all := st.GetAllPresences()
+	for userID, p := range all {
+		e.Observe(store.PresenceEvent{UserID: userID, Presence: p, Removed: p.Hidden}, now)
+	}
+	e.mu.Lock()
+	var gone []string
+	for key := range e.state {
+		if _, ok := all[key.user]; !ok && !slices.Contains(gone, key.user) {
+			gone = append(gone, key.user)
+		}
+	}
+	e.mu.Unlock()
+	for _, userID := range gone {
+		e.Observe(store.PresenceEvent{UserID: userID, Removed: true}, now)
+	}
+}
+
+// fire runs the rule's actions for a match.
+func (e *Engine) fire(r Rule, m Match) {
+	log := logging.Log.WithFields(logrus.Fields{"rule": r.ID, "user_id": m.UserID, "at": m.At})
+	if e.dryRun {
+		log.Info("rule matched (dry run)")
+		return
+	}
+	for _, a := range r.Actions {
+		var msg strings.Builder
+		if err := a.tmpl.Execute(&msg, m); err != nil {
+			log.WithError(err).Warn("failed to render rule message")
+			continue
+		}
+		var err error
+		switch a.Type {
+		case ActionLog:
+			log.Info(msg.String())
+		case ActionWebhook:
+			if e.actions.Webhooks == nil {
+				log.Error("rule webhook action without webhooks configured")
+				continue
+			}
+			err = e.actions.Webhooks.Notify(a.WebhookID, m.UserID, EventRuleMatch, m)
+		case ActionDiscord:
+			if e.actions.SendMessage == nil {
+				log.Error("rule discord action without a Discord session")
+				continue
+			}
+			err = e.actions.SendMessage(a.ChannelID, msg.String())
+		}
+		if err != nil {
+			log.WithError(err).WithField("action", a.Type).Warn("rule action failed")
+		}
+	}
+}
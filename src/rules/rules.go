@@ -0,0 +1,179 @@
+// Package rules evaluates declarative presence rules against the store's
+// event stream, e.g. "when user X starts playing Y" or "when user X has been
+// offline for more than 30 minutes", and runs their actions on a match.
+//
+// A rule's condition is a predicate over a user's public snapshot. A match
+// starts when the condition becomes true, fires once it has held for the
+// rule's debounce, and fires at most once per match. The cooldown suppresses
+// further matches for the same user for a while after a firing.
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"tether/src/store"
+	"tether/src/utils"
+)
+
+// Action types.
+const (
+	ActionLog     = "log"
+	ActionWebhook = "webhook"
+	ActionDiscord = "discord"
+)
+
+// Rule is one declarative rule, as stored in RULES_FILE.
+type Rule struct {
+	ID string `json:"id"`
+	// UserIDs limits the rule to these users; empty matches every user.
+	UserIDs []string  `json:"user_ids,omitempty"`
+	When    Condition `json:"when"`
+	// Debounce is how long the condition must hold before the rule fires.
+	Debounce Duration `json:"debounce,omitempty"`
+	// Cooldown is the minimum time between firings for the same user.
+	Cooldown Duration `json:"cooldown,omitempty"`
+	Actions  []Action `json:"actions"`
+}
+
+// Condition is a predicate over a user's public snapshot. Every set field
+// must match.
+type Condition struct {
+	// Status is one of online, idle, dnd or offline.
+	Status string `json:"status,omitempty"`
+	// ActivityName matches any activity name, case-insensitively.
+	ActivityName string `json:"activity_name,omitempty"`
+	// ActivityType matches an activity's type_name (playing, streaming, ...).
+	ActivityType string `json:"activity_type,omitempty"`
+}
+
+// Action is run when a rule fires.
+type Action struct {
+	Type string `json:"type"`
+	// ChannelID is the Discord channel for discord actions.
+	ChannelID string `json:"channel_id,omitempty"`
+	// WebhookID names a registered webhook for webhook actions.
+	WebhookID string `json:"webhook_id,omitempty"`
+	// Message is a text/template rendered with Match; used by discord and
+	// log actions.
+	Message string `json:"message,omitempty"`
+
+	tmpl *template.Template
+}
+
+// defaultMessage is used by actions without a Message.
+const defaultMessage = "Rule {{.Rule}} matched for {{.Username}} ({{.UserID}})"
+
+// Duration is a time.Duration written as a Go duration string ("30m").
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("durations must be strings such as \"30m\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadFile reads and validates rules from a JSON array file. A missing file
+// yields no rules.
+func LoadFile(path string) ([]Rule, error) {
+	var rules []Rule
+	if err := utils.LoadJSONFile(path, &rules); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(rules))
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rules[i].ID, err)
+		}
+		if _, dup := seen[rules[i].ID]; dup {
+			return nil, fmt.Errorf("rule %q: duplicate id", rules[i].ID)
+		}
+		seen[rules[i].ID] = struct{}{}
+	}
+	return rules, nil
+}
+
+// compile validates the rule and parses its message templates.
+func (r *Rule) compile() error {
+	if r.ID == "" {
+		return errors.New("id is required")
+	}
+	if r.When == (Condition{}) {
+		return errors.New("when needs at least one condition")
+	}
+	if len(r.Actions) == 0 {
+		return errors.New("at least one action is required")
+	}
+	for i := range r.Actions {
+		a := &r.Actions[i]
+		switch a.Type {
+		case ActionLog:
+		case ActionWebhook:
+			if a.WebhookID == "" {
+				return errors.New("webhook actions need webhook_id")
+			}
+		case ActionDiscord:
+			if a.ChannelID == "" {
+				return errors.New("discord actions need channel_id")
+			}
+		default:
+			return fmt.Errorf("unknown action type %q", a.Type)
+		}
+		msg := a.Message
+		if msg == "" {
+			msg = defaultMessage
+		}
+		tmpl, err := template.New(r.ID).Parse(msg)
+		if err != nil {
+			return err
+		}
+		a.tmpl = tmpl
+	}
+	return nil
+}
+
+// appliesTo reports whether the rule watches userID.
+func (r Rule) appliesTo(userID string) bool {
+	return len(r.UserIDs) == 0 || slices.Contains(r.UserIDs, userID)
+}
+
+// matches evaluates the condition against a public snapshot. It returns the
+// name of the matching activity, if the condition involves one.
+func (c Condition) matches(p store.PublicPresence) (bool, string) {
+	if c.Status != "" && !strings.EqualFold(p.Status, c.Status) {
+		return false, ""
+	}
+	if c.ActivityName == "" && c.ActivityType == "" {
+		return true, ""
+	}
+	for _, a := range p.Activities {
+		name, _ := a["name"].(string)
+		typeName, _ := a["type_name"].(string)
+		if c.ActivityName != "" && !strings.EqualFold(name, c.ActivityName) {
+			continue
+		}
+		if c.ActivityType != "" && !strings.EqualFold(typeName, c.ActivityType) {
+			continue
+		}
+		return true, name
+	}
+	return false, ""
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"time"
+
+	"tether/src/utils"
+)
+
+// Activity schema versions selectable by REST (?activity_schema=) and the
+// WebSocket INITIALIZE payload (activity_schema). The raw schema passes
+// Discord's activity objects through untouched; v1 is Tether's typed model,
+// which only changes in a new version.
+const (
+	ActivitySchemaRaw = "raw"
+	ActivitySchemaV1  = "v1"
+)
+
+// ValidActivitySchema reports whether schema names a supported activity
+// schema. The empty string selects the raw default.
+func ValidActivitySchema(schema string) bool {
+	switch schema {
+	case "", ActivitySchemaRaw, ActivitySchemaV1:
+		return true
+	}
+	return false
+}
+
+// PublicActivity is the typed (v1) public activity model.
+type PublicActivity struct {
+	Type          int             `json:"type"`
+	TypeName      string          `json:"type_name"`
+	Name          string          `json:"name"`
+	Details       string          `json:"details,omitempty"`
+	State         string          `json:"state,omitempty"`
+	URL           string          `json:"url,omitempty"`
+	ApplicationID string          `json:"application_id,omitempty"`
+	CreatedAt     int64           `json:"created_at,omitempty"`
+	Timestamps    *Timestamps     `json:"timestamps,omitempty"`
+	Assets        *ActivityAssets `json:"assets,omitempty"`
+	Party         *ActivityParty  `json:"party,omitempty"`
+	Buttons       []string        `json:"buttons,omitempty"`
+	Emoji         *ActivityEmoji  `json:"emoji,omitempty"`
+	// Application is resolved from the application metadata cache.
+	Application *ActivityApplication `json:"application,omitempty"`
+	// Timing fields are computed when the activity is sent; see Render.
+	ElapsedMs   *int64   `json:"elapsed_ms,omitempty"`
+	RemainingMs *int64   `json:"remaining_ms,omitempty"`
+	Progress    *float64 `json:"progress,omitempty"`
+}
+
+// ActivityApplication is the canonical metadata of an activity's application.
+type ActivityApplication struct {
+	ID            string `json:"id"`
+	Name          string `json:"name,omitempty"`
+	IconURL       string `json:"icon_url,omitempty"`
+	CoverImageURL string `json:"cover_image_url,omitempty"`
+}
+
+// ActivityAssets holds an activity's images with resolved CDN URLs.
+type ActivityAssets struct {
+	LargeImage    string `json:"large_image,omitempty"`
+	LargeImageURL string `json:"large_image_url,omitempty"`
+	LargeText     string `json:"large_text,omitempty"`
+	SmallImage    string `json:"small_image,omitempty"`
+	SmallImageURL string `json:"small_image_url,omitempty"`
+	SmallText     string `json:"small_text,omitempty"`
+}
+
+// ActivityParty describes the party an activity belongs to.
+type ActivityParty struct {
+	ID          string `json:"id,omitempty"`
+	CurrentSize int    `json:"current_size,omitempty"`
+	MaxSize     int    `json:"max_size,omitempty"`
+}
+
+// ActivityEmoji is the emoji attached to a custom status.
+type ActivityEmoji struct {
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Animated bool   `json:"animated,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// typedPublicPresence serves PublicPresence with typed activities; the outer
+// Activities field shadows the embedded raw list when marshaled.
+type typedPublicPresence struct {
+	PublicPresence
+	Activities []PublicActivity `json:"activities"`
+}
+
+// RenderOptions are the per-request (REST) or per-connection (WebSocket)
+// choices applied when a snapshot is serialised.
+type RenderOptions struct {
+	// Schema is one of the ActivitySchema constants; empty selects raw.
+	Schema string
+	// CDN overrides the deployment's utils.CDNDefaults.
+	CDN utils.CDNOptions
+}
+
+// Render returns the snapshot to serialise for opts, with CDN URLs rewritten
+// and activity and Spotify timing computed against now.
+func (p PublicPresence) Render(opts RenderOptions, now time.Time) any {
+	p = p.withCDN(utils.CDNDefaults().Merge(opts.CDN))
+	p.Spotify = p.Spotify.withTiming(now)
+	if opts.Schema == ActivitySchemaV1 {
+		typed := make([]PublicActivity, len(p.TypedActivities))
+		for i, a := range p.TypedActivities {
+			if a.Timestamps != nil {
+				a.ElapsedMs, a.RemainingMs, a.Progress = computeTiming(a.Timestamps.Start, a.Timestamps.End, now)
+			}
+			typed[i] = a
+		}
+		return typedPublicPresence{PublicPresence: p, Activities: typed}
+	}
+	acts := make([]Activity, len(p.Activities))
+	for i, a := range p.Activities {
+		acts[i] = a.withTiming(now)
+	}
+	p.Activities = acts
+	return p
+}
+
+// Render returns the stream to serialise for opts, with its thumbnail URL
+// rewritten like the presence it came from.
+func (s *Streaming) Render(opts RenderOptions) *Streaming {
+	return s.withCDN(utils.CDNDefaults().Merge(opts.CDN))
+}
+
+// CustomStatus is the user's custom status, lifted out of the type-4
+// activity so clients need not search for it.
+type CustomStatus struct {
+	Text  string             `json:"text,omitempty"`
+	Emoji *CustomStatusEmoji `json:"emoji,omitempty"`
+	// ExpiresAt is when the status clears, in milliseconds since the Unix
+	// epoch, when the user set an expiry.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// CustomStatusEmoji is a custom status's emoji, keyed like the raw emoji
+// objects in activities.
+type CustomStatusEmoji struct {
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Animated bool   `json:"animated,omitempty"`
+	EmojiURL string `json:"emoji_url,omitempty"`
+}
+
+// Streaming describes a live stream, lifted out of the type-1 activity.
+type Streaming struct {
+	URL string `json:"url"`
+	// Platform is "twitch", "youtube", "kick" or "unknown".
+	Platform     string      `json:"platform"`
+	Channel      string      `json:"channel,omitempty"`
+	Title        string      `json:"title,omitempty"`
+	Game         string      `json:"game,omitempty"`
+	ThumbnailURL string      `json:"thumbnail_url,omitempty"`
+	Timestamps   *Timestamps `json:"timestamps,omitempty"`
+}
@@ -0,0 +1,86 @@
+package store
+
+import "tether/src/utils"
+
+// withCDN returns a copy of the snapshot with every Discord CDN URL
+// rewritten by opts. The shared snapshot is left untouched.
+func (p PublicPresence) withCDN(opts utils.CDNOptions) PublicPresence {
+	if opts.IsZero() {
+		return p
+	}
+	p.DiscordUser.AvatarURL = utils.RewriteCDNURL(p.DiscordUser.AvatarURL, opts)
+	p.DiscordUser.BannerURL = utils.RewriteCDNURL(p.DiscordUser.BannerURL, opts)
+	p.DiscordUser.AvatarDecorationData = utils.RewriteCDNURLs(p.DiscordUser.AvatarDecorationData, opts)
+	p.DiscordUser.PrimaryGuild = utils.RewriteCDNURLs(p.DiscordUser.PrimaryGuild, opts)
+	badges := make([]utils.Badge, len(p.DiscordUser.Badges))
+	for i, b := range p.DiscordUser.Badges {
+		b.IconURL = utils.RewriteCDNURL(b.IconURL, opts)
+		badges[i] = b
+	}
+	p.DiscordUser.Badges = badges
+
+	acts := make([]Activity, len(p.Activities))
+	for i, a := range p.Activities {
+		acts[i] = Activity(utils.RewriteCDNURLs(map[string]any(a), opts).(map[string]any))
+	}
+	p.Activities = acts
+
+	typed := make([]PublicActivity, len(p.TypedActivities))
+	for i, a := range p.TypedActivities {
+		if a.Assets != nil {
+			assets := *a.Assets
+			assets.LargeImageURL = utils.RewriteCDNURL(assets.LargeImageURL, opts)
+			assets.SmallImageURL = utils.RewriteCDNURL(assets.SmallImageURL, opts)
+			a.Assets = &assets
+		}
+		a.Emoji = a.Emoji.withCDN(opts)
+		if a.Application != nil {
+			app := *a.Application
+			app.IconURL = utils.RewriteCDNURL(app.IconURL, opts)
+			app.CoverImageURL = utils.RewriteCDNURL(app.CoverImageURL, opts)
+			a.Application = &app
+		}
+		typed[i] = a
+	}
+	p.TypedActivities = typed
+
+	if p.CustomStatus != nil {
+		status := *p.CustomStatus
+		status.Emoji = status.Emoji.withCDN(opts)
+		p.CustomStatus = &status
+	}
+	if p.Member != nil {
+		member := *p.Member
+		member.AvatarURL = utils.RewriteCDNURL(member.AvatarURL, opts)
+		p.Member = &member
+	}
+	p.Streaming = p.Streaming.withCDN(opts)
+	return p
+}
+
+func (s *Streaming) withCDN(opts utils.CDNOptions) *Streaming {
+	if s == nil {
+		return nil
+	}
+	out := *s
+	out.ThumbnailURL = utils.RewriteCDNURL(out.ThumbnailURL, opts)
+	return &out
+}
+
+func (e *ActivityEmoji) withCDN(opts utils.CDNOptions) *ActivityEmoji {
+	if e == nil {
+		return nil
+	}
+	out := *e
+	out.URL = utils.RewriteCDNURL(out.URL, opts)
+	return &out
+}
+
+func (e *CustomStatusEmoji) withCDN(opts utils.CDNOptions) *CustomStatusEmoji {
+	if e == nil {
+		return nil
+	}
+	out := *e
+	out.EmojiURL = utils.RewriteCDNURL(out.EmojiURL, opts)
+	return &out
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"maps"
+)
+
+// Limits for user-owned key/value metadata.
+const (
+	MaxKVKeys        = 32
+	MaxKVKeyLength   = 64
+	MaxKVValueLength = 512
+)
+
+var (
+	ErrKVInvalidKey    = errors.New("kv keys must be 1-64 characters of letters, digits or underscores")
+	ErrKVValueTooLong  = errors.New("kv value exceeds 512 bytes")
+	ErrKVTooManyKeys   = errors.New("kv store is limited to 32 keys per user")
+	ErrKVKeyNotPresent = errors.New("kv key does not exist")
+)
+
+// ValidKVKey reports whether key satisfies the KV naming rules.
+func ValidKVKey(key string) bool {
+	if key == "" || len(key) > MaxKVKeyLength {
+		return false
+	}
+	for _, ch := range key {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// LoadKV reads persisted key/value metadata from path, replacing what is in
+// memory, and saves future changes back to it.
+func (s *PresenceStore) LoadKV(path string) error {
+	entries := make(map[string]map[string]string)
+	if err := loadJSONFile(path, &entries); err != nil {
+		return err
+	}
+	maps.DeleteFunc(entries, func(_ string, kv map[string]string) bool { return len(kv) == 0 })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.kvFile == nil || s.kvFile.path != path {
+		s.kvFile = &jsonFile{path: path, what: "kv store"}
+	}
+	clear(s.kv)
+	maps.Copy(s.kv, entries)
+	s.renormalizeAll()
+	return nil
+}
+
+// GetKV returns a copy of the user's key/value metadata.
+func (s *PresenceStore) GetKV(userID string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.kv[userID])
+}
+
+// SetKV stores a key for userID and broadcasts the updated presence.
+func (s *PresenceStore) SetKV(userID, key, value string) error {
+	if !ValidKVKey(key) {
+		return ErrKVInvalidKey
+	}
+	if len(value) > MaxKVValueLength {
+		return ErrKVValueTooLong
+	}
+	return s.updateKV(userID, func(kv map[string]string) error {
+		if _, exists := kv[key]; !exists && len(kv) >= MaxKVKeys {
+			return ErrKVTooManyKeys
+		}
+		kv[key] = value
+		return nil
+	})
+}
+
+// DeleteKV removes a key for userID and broadcasts the updated presence.
+func (s *PresenceStore) DeleteKV(userID, key string) error {
+	return s.updateKV(userID, func(kv map[string]string) error {
+		if _, exists := kv[key]; !exists {
+			return ErrKVKeyNotPresent
+		}
+		delete(kv, key)
+		return nil
+	})
+}
+
+// updateKV applies mutate to a copy of the user's metadata, commits it when
+// mutate succeeds, persists the store and broadcasts the new snapshot.
+func (s *PresenceStore) updateKV(userID string, mutate func(map[string]string) error) error {
+	s.mu.Lock()
+	kv := maps.Clone(s.kv[userID])
+	if kv == nil {
+		kv = make(map[string]string)
+	}
+	if err := mutate(kv); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if len(kv) == 0 {
+		delete(s.kv, userID)
+	} else {
+		s.kv[userID] = kv
+	}
+	current, exists := s.data[userID]
+	if exists {
+		current = s.normalize(userID, current)
+		s.data[userID] = current
+	}
+	snapshot := make(map[string]map[string]string, len(s.kv))
+	for id, entries := range s.kv {
+		snapshot[id] = maps.Clone(entries)
+	}
+	file := s.kvFile
+	seq := file.stamp()
+	s.mu.Unlock()
+
+	file.save(seq, snapshot)
+	if exists {
+		s.broadcast(PresenceEvent{UserID: userID, Presence: current, Version: current.Version})
+	}
+	return nil
+}
+
+// LoadAPIKeys reads persisted KV API keys from path, replacing those in
+// memory, and saves future changes back to it. Only SHA-256 hashes of keys
+// are stored.
+func (s *PresenceStore) LoadAPIKeys(path string) error {
+	keys := make(map[string]string)
+	if err := loadJSONFile(path, &keys); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.apiKeysFile == nil || s.apiKeysFile.path != path {
+		s.apiKeysFile = &jsonFile{path: path, what: "api keys"}
+	}
+	clear(s.apiKeys)
+	maps.Copy(s.apiKeys, keys)
+	return nil
+}
+
+// ReloadSettings reads privacy settings, KV metadata and API keys again from
+// the files they were loaded from. Only the leader changes them, so a node
+// that takes over calls this to pick up the previous leader's changes from
+// a shared DATA_DIR.
+func (s *PresenceStore) ReloadSettings() error {
+	s.mu.RLock()
+	privacy, kv, keys := s.privacyFile, s.kvFile, s.apiKeysFile
+	s.mu.RUnlock()
+	var errs []error
+	if privacy != nil {
+		errs = append(errs, s.LoadPrivacy(privacy.path))
+	}
+	if kv != nil {
+		errs = append(errs, s.LoadKV(kv.path))
+	}
+	if keys != nil {
+		errs = append(errs, s.LoadAPIKeys(keys.path))
+	}
+	return errors.Join(errs...)
+}
+
+// IssueAPIKey generates a new API key for userID, revoking any previous key.
+// The plaintext key is returned once and never stored.
+func (s *PresenceStore) IssueAPIKey(userID string) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	for hash, owner := range s.apiKeys {
+		if owner == userID {
+			delete(s.apiKeys, hash)
+		}
+	}
+	s.apiKeys[hashAPIKey(key)] = userID
+	snapshot := maps.Clone(s.apiKeys)
+	file := s.apiKeysFile
+	seq := file.stamp()
+	s.mu.Unlock()
+
+	file.save(seq, snapshot)
+	return key, nil
+}
+
+// UserForAPIKey resolves an API key to the user that owns it.
+func (s *PresenceStore) UserForAPIKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	userID, ok := s.apiKeys[hashAPIKey(key)]
+	return userID, ok
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
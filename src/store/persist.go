@@ -0,0 +1,54 @@
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"tether/src/logging"
+	"tether/src/utils"
+)
+
+// jsonFile persists a piece of user-controlled state to a local JSON file.
+// A nil jsonFile is valid and makes save a no-op, so persistence stays
+// optional for tests and ephemeral deployments.
+//
+// Snapshots are taken under the store lock but written after releasing it,
+// so two writers can reach save in either order. Each snapshot is numbered
+// with stamp while the lock is held, and save skips any snapshot older than
+// the last one written.
+type jsonFile struct {
+	mu    sync.Mutex
+	path  string
+	what  string
+	seq   atomic.Uint64
+	saved uint64
+}
+
+// stamp numbers a snapshot. Call it under the lock the snapshot is taken
+// with.
+func (f *jsonFile) stamp() uint64 {
+	if f == nil {
+		return 0
+	}
+	return f.seq.Add(1)
+}
+
+func (f *jsonFile) save(seq uint64, v any) {
+	if f == nil || f.path == "" {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seq <= f.saved {
+		return
+	}
+	f.saved = seq
+	if err := utils.SaveJSONFile(f.path, v); err != nil {
+		logging.Log.WithError(err).WithField("path", f.path).Error("failed to persist " + f.what)
+	}
+}
+
+// loadJSONFile decodes path into v; see utils.LoadJSONFile.
+func loadJSONFile(path string, v any) error {
+	return utils.LoadJSONFile(path, v)
+}
@@ -0,0 +1,24 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONFileSkipsStaleSnapshots(t *testing.T) {
+	f := &jsonFile{path: filepath.Join(t.TempDir(), "state.json"), what: "test state"}
+	older, newer := f.stamp(), f.stamp()
+
+	// The newer snapshot reaches save first, as when two writers race after
+	// releasing the store lock.
+	f.save(newer, map[string]int{"n": 2})
+	f.save(older, map[string]int{"n": 1})
+
+	var got map[string]int
+	if err := loadJSONFile(f.path, &got); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got["n"] != 2 {
+		t.Fatalf("stale snapshot overwrote the newer one: %v", got)
+	}
+}
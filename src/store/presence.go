@@ -2,7 +2,11 @@ package store
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
+
 	"tether/src/concurrency"
+	"tether/src/utils"
 )
 
 type Timestamps struct {
@@ -24,6 +28,10 @@ type Spotify struct {
 	Artist     *string     `json:"artist"`
 	AlbumArt   *string     `json:"album_art_url"`
 	Album      *string     `json:"album"`
+	// Timing fields are computed when the snapshot is sent; see Render.
+	ElapsedMs   *int64   `json:"elapsed_ms,omitempty"`
+	RemainingMs *int64   `json:"remaining_ms,omitempty"`
+	Progress    *float64 `json:"progress,omitempty"`
 }
 
 // PublicClients is the public, stable clients grouping used by REST and WS.
@@ -41,15 +49,53 @@ type PublicPresence struct {
 	Clients     PublicClients `json:"clients"`
 	DiscordUser DiscordUser   `json:"discord_user"`
 	Spotify     *Spotify      `json:"spotify"`
+	// CustomStatus is null when the user has no custom status.
+	CustomStatus *CustomStatus `json:"custom_status"`
+	// Streaming is null unless the user is live.
+	Streaming *Streaming `json:"streaming"`
+	// Member is null unless the user's guild data may be exposed.
+	Member *GuildMember `json:"member"`
+	// TypedActivities mirrors Activities in the v1 typed schema; see Render.
+	TypedActivities []PublicActivity `json:"-"`
+	// KV is user-owned metadata set via /kv or the KV REST endpoint.
+	KV map[string]string `json:"kv"`
+}
+
+// GuildMember is the guild member profile from the user's preferred guild.
+// It is only built for users on the member allowlist.
+type GuildMember struct {
+	GuildID   string      `json:"guild_id"`
+	Nick      string      `json:"nick,omitempty"`
+	AvatarURL string      `json:"avatar_url,omitempty"`
+	Roles     []GuildRole `json:"roles"`
+	JoinedAt  string      `json:"joined_at,omitempty"`
+	// PremiumSince is when the member started boosting the guild.
+	PremiumSince               string `json:"premium_since,omitempty"`
+	Boosting                   bool   `json:"boosting"`
+	CommunicationDisabledUntil string `json:"communication_disabled_until,omitempty"`
+}
+
+// GuildRole is a member role. Name and Color are empty when the role could
+// not be resolved from the bot's guild state.
+type GuildRole struct {
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Color int    `json:"color"`
 }
 
 // DiscordUser contains the minimal public Discord user fields Tether relays.
 type DiscordUser struct {
-	ID                   string   `json:"id,omitempty"`
-	Username             string   `json:"username,omitempty"`
-	GlobalName           string   `json:"global_name,omitempty"`
-	Avatar               string   `json:"avatar,omitempty"`
-	AvatarURL            string   `json:"avatar_url,omitempty"`
+	ID         string `json:"id,omitempty"`
+	Username   string `json:"username,omitempty"`
+	GlobalName string `json:"global_name,omitempty"`
+	Avatar     string `json:"avatar,omitempty"`
+	AvatarURL  string `json:"avatar_url,omitempty"`
+	Banner     string `json:"banner,omitempty"`
+	BannerURL  string `json:"banner_url,omitempty"`
+	// AccentColor is the profile colour as an RGB integer, when set.
+	AccentColor *int `json:"accent_color,omitempty"`
+	// NameplateURL is the static image of the nameplate in Collectibles.
+	NameplateURL         string   `json:"nameplate_url,omitempty"`
 	AvatarDecorationData any      `json:"avatar_decoration_data"`
 	PrimaryGuild         any      `json:"primary_guild"`
 	Collectibles         any      `json:"collectibles"`
@@ -57,6 +103,8 @@ type DiscordUser struct {
 	PublicFlagsRaw       int      `json:"-"`
 	PublicFlagsPresent   bool     `json:"-"`
 	PublicFlags          []string `json:"public_flags"`
+	// Badges are the public flags shown as profile badges, with icons.
+	Badges []utils.Badge `json:"badges"`
 }
 
 type PresenceData struct {
@@ -67,16 +115,38 @@ type PresenceData struct {
 	ActiveOnDiscordEmbedded bool `json:"-"`
 	ActiveOnDiscordVR       bool `json:"-"`
 	// Derived convenience fields summarizing active clients.
-	ActiveClients         []string    `json:"active_clients,omitempty"`
-	PrimaryActiveClient   string      `json:"primary_active_client,omitempty"`
-	Spotify               *Spotify    `json:"spotify"`
-	DiscordUser           DiscordUser `json:"discord_user"`
-	DiscordStatus         string      `json:"discord_status"`
-	Activities            []Activity  `json:"activities"`
-	SuggestedUserIfExists *string     `json:"suggested_user_if_exists,omitempty"`
+	ActiveClients       []string      `json:"active_clients,omitempty"`
+	PrimaryActiveClient string        `json:"primary_active_client,omitempty"`
+	Spotify             *Spotify      `json:"spotify"`
+	CustomStatus        *CustomStatus `json:"custom_status,omitempty"`
+	Streaming           *Streaming    `json:"streaming,omitempty"`
+	Member              *GuildMember  `json:"member,omitempty"`
+	DiscordUser         DiscordUser   `json:"discord_user"`
+	DiscordStatus       string        `json:"discord_status"`
+	Activities          []Activity    `json:"activities"`
+	// TypedActivities holds Activities converted to the typed model, index
+	// for index.
+	TypedActivities       []PublicActivity `json:"-"`
+	SuggestedUserIfExists *string          `json:"suggested_user_if_exists,omitempty"`
+	// BaseUser is the user-level identity before guild-scoped overrides.
+	// DiscordUser is derived from it plus the preferred guild's member data.
+	BaseUser DiscordUser `json:"-"`
+	// GuildMembers holds the raw member payload for every tracked guild the
+	// user belongs to, keyed by guild ID. A nil payload records membership
+	// without guild-scoped overrides.
+	GuildMembers map[string]map[string]any `json:"-"`
+	// UpdatedAt is when the store last received this entry from the gateway.
+	// Resyncs compare it against the resync start to find stale entries.
+	UpdatedAt time.Time `json:"-"`
+	// Version orders this entry against other versions of the same user
+	// across nodes; see ApplyReplicated.
+	Version Version `json:"-"`
 	// Public is the precomputed public-facing snapshot used by REST and WS.
 	// It is intentionally omitted from JSON when PresenceData is marshaled.
 	Public PublicPresence `json:"-"`
+	// Hidden mirrors the user's privacy opt-out; hidden presences are never
+	// served publicly.
+	Hidden bool `json:"-"`
 }
 
 func isSpotifyActivity(act map[string]any) bool {
@@ -91,35 +161,61 @@ func isSpotifyActivity(act map[string]any) bool {
 	return false
 }
 
-func buildPublicPresence(p PresenceData) PublicPresence {
+func buildPublicPresence(p PresenceData, privacy PrivacySettings, kv map[string]string) PublicPresence {
 	active := p.ActiveClients
 	if active == nil {
 		active = []string{}
 	}
 
 	filtered := make([]Activity, 0, len(p.Activities))
-	for _, a := range p.Activities {
+	typed := make([]PublicActivity, 0, len(p.Activities))
+	for i, a := range p.Activities {
 		if isSpotifyActivity(map[string]any(a)) {
 			continue
 		}
 		filtered = append(filtered, a)
+		if i < len(p.TypedActivities) {
+			typed = append(typed, p.TypedActivities[i])
+		}
 	}
 
-	return PublicPresence{
-		Status:      p.DiscordStatus,
-		Clients:     PublicClients{Active: active, Primary: p.PrimaryActiveClient},
-		Activities:  filtered,
-		Spotify:     p.Spotify,
-		DiscordUser: p.DiscordUser,
+	if kv == nil {
+		kv = map[string]string{}
 	}
+
+	return applyPrivacy(PublicPresence{
+		Status:          p.DiscordStatus,
+		Clients:         PublicClients{Active: active, Primary: p.PrimaryActiveClient},
+		Activities:      filtered,
+		TypedActivities: typed,
+		Spotify:         p.Spotify,
+		CustomStatus:    p.CustomStatus,
+		Streaming:       p.Streaming,
+		Member:          p.Member,
+		DiscordUser:     p.DiscordUser,
+		KV:              kv,
+	}, privacy)
 }
 
-func normalizePresence(p PresenceData) PresenceData {
-	// Ensure cached public snapshot is always in sync.
-	p.Public = buildPublicPresence(p)
+// normalize rebuilds the cached public snapshot from the presence and the
+// user's own settings so it is always in sync, and stamps a new version.
+// Callers must hold s.mu for writing.
+func (s *PresenceStore) normalize(userID string, p PresenceData) PresenceData {
+	privacy := s.privacy[userID]
+	p.Public = buildPublicPresence(p, privacy, s.kv[userID])
+	p.Hidden = privacy.Hidden
+	p.Version = s.nextVersion()
 	return p
 }
 
+// renormalizeAll rebuilds every cached public snapshot, e.g. after settings
+// are loaded from disk. Callers must hold s.mu.
+func (s *PresenceStore) renormalizeAll() {
+	for userID, p := range s.data {
+		s.data[userID] = s.normalize(userID, p)
+	}
+}
+
 type PrettyPresence struct {
 	UserID   string       `json:"user_id"`
 	Presence PresenceData `json:"data"`
@@ -127,9 +223,10 @@ type PrettyPresence struct {
 
 // PublicFields is the public envelope shape used by REST and WebSocket replies
 type PublicFields struct {
-	Success bool `json:"success"`
-	Data    any  `json:"data,omitempty"`
-	Error   any  `json:"error,omitempty"`
+	Success    bool  `json:"success"`
+	Data       any   `json:"data,omitempty"`
+	Error      any   `json:"error,omitempty"`
+	ServerTime int64 `json:"server_time"`
 }
 
 // PresenceEvent represents a store mutation.
@@ -137,6 +234,12 @@ type PresenceEvent struct {
 	UserID   string
 	Presence PresenceData
 	Removed  bool
+	// Version is the mutation's version; for removals it is the version of
+	// the removal itself.
+	Version Version
+	// Via lists the nodes a replicated event has passed through, origin
+	// first. It is empty for local mutations.
+	Via []string
 }
 
 // Replicator can optionally fan out presence mutations (e.g., via pub/sub)
@@ -151,36 +254,81 @@ type Replicator interface {
 type PresenceStore struct {
 	mu            sync.RWMutex
 	data          map[string]PresenceData
-	watchers      map[int]chan PresenceEvent
+	privacy       map[string]PrivacySettings
+	privacyFile   *jsonFile
+	kv            map[string]map[string]string
+	kvFile        *jsonFile
+	apiKeys       map[string]string
+	apiKeysFile   *jsonFile
+	watchers      map[int]*watcher
 	nextWatcherID int
 	replicators   []Replicator
+	// epoch and seq generate versions for local mutations.
+	epoch int64
+	seq   uint64
+	// replicated holds the newest replicated version applied per user,
+	// removals included, so stale events are ignored.
+	replicated map[string]Version
 }
 
 func NewPresenceStore() *PresenceStore {
 	return &PresenceStore{
 		data:     make(map[string]PresenceData),
-		watchers: make(map[int]chan PresenceEvent),
+		privacy:  make(map[string]PrivacySettings),
+		kv:       make(map[string]map[string]string),
+		apiKeys:  make(map[string]string),
+		watchers: make(map[int]*watcher),
+		epoch:    time.Now().UnixNano(),
+
+		replicated: make(map[string]Version),
 	}
 }
 
+// watcher is one subscription's channel and the events it missed.
+type watcher struct {
+	ch      chan PresenceEvent
+	dropped atomic.Uint64
+}
+
 func (s *PresenceStore) Subscribe() (int, <-chan PresenceEvent, func()) {
+	return s.SubscribeBuffered(16)
+}
+
+// SubscribeBuffered is Subscribe with a custom channel capacity. Events are
+// dropped when the buffer is full, so consumers that must see every event
+// (such as recording replays) size it for the burst they expect, and
+// consumers that keep state check Dropped and resync from GetAllPresences.
+func (s *PresenceStore) SubscribeBuffered(size int) (int, <-chan PresenceEvent, func()) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	id := s.nextWatcherID
 	s.nextWatcherID++
-	ch := make(chan PresenceEvent, 16)
-	s.watchers[id] = ch
+	w := &watcher{ch: make(chan PresenceEvent, size)}
+	s.watchers[id] = w
 
 	cancel := func() {
 		s.mu.Lock()
 		defer s.mu.Unlock()
-		if c, ok := s.watchers[id]; ok {
+		if w, ok := s.watchers[id]; ok {
 			delete(s.watchers, id)
-			close(c)
+			close(w.ch)
 		}
 	}
-	return id, ch, cancel
+	return id, w.ch, cancel
+}
+
+// Dropped returns how many events subscription id has missed because its
+// buffer was full since the previous call, and resets the count. A drop
+// leaves the buffer full, so checking after each received event notices it.
+func (s *PresenceStore) Dropped(id int) uint64 {
+	s.mu.RLock()
+	w, ok := s.watchers[id]
+	s.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	return w.dropped.Swap(0)
 }
 
 // AddReplicator registers a best-effort publisher for multi-node
@@ -199,6 +347,18 @@ func (s *PresenceStore) GetPresence(userID string) (PresenceData, bool) {
 	return p, ok
 }
 
+// GetPublicPresence returns the public snapshot served by REST and WS. Users
+// who have hidden themselves are reported as not found.
+func (s *PresenceStore) GetPublicPresence(userID string) (PublicPresence, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.data[userID]
+	if !ok || p.Hidden {
+		return PublicPresence{}, false
+	}
+	return p.Public, true
+}
+
 func (s *PresenceStore) GetAllPresences() map[string]PresenceData {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -217,17 +377,19 @@ func (s *PresenceStore) Count() int {
 }
 
 func (s *PresenceStore) SetPresence(userID string, presence PresenceData) {
-	presence = normalizePresence(presence)
 	s.mu.Lock()
+	presence.UpdatedAt = time.Now()
+	presence = s.normalize(userID, presence)
 	s.data[userID] = presence
 	s.mu.Unlock()
-	s.broadcast(PresenceEvent{UserID: userID, Presence: presence})
+	s.broadcast(PresenceEvent{UserID: userID, Presence: presence, Version: presence.Version})
 }
 
 // SetPresenceQuiet updates presence without broadcasting (for staged updates).
 func (s *PresenceStore) SetPresenceQuiet(userID string, presence PresenceData) {
-	presence = normalizePresence(presence)
 	s.mu.Lock()
+	presence.UpdatedAt = time.Now()
+	presence = s.normalize(userID, presence)
 	s.data[userID] = presence
 	s.mu.Unlock()
 }
@@ -243,7 +405,8 @@ func (s *PresenceStore) UpdatePresenceQuiet(userID string, update func(PresenceD
 		current = PresenceData{DiscordStatus: "offline"}
 	}
 	updated := update(current)
-	updated = normalizePresence(updated)
+	updated.UpdatedAt = time.Now()
+	updated = s.normalize(userID, updated)
 	s.data[userID] = updated
 	s.mu.Unlock()
 }
@@ -251,8 +414,9 @@ func (s *PresenceStore) UpdatePresenceQuiet(userID string, update func(PresenceD
 func (s *PresenceStore) RemovePresence(userID string) {
 	s.mu.Lock()
 	delete(s.data, userID)
+	version := s.nextVersion()
 	s.mu.Unlock()
-	s.broadcast(PresenceEvent{UserID: userID, Removed: true})
+	s.broadcast(PresenceEvent{UserID: userID, Removed: true, Version: version})
 }
 
 func (s *PresenceStore) BroadcastPresence(userID string) {
@@ -264,7 +428,7 @@ func (s *PresenceStore) BroadcastPresence(userID string) {
 		return
 	}
 
-	s.broadcast(PresenceEvent{UserID: userID, Presence: data})
+	s.broadcast(PresenceEvent{UserID: userID, Presence: data, Version: data.Version})
 }
 
 // PrettySnapshot returns the combined user ID + presence shape Tether exposes.
@@ -279,11 +443,16 @@ func (s *PresenceStore) PrettySnapshot(userID string) (PrettyPresence, bool) {
 func (s *PresenceStore) broadcast(evt PresenceEvent) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, ch := range s.watchers {
+	// Hidden users are announced as removed so subscribers drop cached data.
+	if !evt.Removed && evt.Presence.Hidden {
+		evt = PresenceEvent{UserID: evt.UserID, Removed: true, Version: evt.Version, Via: evt.Via}
+	}
+	for _, w := range s.watchers {
 		select {
-		case ch <- evt:
+		case w.ch <- evt:
 		default:
 			// Drop when a watcher is slow to keep the store non-blocking.
+			w.dropped.Add(1)
 		}
 	}
 	for _, r := range s.replicators {
@@ -0,0 +1,94 @@
+package store
+
+import "maps"
+
+// PrivacySettings are per-user opt-outs chosen by the tracked user. They are
+// applied when the public snapshot is built so REST and WebSocket never see
+// hidden data.
+type PrivacySettings struct {
+	// Hidden removes the user from every public surface entirely.
+	Hidden bool `json:"hidden,omitempty"`
+	// HideActivities strips the activities list, custom status and stream.
+	HideActivities bool `json:"hide_activities,omitempty"`
+	// HideSpotify strips the spotify object.
+	HideSpotify bool `json:"hide_spotify,omitempty"`
+	// HideClients strips active and primary client platforms.
+	HideClients bool `json:"hide_clients,omitempty"`
+}
+
+// IsZero reports whether no privacy option is enabled.
+func (p PrivacySettings) IsZero() bool {
+	return p == PrivacySettings{}
+}
+
+// applyPrivacy redacts a public snapshot according to the user's settings.
+func applyPrivacy(pub PublicPresence, privacy PrivacySettings) PublicPresence {
+	if privacy.HideActivities {
+		pub.Activities = []Activity{}
+		pub.TypedActivities = []PublicActivity{}
+		pub.CustomStatus = nil
+		pub.Streaming = nil
+	}
+	if privacy.HideSpotify {
+		pub.Spotify = nil
+	}
+	if privacy.HideClients {
+		pub.Clients = PublicClients{Active: []string{}}
+	}
+	return pub
+}
+
+// LoadPrivacy reads persisted privacy settings from path, replacing those in
+// memory, and saves future changes back to it. Call it at startup before
+// gateway events arrive; ReloadSettings calls it again.
+func (s *PresenceStore) LoadPrivacy(path string) error {
+	settings := make(map[string]PrivacySettings)
+	if err := loadJSONFile(path, &settings); err != nil {
+		return err
+	}
+	maps.DeleteFunc(settings, func(_ string, p PrivacySettings) bool { return p.IsZero() })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.privacyFile == nil || s.privacyFile.path != path {
+		s.privacyFile = &jsonFile{path: path, what: "privacy settings"}
+	}
+	clear(s.privacy)
+	maps.Copy(s.privacy, settings)
+	s.renormalizeAll()
+	return nil
+}
+
+// GetPrivacy returns the privacy settings for userID.
+func (s *PresenceStore) GetPrivacy(userID string) PrivacySettings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.privacy[userID]
+}
+
+// SetPrivacy stores the user's privacy settings, rebuilds their public
+// snapshot and broadcasts the result.
+func (s *PresenceStore) SetPrivacy(userID string, privacy PrivacySettings) {
+	s.mu.Lock()
+	if privacy.IsZero() {
+		delete(s.privacy, userID)
+	} else {
+		s.privacy[userID] = privacy
+	}
+	current, exists := s.data[userID]
+	if exists {
+		current = s.normalize(userID, current)
+		s.data[userID] = current
+	}
+	snapshot := make(map[string]PrivacySettings, len(s.privacy))
+	for id, p := range s.privacy {
+		snapshot[id] = p
+	}
+	file := s.privacyFile
+	seq := file.stamp()
+	s.mu.Unlock()
+
+	file.save(seq, snapshot)
+	if exists {
+		s.broadcast(PresenceEvent{UserID: userID, Presence: current, Version: current.Version})
+	}
+}
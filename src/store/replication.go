@@ -0,0 +1,94 @@
+package store
+
+import "time"
+
+// Version orders mutations of a user across nodes. Epoch identifies the
+// store instance that made the mutation (its start time), so versions from
+// a restarted node win over the previous process's; Seq increases with every
+// mutation within an epoch.
+type Version struct {
+	Epoch int64  `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+}
+
+// Newer reports whether v orders after other.
+func (v Version) Newer(other Version) bool {
+	if v.Epoch != other.Epoch {
+		return v.Epoch > other.Epoch
+	}
+	return v.Seq > other.Seq
+}
+
+// nextVersion returns a fresh local version. Callers must hold s.mu for
+// writing.
+func (s *PresenceStore) nextVersion() Version {
+	s.seq++
+	return Version{Epoch: s.epoch, Seq: s.seq}
+}
+
+// CurrentVersion returns the version of the store's latest local mutation.
+func (s *PresenceStore) CurrentVersion() Version {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Version{Epoch: s.epoch, Seq: s.seq}
+}
+
+// NewEpoch starts a new epoch at the current time, so mutations made from
+// now on order after those of any node that was leading before this one.
+func (s *PresenceStore) NewEpoch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.epoch = max(time.Now().UnixNano(), s.epoch+1)
+	s.seq = 0
+}
+
+// ApplyReplicated applies an event received from another node and
+// broadcasts it, keeping its Version and Via. Only evt.Presence.Public is
+// used: it is the snapshot the origin computed, so it is stored as is. The
+// event is ignored, and false returned, unless its version is newer than the
+// last one applied for the user, and than the stored entry if that comes from
+// the same epoch (such as this node's own data replicated back to it).
+func (s *PresenceStore) ApplyReplicated(evt PresenceEvent) bool {
+	s.mu.Lock()
+	last, ok := s.replicated[evt.UserID]
+	stale := ok && !evt.Version.Newer(last)
+	if cur, exists := s.data[evt.UserID]; exists && cur.Version.Epoch == evt.Version.Epoch && !evt.Version.Newer(cur.Version) {
+		stale = true
+	}
+	if stale {
+		s.mu.Unlock()
+		return false
+	}
+	s.replicated[evt.UserID] = evt.Version
+	if evt.Removed {
+		delete(s.data, evt.UserID)
+	} else {
+		evt.Presence = replicatedPresence(evt.Presence.Public, evt.Version)
+		s.data[evt.UserID] = evt.Presence
+	}
+	s.mu.Unlock()
+	s.broadcast(evt)
+	return true
+}
+
+// replicatedPresence rebuilds presence data from a replicated public
+// snapshot, filling the source fields too so local re-normalisation (e.g.
+// after a KV write) reproduces the snapshot.
+func replicatedPresence(pub PublicPresence, version Version) PresenceData {
+	return PresenceData{
+		ActiveClients:       pub.Clients.Active,
+		PrimaryActiveClient: pub.Clients.Primary,
+		Spotify:             pub.Spotify,
+		CustomStatus:        pub.CustomStatus,
+		Streaming:           pub.Streaming,
+		Member:              pub.Member,
+		DiscordUser:         pub.DiscordUser,
+		BaseUser:            pub.DiscordUser,
+		DiscordStatus:       pub.Status,
+		Activities:          pub.Activities,
+		TypedActivities:     pub.TypedActivities,
+		UpdatedAt:           time.Now(),
+		Public:              pub,
+		Version:             version,
+	}
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"maps"
+	"time"
+)
+
+// computeTiming derives elapsed/remaining time and progress from activity
+// timestamps (milliseconds since the Unix epoch) at now. Fields that cannot
+// be derived are nil: elapsed needs a start, remaining an end, and progress
+// both.
+func computeTiming(start, end int64, now time.Time) (elapsed, remaining *int64, progress *float64) {
+	nowMs := now.UnixMilli()
+	if start > 0 {
+		e := max(nowMs-start, 0)
+		elapsed = &e
+	}
+	if end > 0 {
+		r := max(end-nowMs, 0)
+		remaining = &r
+	}
+	if start > 0 && end > start {
+		p := min(max(float64(nowMs-start)/float64(end-start), 0), 1)
+		progress = &p
+	}
+	return elapsed, remaining, progress
+}
+
+// withTiming returns a copy of the activity with elapsed_ms, remaining_ms
+// and progress set from its timestamps. Activities without timestamps are
+// returned as-is.
+func (a Activity) withTiming(now time.Time) Activity {
+	ts, ok := a["timestamps"].(map[string]any)
+	if !ok {
+		return a
+	}
+	elapsed, remaining, progress := computeTiming(timestampMs(ts["start"]), timestampMs(ts["end"]), now)
+	if elapsed == nil && remaining == nil {
+		return a
+	}
+	out := maps.Clone(a)
+	if elapsed != nil {
+		out["elapsed_ms"] = *elapsed
+	}
+	if remaining != nil {
+		out["remaining_ms"] = *remaining
+	}
+	if progress != nil {
+		out["progress"] = *progress
+	}
+	return out
+}
+
+// withTiming returns a copy of the Spotify object with timing fields set.
+func (s *Spotify) withTiming(now time.Time) *Spotify {
+	if s == nil || s.Timestamps == nil {
+		return s
+	}
+	out := *s
+	out.ElapsedMs, out.RemainingMs, out.Progress = computeTiming(s.Timestamps.Start, s.Timestamps.End, now)
+	return &out
+}
+
+// timestampMs reads a JSON-decoded millisecond timestamp.
+func timestampMs(v any) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+	return 0
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// CDNBaseURL is the origin of every Discord image URL Tether generates.
+const CDNBaseURL = "https://cdn.discordapp.com"
+
+// CDNSizes are the image sizes Discord's CDN accepts.
+var CDNSizes = []int{16, 32, 64, 128, 256, 512, 1024, 2048, 4096}
+
+// CDNFormats are the static image formats callers may request. Animated
+// images are served as gif unless animation is disabled.
+var CDNFormats = []string{"webp", "png", "jpg"}
+
+// CDNOptions tunes generated CDN URLs. Zero fields keep each image kind's
+// default: avatars are webp at 256px, emojis and clan badges png at 32px,
+// decorations png at 240px and activity assets webp at full size.
+type CDNOptions struct {
+	// Size must be one of CDNSizes.
+	Size int
+	// Format is one of CDNFormats and applies to static images.
+	Format string
+	// Animated=false serves animated avatars, emojis and decorations as
+	// static images.
+	Animated *bool
+}
+
+// IsZero reports whether no option is set.
+func (o CDNOptions) IsZero() bool {
+	return o.Size == 0 && o.Format == "" && o.Animated == nil
+}
+
+// Merge returns o with every option set in override replacing its own.
+func (o CDNOptions) Merge(override CDNOptions) CDNOptions {
+	if override.Size != 0 {
+		o.Size = override.Size
+	}
+	if override.Format != "" {
+		o.Format = override.Format
+	}
+	if override.Animated != nil {
+		o.Animated = override.Animated
+	}
+	return o
+}
+
+// Validate reports the first invalid option, named as in the public API
+// (avatar_size, avatar_format).
+func (o CDNOptions) Validate() error {
+	if o.Size != 0 && !ValidCDNSize(o.Size) {
+		return fmt.Errorf("avatar_size must be a power of two between 16 and 4096")
+	}
+	if o.Format != "" && !ValidCDNFormat(o.Format) {
+		return fmt.Errorf("avatar_format must be one of: %s", strings.Join(CDNFormats, ", "))
+	}
+	return nil
+}
+
+// animated reports whether animated images stay animated.
+func (o CDNOptions) animated() bool {
+	return o.Animated == nil || *o.Animated
+}
+
+// ValidCDNSize reports whether size is one of CDNSizes.
+func ValidCDNSize(size int) bool {
+	for _, s := range CDNSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidCDNFormat reports whether format is one of CDNFormats. "jpeg" is
+// accepted as an alias of jpg.
+func ValidCDNFormat(format string) bool {
+	if format == "jpeg" {
+		return true
+	}
+	for _, f := range CDNFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+var cdnDefaults atomic.Pointer[CDNOptions]
+
+// SetCDNDefaults installs deployment-wide options. They are applied when
+// snapshots are rendered, beneath any per-request options.
+func SetCDNDefaults(opts CDNOptions) {
+	cdnDefaults.Store(&opts)
+}
+
+// CDNDefaults returns the options installed by SetCDNDefaults.
+func CDNDefaults() CDNOptions {
+	if opts := cdnDefaults.Load(); opts != nil {
+		return *opts
+	}
+	return CDNOptions{}
+}
+
+// cdnKind describes how one CDN path prefix handles options.
+type cdnKind struct {
+	// staticExt replaces gif when animation is disabled.
+	staticExt string
+	// formats reports whether the kind may be served in other formats.
+	formats bool
+	// decoration images animate through passthrough=true instead of gif.
+	decoration bool
+}
+
+var cdnKinds = map[string]cdnKind{
+	"avatars":                   {staticExt: "webp", formats: true},
+	"guilds":                    {staticExt: "webp", formats: true},
+	"banners":                   {staticExt: "webp", formats: true},
+	"emojis":                    {staticExt: "png", formats: true},
+	"clan-badges":               {staticExt: "png", formats: true},
+	"app-assets":                {staticExt: "webp", formats: true},
+	"app-icons":                 {staticExt: "png", formats: true},
+	"badge-icons":               {staticExt: "png", formats: true},
+	"embed":                     {staticExt: "png"},
+	"avatar-decoration-presets": {staticExt: "png", decoration: true},
+}
+
+// CDNURL builds a CDN URL for path (without extension). A zero size leaves
+// the size to the CDN.
+func CDNURL(path, ext string, size int) string {
+	u := CDNBaseURL + "/" + path + "." + ext
+	if size > 0 {
+		u += "?size=" + strconv.Itoa(size)
+	}
+	return u
+}
+
+// RewriteCDNURL applies opts to a URL produced by the builders in this
+// package. URLs outside Discord's CDN, such as external activity images, are
+// returned unchanged.
+func RewriteCDNURL(raw string, opts CDNOptions) string {
+	if opts.IsZero() || !strings.HasPrefix(raw, CDNBaseURL+"/") {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	path := strings.TrimPrefix(u.Path, "/")
+	prefix, _, _ := strings.Cut(path, "/")
+	kind, ok := cdnKinds[prefix]
+	if !ok {
+		return raw
+	}
+	dot := strings.LastIndex(path, ".")
+	if dot < 0 {
+		return raw
+	}
+	base, ext := path[:dot], path[dot+1:]
+
+	query := u.Query()
+	switch {
+	case kind.decoration:
+		if !opts.animated() {
+			query.Set("passthrough", "false")
+		}
+	case ext == "gif" && !opts.animated():
+		ext = kind.staticExt
+		if kind.formats && opts.Format != "" {
+			ext = opts.Format
+		}
+	case kind.formats && opts.Format != "" && ext != "gif":
+		ext = opts.Format
+	}
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+	if opts.Size > 0 {
+		query.Set("size", strconv.Itoa(opts.Size))
+	}
+	u.Path = "/" + base + "." + ext
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// RewriteCDNURLs returns v with every Discord CDN URL stored under a key
+// ending in "_url" rewritten by opts. Maps and slices are copied on the way
+// down so shared snapshots are never mutated.
+func RewriteCDNURLs(v any, opts CDNOptions) any {
+	switch val := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, item := range val {
+			if s, ok := item.(string); ok && strings.HasSuffix(k, "_url") {
+				out[k] = RewriteCDNURL(s, opts)
+				continue
+			}
+			out[k] = RewriteCDNURLs(item, opts)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = RewriteCDNURLs(item, opts)
+		}
+		return out
+	}
+	return v
+}
@@ -2,7 +2,7 @@ package utils
 
 import (
 	"encoding/json"
-	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -27,7 +27,7 @@ func EnrichAvatarDecorationData(raw any) any {
 	if asset == "" {
 		return raw
 	}
-	m["avatar_decoration_url"] = "https://cdn.discordapp.com/avatar-decoration-presets/" + asset + ".png?size=240&passthrough=true"
+	m["avatar_decoration_url"] = CDNURL("avatar-decoration-presets/"+asset, "png", 240) + "&passthrough=true"
 	return m
 }
 
@@ -48,7 +48,7 @@ func EnrichEmojiData(raw any) any {
 	if animated, ok := m["animated"].(bool); ok && animated {
 		ext = "gif"
 	}
-	m["emoji_url"] = "https://cdn.discordapp.com/emojis/" + emojiID + "." + ext + "?size=32"
+	m["emoji_url"] = CDNURL("emojis/"+emojiID, ext, 32)
 	return m
 }
 
@@ -66,7 +66,7 @@ func EnrichPrimaryGuildData(raw any) any {
 	if identityGuildID == "" || badge == "" {
 		return raw
 	}
-	m["badge_url"] = "https://cdn.discordapp.com/clan-badges/" + identityGuildID + "/" + badge + ".png?size=32"
+	m["badge_url"] = CDNURL("clan-badges/"+identityGuildID+"/"+badge, "png", 32)
 	return m
 }
 
@@ -99,7 +99,7 @@ func EnrichActivityAssets(raw any) any {
 		if appID == "" {
 			return ""
 		}
-		return "https://cdn.discordapp.com/app-assets/" + appID + "/" + asset + ".webp"
+		return CDNURL("app-assets/"+appID+"/"+asset, "webp", 0)
 	}
 
 	if li := GetString(assets["large_image"]); li != "" {
@@ -118,6 +118,7 @@ func EnrichActivityAssets(raw any) any {
 }
 
 // BuildAvatarURL builds Discord CDN avatar URLs for custom or default avatars.
+// Sizes and formats are the defaults; RewriteCDNURL applies CDNOptions.
 func BuildAvatarURL(userID, avatar, discriminator string) string {
 	if avatar != "" {
 		ext := "webp"
@@ -126,7 +127,7 @@ func BuildAvatarURL(userID, avatar, discriminator string) string {
 			ext = "gif"
 			size = 64
 		}
-		return "https://cdn.discordapp.com/avatars/" + userID + "/" + avatar + "." + ext + "?size=" + fmt.Sprintf("%d", size)
+		return CDNURL("avatars/"+userID+"/"+avatar, ext, size)
 	}
 	var index int
 	if discriminator == "0" || discriminator == "" {
@@ -137,7 +138,60 @@ func BuildAvatarURL(userID, avatar, discriminator string) string {
 	} else {
 		index = int(GetInt64(discriminator) % 5)
 	}
-	return "https://cdn.discordapp.com/embed/avatars/" + fmt.Sprintf("%d", index) + ".png?size=128"
+	return CDNURL("embed/avatars/"+strconv.Itoa(index), "png", 128)
+}
+
+// BuildBannerURL builds the CDN URL of a user's profile banner, or "" when
+// the user has none.
+func BuildBannerURL(userID, banner string) string {
+	if userID == "" || banner == "" {
+		return ""
+	}
+	return CDNURL("banners/"+userID+"/"+banner, animatedExt(banner), 1024)
+}
+
+// BuildMemberBannerURL builds the CDN URL of a guild-specific member banner.
+func BuildMemberBannerURL(guildID, userID, banner string) string {
+	if guildID == "" || userID == "" || banner == "" {
+		return ""
+	}
+	return CDNURL("guilds/"+guildID+"/users/"+userID+"/banners/"+banner, animatedExt(banner), 1024)
+}
+
+// BuildMemberAvatarURL builds the CDN URL of a guild-specific member
+// avatar, or "" when the member has none.
+func BuildMemberAvatarURL(guildID, userID, avatar string) string {
+	if guildID == "" || userID == "" || avatar == "" {
+		return ""
+	}
+	return CDNURL("guilds/"+guildID+"/users/"+userID+"/avatars/"+avatar, animatedExt(avatar), 256)
+}
+
+// BuildNameplateURL returns the static image of the nameplate in a
+// collectibles object, or "" when there is none. Nameplate assets are
+// directory paths such as "nameplates/nameplates_v3/bonsai/".
+func BuildNameplateURL(collectibles any) string {
+	m := MarshalToMap(collectibles)
+	if m == nil {
+		return ""
+	}
+	nameplate, ok := m["nameplate"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	asset := strings.Trim(GetString(nameplate["asset"]), "/")
+	if asset == "" {
+		return ""
+	}
+	return CDNBaseURL + "/assets/collectibles/" + asset + "/static.png"
+}
+
+// animatedExt picks gif for animated ("a_") image hashes and webp otherwise.
+func animatedExt(hash string) string {
+	if strings.HasPrefix(hash, "a_") {
+		return "gif"
+	}
+	return "webp"
 }
 
 // ClientStatusActive reports if a given platform key has a non-empty status.
@@ -3,26 +3,27 @@ package utils
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 )
 
 // WriteJSON writes the payload as JSON with the given status code.
 func WriteJSON(w http.ResponseWriter, status int, payload any) {
+	// CORS headers are owned by middleware.CORS so the origin policy applies
+	// uniformly to every response.
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	// CORS headers for public API
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
 	w.WriteHeader(status)
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
 	_ = enc.Encode(payload)
 }
 
-// ErrorResponse creates a standardized error response object.
+// ErrorResponse creates a standardized error response object. Like
+// SuccessResponse it carries server_time (Unix milliseconds) so clients can
+// correct for clock skew.
 func ErrorResponse(code string, message string, status int, retryable bool, details any) map[string]any {
 	return map[string]any{
-		"success": false,
+		"success":     false,
+		"server_time": time.Now().UnixMilli(),
 		"error": map[string]any{
 			"code":      code,
 			"message":   message,
@@ -36,8 +37,9 @@ func ErrorResponse(code string, message string, status int, retryable bool, deta
 // SuccessResponse creates a standardized success response object.
 func SuccessResponse(data any) map[string]any {
 	return map[string]any{
-		"success": true,
-		"data":    data,
+		"success":     true,
+		"data":        data,
+		"server_time": time.Now().UnixMilli(),
 	}
 }
 
@@ -1,45 +1,80 @@
 package utils
 
-// PublicFlagsToNames converts the Discord public_flags bitset into semantic labels.
-func PublicFlagsToNames(flag int) []string {
-	flags := []string{}
+import "strconv"
 
-	if flag&1 != 0 {
-		flags = append(flags, "Discord_Employee")
-	}
-	if flag&262144 != 0 {
-		flags = append(flags, "Discord_Certified_Moderator")
-	}
-	if flag&2 != 0 {
-		flags = append(flags, "Partnered_Server_Owner")
-	}
-	if flag&4 != 0 {
-		flags = append(flags, "HypeSquad_Events")
-	}
-	if flag&64 != 0 {
-		flags = append(flags, "House_Bravery")
-	}
-	if flag&128 != 0 {
-		flags = append(flags, "House_Brilliance")
-	}
-	if flag&256 != 0 {
-		flags = append(flags, "House_Balance")
-	}
-	if flag&8 != 0 {
-		flags = append(flags, "Bug_Hunter_Level_1")
-	}
-	if flag&16384 != 0 {
-		flags = append(flags, "Bug_Hunter_Level_2")
-	}
-	if flag&4194304 != 0 {
-		flags = append(flags, "Active_Developer")
+// PublicFlag describes one bit of Discord's public_flags bitset. Flags with
+// an Icon are shown as profile badges.
+type PublicFlag struct {
+	Bit         uint
+	Name        string
+	Description string
+	// Icon is the badge-icons asset hash; empty for flags without a badge.
+	Icon string
+}
+
+// PublicFlags lists every documented public flag in the order labels and
+// badges are reported.
+var PublicFlags = []PublicFlag{
+	{Bit: 0, Name: "Discord_Employee", Description: "Discord Staff", Icon: "5e74e9b61934fc1f67c65515d1f7e60d"},
+	{Bit: 18, Name: "Discord_Certified_Moderator", Description: "Moderator Programs Alumni", Icon: "fee1624003e2fee35cb398e125dc479b"},
+	{Bit: 1, Name: "Partnered_Server_Owner", Description: "Partnered Server Owner", Icon: "3f9748e53446a137a052f3454e2de41e"},
+	{Bit: 2, Name: "HypeSquad_Events", Description: "HypeSquad Events", Icon: "bf01d1073931f921909045f3a39fd264"},
+	{Bit: 6, Name: "House_Bravery", Description: "HypeSquad Bravery", Icon: "8a88d63823d8a71cd5e390baa45efa02"},
+	{Bit: 7, Name: "House_Brilliance", Description: "HypeSquad Brilliance", Icon: "011940fd013da3f7fb926e4a1cd2e618"},
+	{Bit: 8, Name: "House_Balance", Description: "HypeSquad Balance", Icon: "3aa41de486fa12454c3761e8e223442e"},
+	{Bit: 3, Name: "Bug_Hunter_Level_1", Description: "Discord Bug Hunter", Icon: "2717692c7dca7289b35297368a940dd0"},
+	{Bit: 14, Name: "Bug_Hunter_Level_2", Description: "Discord Bug Hunter", Icon: "848f79194d4be5ff5f81505cbd0ce1e6"},
+	{Bit: 22, Name: "Active_Developer", Description: "Active Developer", Icon: "6bdc42827a38498929a4920da12695d9"},
+	{Bit: 17, Name: "Early_Verified_Bot_Developer", Description: "Early Verified Bot Developer", Icon: "6df5892e0f35b051f8b61eace34f4967"},
+	{Bit: 9, Name: "Early_Supporter", Description: "Early Supporter", Icon: "7060786766c9c840eb3019e725d2b358"},
+	{Bit: 10, Name: "Team_User", Description: "Team User"},
+	{Bit: 16, Name: "Verified_Bot", Description: "Verified Bot"},
+	{Bit: 19, Name: "Bot_HTTP_Interactions", Description: "Bot Uses Only HTTP Interactions"},
+	{Bit: 20, Name: "Spammer", Description: "Flagged as Spammer"},
+}
+
+// knownFlagBits is the union of every bit in PublicFlags.
+var knownFlagBits = func() int {
+	var mask int
+	for _, f := range PublicFlags {
+		mask |= 1 << f.Bit
 	}
-	if flag&131072 != 0 {
-		flags = append(flags, "Early_Verified_Bot_Developer")
+	return mask
+}()
+
+// Badge is a public flag rendered as a profile badge.
+type Badge struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	IconURL     string `json:"icon_url"`
+}
+
+// PublicFlagsToNames converts the Discord public_flags bitset into semantic
+// labels. Bits missing from PublicFlags are reported as Unknown_<bit>.
+func PublicFlagsToNames(flag int) []string {
+	flags := []string{}
+	for _, f := range PublicFlags {
+		if flag&(1<<f.Bit) != 0 {
+			flags = append(flags, f.Name)
+		}
 	}
-	if flag&512 != 0 {
-		flags = append(flags, "Early_Supporter")
+	for bit, unknown := uint(0), flag&^knownFlagBits; unknown != 0; bit++ {
+		if unknown&(1<<bit) != 0 {
+			flags = append(flags, "Unknown_"+strconv.Itoa(int(bit)))
+			unknown &^= 1 << bit
+		}
 	}
-
 	return flags
 }
+
+// PublicFlagsToBadges returns the profile badges for the public_flags
+// bitset. Flags without a badge icon are skipped.
+func PublicFlagsToBadges(flag int) []Badge {
+	badges := []Badge{}
+	for _, f := range PublicFlags {
+		if f.Icon != "" && flag&(1<<f.Bit) != 0 {
+			badges = append(badges, Badge{ID: f.Name, Description: f.Description, IconURL: CDNURL("badge-icons/"+f.Icon, "png", 0)})
+		}
+	}
+	return badges
+}
@@ -0,0 +1,67 @@
+package webhooks
+
+import (
+	"fmt"
+
+	"tether/src/store"
+)
+
+type change struct {
+	event string
+	data  any
+}
+
+// diff derives webhook events from two consecutive public snapshots of the
+// same user.
+func diff(prev, next store.PublicPresence) []change {
+	var out []change
+	if prev.Status != next.Status {
+		out = append(out, change{EventStatusChange, map[string]any{"previous": prev.Status, "current": next.Status}})
+	}
+
+	before := activityKeys(prev.Activities)
+	after := activityKeys(next.Activities)
+	for _, a := range next.Activities {
+		if _, ok := before[activityKey(a)]; !ok {
+			out = append(out, change{EventActivityStart, map[string]any{"activity": a}})
+		}
+	}
+	for _, a := range prev.Activities {
+		if _, ok := after[activityKey(a)]; !ok {
+			out = append(out, change{EventActivityStop, map[string]any{"activity": a}})
+		}
+	}
+
+	if track := spotifyTrack(next.Spotify); track != "" && track != spotifyTrack(prev.Spotify) {
+		out = append(out, change{EventSpotifyTrackChange, map[string]any{
+			"previous_track_id": spotifyTrack(prev.Spotify),
+			"spotify":           next.Spotify,
+		}})
+	}
+	return out
+}
+
+// activityKey identifies an activity across updates: its type plus the
+// application ID, or the name for activities without one.
+func activityKey(a store.Activity) string {
+	id, _ := a["application_id"].(string)
+	if id == "" {
+		id, _ = a["name"].(string)
+	}
+	return fmt.Sprintf("%v:%s", a["type"], id)
+}
+
+func activityKeys(acts []store.Activity) map[string]struct{} {
+	keys := make(map[string]struct{}, len(acts))
+	for _, a := range acts {
+		keys[activityKey(a)] = struct{}{}
+	}
+	return keys
+}
+
+func spotifyTrack(s *store.Spotify) string {
+	if s == nil || s.TrackID == nil {
+		return ""
+	}
+	return *s.TrackID
+}
@@ -0,0 +1,460 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"tether/src/concurrency"
+	"tether/src/logging"
+	"tether/src/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Delivery states.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Config tunes a Dispatcher. Zero values pick the defaults noted per field.
+type Config struct {
+	// File persists webhooks registered through the API; it is also read
+	// at startup. Empty keeps webhooks in memory only.
+	File string
+	// DeadLetterFile receives one JSON line per delivery that exhausted its
+	// attempts. Empty only logs them.
+	DeadLetterFile string
+	// MaxAttempts per delivery (default 5).
+	MaxAttempts int
+	// Backoff before the first retry, doubled per attempt (default 1s,
+	// capped at one minute).
+	Backoff time.Duration
+	// Workers sending deliveries concurrently (default 4).
+	Workers int
+	// Client sends the requests (default: 10s timeout).
+	Client *http.Client
+}
+
+// eventBuffer is how many presence events the dispatcher queues before the
+// store drops them; after a drop it resyncs from the store.
+const eventBuffer = 1024
+
+// historyLimit is how many recent deliveries are kept per webhook.
+const historyLimit = 100
+
+const maxBackoff = time.Minute
+
+// Payload is the JSON body POSTed to webhooks.
+type Payload struct {
+	ID        string `json:"id"`
+	Event     string `json:"event"`
+	UserID    string `json:"user_id"`
+	Timestamp int64  `json:"timestamp"`
+	Data      any    `json:"data"`
+}
+
+// Delivery records one payload's delivery to one webhook.
+type Delivery struct {
+	ID             string     `json:"id"`
+	WebhookID      string     `json:"webhook_id"`
+	Event          string     `json:"event"`
+	UserID         string     `json:"user_id"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+type job struct {
+	hook     Webhook
+	body     []byte
+	delivery *Delivery
+	// attempts made so far and the wait before the next retry.
+	attempts int
+	backoff  time.Duration
+}
+
+// Dispatcher fans presence events out to webhooks.
+type Dispatcher struct {
+	st       *store.PresenceStore
+	cfg      Config
+	registry registry
+
+	queue  chan job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// last is each user's previous public snapshot; only the subscriber
+	// goroutine touches it.
+	last map[string]store.PublicPresence
+
+	mu         sync.Mutex
+	history    map[string][]*Delivery
+	deadLetter sync.Mutex
+}
+
+// New creates a dispatcher for st. Call Load to read configured webhooks
+// and Start to begin delivering.
+func New(st *store.PresenceStore, cfg Config) *Dispatcher {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Dispatcher{
+		st:      st,
+		cfg:     cfg,
+		queue:   make(chan job, 1024),
+		last:    make(map[string]store.PublicPresence),
+		history: make(map[string][]*Delivery),
+	}
+}
+
+// Load reads webhooks from the configured file. A missing file is fine.
+func (d *Dispatcher) Load() error {
+	if d.cfg.File == "" {
+		return nil
+	}
+	return d.registry.load(d.cfg.File)
+}
+
+// Register adds a webhook, generating its ID and, when empty, its secret.
+func (d *Dispatcher) Register(h Webhook) (Webhook, error) {
+	return d.registry.add(h)
+}
+
+// Remove deletes a webhook and its delivery history.
+func (d *Dispatcher) Remove(id string) error {
+	if err := d.registry.remove(id); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	delete(d.history, id)
+	d.mu.Unlock()
+	return nil
+}
+
+// Webhooks lists registered webhooks, secrets included.
+func (d *Dispatcher) Webhooks() []Webhook {
+	return d.registry.list()
+}
+
+// Deliveries returns the most recent deliveries to webhook id, newest first.
+func (d *Dispatcher) Deliveries(id string) ([]Delivery, error) {
+	if _, ok := d.registry.get(id); !ok {
+		return nil, ErrNotFound
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hist := d.history[id]
+	out := make([]Delivery, 0, len(hist))
+	for i := len(hist) - 1; i >= 0; i-- {
+		out = append(out, *hist[i])
+	}
+	return out, nil
+}
+
+// Start subscribes to the store and starts the delivery workers.
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	id, events, unsubscribe := d.st.SubscribeBuffered(eventBuffer)
+
+	d.wg.Add(1)
+	concurrency.GoSafe(func() {
+		defer d.wg.Done()
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt, ok := <-events:
+				if !ok {
+					return
+				}
+				d.handle(evt)
+				if n := d.st.Dropped(id); n > 0 {
+					logging.Log.WithField("dropped", n).Warn("webhooks fell behind presence events; resyncing from the store")
+					d.resync()
+				}
+			}
+		}
+	})
+	for range d.cfg.Workers {
+		d.wg.Add(1)
+		concurrency.GoSafe(func() {
+			defer d.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j := <-d.queue:
+					d.deliver(ctx, j)
+				}
+			}
+		})
+	}
+}
+
+// Close stops delivering and waits for workers. Retries still pending are
+// abandoned.
+func (d *Dispatcher) Close() {
+	if d == nil || d.cancel == nil {
+		return
+	}
+	d.cancel()
+	d.wg.Wait()
+}
+
+// handle diffs evt against the user's previous snapshot and enqueues the
+// resulting events.
+func (d *Dispatcher) handle(evt store.PresenceEvent) {
+	if evt.Removed {
+		delete(d.last, evt.UserID)
+		return
+	}
+	next := evt.Presence.Public
+	prev, seen := d.last[evt.UserID]
+	d.last[evt.UserID] = next
+	if !seen {
+		// The first snapshot is the baseline; there is nothing to compare.
+		return
+	}
+	for _, change := range diff(prev, next) {
+		d.enqueue(evt.UserID, change.event, change.data)
+	}
+}
+
+// resync diffs every user's current snapshot after events were missed, so
+// the changes made meanwhile are still sent, if coalesced.
+func (d *Dispatcher) resync() {
+	all := d.st.GetAllPresences()
+	for userID, p := range all {
+		d.handle(store.PresenceEvent{UserID: userID, Presence: p, Removed: p.Hidden})
+	}
+	for userID := range d.last {
+		if _, ok := all[userID]; !ok {
+			delete(d.last, userID)
+		}
+	}
+}
+
+func (d *Dispatcher) enqueue(userID, event string, data any) {
+	for _, hook := range d.registry.list() {
+		if hook.matches(userID, event) {
+			d.enqueueTo(hook, userID, event, data)
+		}
+	}
+}
+
+// Notify delivers an event to one webhook regardless of its filters. The
+// rule engine uses it for webhook actions.
+func (d *Dispatcher) Notify(webhookID, userID, event string, data any) error {
+	hook, ok := d.registry.get(webhookID)
+	if !ok {
+		return ErrNotFound
+	}
+	d.enqueueTo(hook, userID, event, data)
+	return nil
+}
+
+func (d *Dispatcher) enqueueTo(hook Webhook, userID, event string, data any) {
+	payload := Payload{ID: randomHex(8), Event: event, UserID: userID, Timestamp: time.Now().UnixMilli(), Data: data}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logging.Log.WithError(err).Warn("failed to encode webhook payload")
+		return
+	}
+	delivery := &Delivery{
+		ID:        payload.ID,
+		WebhookID: hook.ID,
+		Event:     event,
+		UserID:    userID,
+		Status:    StatusPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	d.record(delivery)
+	select {
+	case d.queue <- job{hook: hook, body: body, delivery: delivery, backoff: d.cfg.Backoff}:
+	default:
+		d.fail(hook, delivery, body, 0, "delivery queue full")
+	}
+}
+
+// deliver makes one attempt at j, scheduling a retry with exponential
+// backoff when it fails.
+func (d *Dispatcher) deliver(ctx context.Context, j job) {
+	j.attempts++
+	code, err := d.send(ctx, j.hook, j.body)
+	d.mu.Lock()
+	j.delivery.Attempts = j.attempts
+	d.mu.Unlock()
+	if err == nil {
+		d.finish(j.delivery, StatusDelivered, code, "")
+		return
+	}
+	if j.attempts >= d.cfg.MaxAttempts {
+		d.fail(j.hook, j.delivery, j.body, code, err.Error())
+		return
+	}
+	d.mu.Lock()
+	j.delivery.ResponseStatus, j.delivery.LastError = code, err.Error()
+	d.mu.Unlock()
+	d.retry(ctx, j)
+}
+
+// retry requeues j after its backoff. The wait happens off the workers, so
+// a failing endpoint does not hold up deliveries to the others.
+func (d *Dispatcher) retry(ctx context.Context, j job) {
+	wait := j.backoff
+	j.backoff = min(j.backoff*2, maxBackoff)
+	// Callers are workers, which hold the wait group, so Close cannot be
+	// waiting on it yet.
+	d.wg.Add(1)
+	concurrency.GoSafe(func() {
+		defer d.wg.Done()
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		select {
+		case <-ctx.Done():
+		case d.queue <- j:
+		}
+	})
+}
+
+// send POSTs body to the webhook. Any non-2xx response is an error.
+func (d *Dispatcher) send(ctx context.Context, hook Webhook, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tether-Webhooks")
+	req.Header.Set("X-Tether-Timestamp", ts)
+	req.Header.Set("X-Tether-Signature", "sha256="+Sign(hook.Secret, ts, body))
+	resp, err := d.cfg.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign computes the hex HMAC-SHA256 of "<timestamp>.<body>" with secret, as
+// sent in X-Tether-Signature.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) record(delivery *Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hist := append(d.history[delivery.WebhookID], delivery)
+	if len(hist) > historyLimit {
+		hist = hist[len(hist)-historyLimit:]
+	}
+	d.history[delivery.WebhookID] = hist
+}
+
+func (d *Dispatcher) finish(delivery *Delivery, status string, code int, lastErr string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	completed(delivery, status, code, lastErr)
+}
+
+// completed sets delivery's outcome. Callers hold d.mu or own delivery.
+func completed(delivery *Delivery, status string, code int, lastErr string) {
+	now := time.Now().UTC()
+	delivery.Status = status
+	delivery.ResponseStatus = code
+	delivery.LastError = lastErr
+	delivery.CompletedAt = &now
+}
+
+// fail gives up on delivery. The dead letter is written before the delivery
+// shows as failed, so anyone watching its status finds the entry.
+func (d *Dispatcher) fail(hook Webhook, delivery *Delivery, body []byte, code int, lastErr string) {
+	d.mu.Lock()
+	final := *delivery
+	d.mu.Unlock()
+	completed(&final, StatusFailed, code, lastErr)
+	d.writeDeadLetter(hook, final, body)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	*delivery = final
+}
+
+// writeDeadLetter logs a delivery that will not be retried and appends it,
+// payload included, to the dead-letter file.
+func (d *Dispatcher) writeDeadLetter(hook Webhook, delivery Delivery, body []byte) {
+	entry := struct {
+		Delivery
+		URL     string          `json:"url"`
+		Payload json.RawMessage `json:"payload"`
+	}{Delivery: delivery, URL: hook.URL, Payload: body}
+
+	logging.Log.WithFields(logrus.Fields{
+		"webhook_id":  hook.ID,
+		"delivery_id": delivery.ID,
+		"event":       entry.Event,
+		"attempts":    entry.Attempts,
+		"error":       entry.LastError,
+	}).Warn("webhook delivery failed")
+
+	if d.cfg.DeadLetterFile == "" {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	d.deadLetter.Lock()
+	defer d.deadLetter.Unlock()
+	if err := os.MkdirAll(filepath.Dir(d.cfg.DeadLetterFile), 0o755); err != nil {
+		logging.Log.WithError(err).Error("failed to open webhook dead-letter log")
+		return
+	}
+	f, err := os.OpenFile(d.cfg.DeadLetterFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		logging.Log.WithError(err).Error("failed to open webhook dead-letter log")
+		return
+	}
+	defer f.Close()
+	_, _ = f.Write(append(line, '\n'))
+}
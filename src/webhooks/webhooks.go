@@ -0,0 +1,191 @@
+// Package webhooks delivers presence changes to registered HTTP endpoints.
+//
+// A Dispatcher subscribes to the presence store, derives event kinds (status
+// changes, activity start/stop, Spotify track changes) by diffing each user's
+// public snapshot, and POSTs HMAC-signed payloads to every webhook whose
+// filters match. Failed deliveries are retried with exponential backoff and
+// end up in a dead-letter log when every attempt fails.
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"tether/src/logging"
+	"tether/src/utils"
+)
+
+// Event kinds a webhook can subscribe to.
+const (
+	EventStatusChange       = "status_change"
+	EventActivityStart      = "activity_start"
+	EventActivityStop       = "activity_stop"
+	EventSpotifyTrackChange = "spotify_track_change"
+)
+
+// EventKinds lists every supported event kind.
+var EventKinds = []string{EventStatusChange, EventActivityStart, EventActivityStop, EventSpotifyTrackChange}
+
+// ErrNotFound is returned for unknown webhook IDs.
+var ErrNotFound = errors.New("webhook not found")
+
+// ErrSave wraps failures to persist the registry. The change is not applied.
+var ErrSave = errors.New("failed to save webhooks")
+
+// Webhook is one registered endpoint. Empty UserIDs or Events match
+// everything.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	UserIDs   []string  `json:"user_ids,omitempty"`
+	Events    []string  `json:"events,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks the URL and event filters.
+func (w Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	for _, ev := range w.Events {
+		if !slices.Contains(EventKinds, ev) {
+			return errors.New("unknown event kind: " + ev)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the webhook wants event for userID.
+func (w Webhook) matches(userID, event string) bool {
+	if len(w.UserIDs) > 0 && !slices.Contains(w.UserIDs, userID) {
+		return false
+	}
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// Redacted returns the webhook without its secret, for listings.
+func (w Webhook) Redacted() Webhook {
+	w.Secret = ""
+	return w
+}
+
+// registry holds webhooks and persists them to an optional JSON file.
+type registry struct {
+	mu    sync.RWMutex
+	hooks []Webhook
+	path  string
+}
+
+// load reads the registry from path. Webhooks written by hand may omit their
+// ID and secret; generated ones are saved back so they survive restarts and
+// the secret can be read from the file.
+func (r *registry) load(path string) error {
+	var hooks []Webhook
+	if err := utils.LoadJSONFile(path, &hooks); err != nil {
+		return err
+	}
+	generated := 0
+	for i, h := range hooks {
+		if err := h.Validate(); err != nil {
+			return errors.New("webhook " + h.ID + ": " + err.Error())
+		}
+		if h.ID == "" {
+			hooks[i].ID = randomHex(8)
+			generated++
+		}
+		if h.Secret == "" {
+			// Never sign with an empty key.
+			hooks[i].Secret = randomHex(32)
+			generated++
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.path = path
+	r.hooks = hooks
+	if generated > 0 {
+		if err := r.saveLocked(); err != nil {
+			return err
+		}
+		logging.Log.WithField("file", path).Info("generated missing webhook IDs and secrets")
+	}
+	return nil
+}
+
+// saveLocked writes the registry back to its file. Callers hold r.mu.
+func (r *registry) saveLocked() error {
+	if r.path == "" {
+		return nil
+	}
+	if err := utils.SaveJSONFile(r.path, r.hooks); err != nil {
+		return fmt.Errorf("%w: %v", ErrSave, err)
+	}
+	return nil
+}
+
+func (r *registry) add(h Webhook) (Webhook, error) {
+	if err := h.Validate(); err != nil {
+		return Webhook{}, err
+	}
+	h.ID = randomHex(8)
+	if h.Secret == "" {
+		h.Secret = randomHex(32)
+	}
+	h.CreatedAt = time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, h)
+	if err := r.saveLocked(); err != nil {
+		r.hooks = r.hooks[:len(r.hooks)-1]
+		return Webhook{}, err
+	}
+	return h, nil
+}
+
+func (r *registry) remove(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.hooks, func(h Webhook) bool { return h.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	prev := r.hooks
+	r.hooks = slices.Delete(slices.Clone(r.hooks), i, i+1)
+	if err := r.saveLocked(); err != nil {
+		r.hooks = prev
+		return err
+	}
+	return nil
+}
+
+func (r *registry) get(id string) (Webhook, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, h := range r.hooks {
+		if h.ID == id {
+			return h, true
+		}
+	}
+	return Webhook{}, false
+}
+
+func (r *registry) list() []Webhook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.hooks)
+}
+
+// randomHex returns n random bytes hex-encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
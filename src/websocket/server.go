@@ -9,6 +9,7 @@ import (
 
 	"tether/src/concurrency"
 	"tether/src/logging"
+	"tether/src/middleware"
 	"tether/src/store"
 	"tether/src/utils"
 
@@ -18,6 +19,13 @@ import (
 
 var sendLatency utils.LatencyRing
 
+// Connection counters – updated atomically and exposed for metrics.
+var (
+	activeConns       atomic.Int64
+	rejectedConns     atomic.Int64
+	rejectedSubscribe atomic.Int64
+)
+
 const (
 	opEvent      = 0
 	opHello      = 1
@@ -29,13 +37,38 @@ const (
 
 	heartbeatIntervalMs = 30000
 	heartbeatTimeoutMs  = heartbeatIntervalMs * 2
+
+	closeTooManyConnsPerIP = 4007
+	closeServerFull        = 4008
+	closeTooManySubs       = 4009
 )
 
+// Config controls per-server connection and subscription limits. A zero
+// limit disables the corresponding check.
+type Config struct {
+	// BehindProxy makes client IP detection honour proxy headers, matching
+	// the HTTP rate limiter.
+	BehindProxy bool
+	// MaxConnsPerIP caps concurrent sockets from a single client IP.
+	MaxConnsPerIP int
+	// MaxConns caps concurrent sockets across all clients.
+	MaxConns int
+	// MaxSubscriptions caps the number of unique user IDs a single
+	// INITIALIZE payload may subscribe to.
+	MaxSubscriptions int
+	// Origins restricts which browser origins may upgrade. The zero value
+	// allows any origin.
+	Origins middleware.OriginPolicy
+}
+
 type wsMessage struct {
 	Op  int    `json:"op"`
 	Seq int64  `json:"seq,omitempty"`
 	T   string `json:"t,omitempty"`
 	D   any    `json:"d,omitempty"`
+	// ServerTime is stamped by writeJSON (Unix milliseconds) so clients can
+	// correct for clock skew.
+	ServerTime int64 `json:"server_time,omitempty"`
 }
 
 type helloPayload struct {
@@ -45,16 +78,31 @@ type helloPayload struct {
 type initPayload struct {
 	SubscribeToIDs []string `json:"subscribe_to_ids"`
 	SubscribeToID  string   `json:"subscribe_to_id"`
+	ActivitySchema string   `json:"activity_schema"`
+	AvatarSize     int      `json:"avatar_size"`
+	AvatarFormat   string   `json:"avatar_format"`
+	Animated       *bool    `json:"animated"`
+}
+
+// renderOptions returns the connection's snapshot rendering choices.
+func (p initPayload) renderOptions() store.RenderOptions {
+	return store.RenderOptions{
+		Schema: p.ActivitySchema,
+		CDN:    utils.CDNOptions{Size: p.AvatarSize, Format: p.AvatarFormat, Animated: p.Animated},
+	}
 }
 
 type presenceEnvelope struct {
-	UserID  string                `json:"user_id"`
-	Data    *store.PublicPresence `json:"data,omitempty"`
-	Removed bool                  `json:"removed,omitempty"`
+	UserID string `json:"user_id"`
+	// Data is a store.PublicPresence rendered for the connection's options.
+	Data    any  `json:"data,omitempty"`
+	Removed bool `json:"removed,omitempty"`
 }
 
 type connState struct {
+	ip            string
 	subs          map[string]struct{}
+	render        store.RenderOptions
 	lastHeartbeat time.Time
 	misses        int
 	mu            sync.Mutex
@@ -68,10 +116,15 @@ type connState struct {
 // available when the gateway includes them.
 type Server struct {
 	store    *store.PresenceStore
+	cfg      Config
 	upgrader websocket.Upgrader
 	stateMu  sync.Mutex
 	state    map[*websocket.Conn]*connState
+	ipConns  map[string]int
 	cancel   func()
+	// streams is the last public stream per user, used to emit
+	// STREAM_STARTED/STREAM_ENDED. Only the broadcast goroutine touches it.
+	streams map[string]*store.Streaming
 }
 
 // MessageP99 returns the p99 of recent websocket send latencies.
@@ -79,15 +132,35 @@ func MessageP99() time.Duration {
 	return sendLatency.P99()
 }
 
-func NewServer(store *store.PresenceStore) *Server {
+// ActiveConnections returns the number of currently registered sockets.
+func ActiveConnections() int64 {
+	return activeConns.Load()
+}
+
+// RejectedConnections returns how many sockets were closed for exceeding
+// the per-IP or global connection limits since startup.
+func RejectedConnections() int64 {
+	return rejectedConns.Load()
+}
+
+// RejectedSubscriptions returns how many INITIALIZE payloads were refused
+// for exceeding the subscription limit since startup.
+func RejectedSubscriptions() int64 {
+	return rejectedSubscribe.Load()
+}
+
+func NewServer(st *store.PresenceStore, cfg Config) *Server {
 	ws := &Server{
-		store: store,
+		store: st,
+		cfg:   cfg,
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true },
+			CheckOrigin: func(r *http.Request) bool { return cfg.Origins.Allows(r.Header.Get("Origin")) },
 		},
-		state: make(map[*websocket.Conn]*connState),
+		state:   make(map[*websocket.Conn]*connState),
+		ipConns: make(map[string]int),
+		streams: make(map[string]*store.Streaming),
 	}
-	_, events, cancel := store.Subscribe()
+	_, events, cancel := st.Subscribe()
 	ws.cancel = cancel
 	concurrency.GoSafe(func() {
 		for evt := range events {
@@ -112,16 +185,34 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if compression {
 		conn.EnableWriteCompression(true)
 	}
-	s.registerConn(conn)
+	ip := middleware.ClientIP(r, s.cfg.BehindProxy)
+	if code, reason := s.registerConn(conn, ip); code != 0 {
+		rejectedConns.Add(1)
+		logging.Log.WithFields(logrus.Fields{"ip": ip, "reason": reason}).Warn("ws connection rejected")
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+		_ = conn.Close()
+		return
+	}
 	s.sendHello(conn)
 	go s.watchHeartbeats(conn)
 	s.handleConn(conn)
 }
 
-func (s *Server) registerConn(conn *websocket.Conn) {
+// registerConn tracks a new socket unless it would exceed the configured
+// limits, in which case it returns the close code and reason to reject with.
+func (s *Server) registerConn(conn *websocket.Conn, ip string) (int, string) {
 	s.stateMu.Lock()
-	s.state[conn] = &connState{subs: make(map[string]struct{}), lastHeartbeat: time.Now()}
-	s.stateMu.Unlock()
+	defer s.stateMu.Unlock()
+	if s.cfg.MaxConns > 0 && len(s.state) >= s.cfg.MaxConns {
+		return closeServerFull, "server_full"
+	}
+	if s.cfg.MaxConnsPerIP > 0 && s.ipConns[ip] >= s.cfg.MaxConnsPerIP {
+		return closeTooManyConnsPerIP, "too_many_connections"
+	}
+	s.state[conn] = &connState{ip: ip, subs: make(map[string]struct{}), lastHeartbeat: time.Now()}
+	s.ipConns[ip]++
+	activeConns.Add(1)
+	return 0, ""
 }
 
 func (s *Server) sendHello(conn *websocket.Conn) {
@@ -160,6 +251,11 @@ func (s *Server) handleInit(conn *websocket.Conn, raw any) {
 	}
 
 	payload := s.decodeInitPayload(raw)
+	render := payload.renderOptions()
+	if !store.ValidActivitySchema(render.Schema) || render.CDN.Validate() != nil {
+		s.closeWithCode(conn, 4006, "invalid_payload")
+		return
+	}
 	s.stateMu.Lock()
 	state, ok := s.state[conn]
 	if !ok {
@@ -167,6 +263,7 @@ func (s *Server) handleInit(conn *websocket.Conn, raw any) {
 		return
 	}
 	state.subs = make(map[string]struct{})
+	state.render = render
 	if payload.SubscribeToID != "" {
 		state.subs[payload.SubscribeToID] = struct{}{}
 	}
@@ -180,11 +277,17 @@ func (s *Server) handleInit(conn *websocket.Conn, raw any) {
 		s.closeWithCode(conn, 4006, "invalid_payload")
 		return
 	}
+	if s.cfg.MaxSubscriptions > 0 && len(state.subs) > s.cfg.MaxSubscriptions {
+		state.subs = make(map[string]struct{})
+		s.stateMu.Unlock()
+		rejectedSubscribe.Add(1)
+		s.closeWithCode(conn, closeTooManySubs, "too_many_subscriptions")
+		return
+	}
 	s.stateMu.Unlock()
 	for userID := range state.subs {
-		if presence, ok := s.store.GetPresence(userID); ok {
-			public := presence.Public
-			s.sendEvent(conn, "INIT_STATE", presenceEnvelope{UserID: userID, Data: &public})
+		if public, ok := s.store.GetPublicPresence(userID); ok {
+			s.sendEvent(conn, "INIT_STATE", presenceEnvelope{UserID: userID, Data: public.Render(render, time.Now())})
 		}
 	}
 }
@@ -266,6 +369,10 @@ func (s *Server) writeJSON(conn *websocket.Conn, v any) error {
 	if !ok {
 		return websocket.ErrCloseSent
 	}
+	if msg, ok := v.(wsMessage); ok {
+		msg.ServerTime = time.Now().UnixMilli()
+		v = msg
+	}
 	state.writeMu.Lock()
 	defer state.writeMu.Unlock()
 	return conn.WriteJSON(v)
@@ -284,11 +391,13 @@ func (s *Server) writeControl(conn *websocket.Conn, messageType int, data []byte
 }
 
 func (s *Server) broadcast(evt store.PresenceEvent) {
+	streamEvent, stream := s.streamTransition(evt)
+
 	s.stateMu.Lock()
-	targets := make([]*websocket.Conn, 0, len(s.state))
+	targets := make(map[*websocket.Conn]store.RenderOptions)
 	for conn, state := range s.state {
 		if _, ok := state.subs[evt.UserID]; ok {
-			targets = append(targets, conn)
+			targets[conn] = state.render
 		}
 	}
 	s.stateMu.Unlock()
@@ -303,23 +412,48 @@ func (s *Server) broadcast(evt store.PresenceEvent) {
 		"removed": evt.Removed,
 	}).Info("gateway event broadcast")
 
-	var payload presenceEnvelope
-	if evt.Removed {
-		payload = presenceEnvelope{UserID: evt.UserID, Removed: true}
-	} else {
-		public := evt.Presence.Public
-		payload = presenceEnvelope{UserID: evt.UserID, Data: &public}
+	for conn, render := range targets {
+		payload := presenceEnvelope{UserID: evt.UserID, Removed: true}
+		if !evt.Removed {
+			payload = presenceEnvelope{UserID: evt.UserID, Data: evt.Presence.Public.Render(render, time.Now())}
+		}
+		s.sendEvent(conn, "PRESENCE_UPDATE", payload)
+		if streamEvent != "" {
+			s.sendEvent(conn, streamEvent, presenceEnvelope{UserID: evt.UserID, Data: stream.Render(render)})
+		}
 	}
+}
 
-	for _, conn := range targets {
-		s.sendEvent(conn, "PRESENCE_UPDATE", payload)
+// streamTransition compares evt with the user's last known stream and
+// returns STREAM_STARTED (a new or different stream URL) or STREAM_ENDED
+// with the stream it concerns, or "" when nothing changed.
+func (s *Server) streamTransition(evt store.PresenceEvent) (string, *store.Streaming) {
+	prev := s.streams[evt.UserID]
+	var cur *store.Streaming
+	if !evt.Removed {
+		cur = evt.Presence.Public.Streaming
+	}
+	if cur == nil {
+		delete(s.streams, evt.UserID)
+	} else {
+		s.streams[evt.UserID] = cur
 	}
+	switch {
+	case cur != nil && (prev == nil || prev.URL != cur.URL):
+		return "STREAM_STARTED", cur
+	case cur == nil && prev != nil:
+		return "STREAM_ENDED", prev
+	}
+	return "", nil
 }
 
 func (s *Server) cleanupConn(conn *websocket.Conn) {
 	s.stateMu.Lock()
 	state, ok := s.state[conn]
 	delete(s.state, conn)
+	if ok {
+		s.releaseIP(state.ip)
+	}
 	s.stateMu.Unlock()
 	if ok {
 		state.writeMu.Lock()
@@ -330,6 +464,16 @@ func (s *Server) cleanupConn(conn *websocket.Conn) {
 	}
 }
 
+// releaseIP drops one connection from the per-IP tally. Callers must hold stateMu.
+func (s *Server) releaseIP(ip string) {
+	activeConns.Add(-1)
+	if n := s.ipConns[ip] - 1; n > 0 {
+		s.ipConns[ip] = n
+	} else {
+		delete(s.ipConns, ip)
+	}
+}
+
 func (s *Server) closeWithCode(conn *websocket.Conn, code int, reason string) {
 	_ = s.writeControl(conn, websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
 	s.cleanupConn(conn)
@@ -341,7 +485,8 @@ func (s *Server) Close() {
 		s.cancel()
 	}
 	s.stateMu.Lock()
-	for conn := range s.state {
+	for conn, state := range s.state {
+		s.releaseIP(state.ip)
 		_ = conn.Close()
 	}
 	s.state = make(map[*websocket.Conn]*connState)
@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tether/src/api"
+	"tether/src/lib"
+	"tether/src/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func storeRawPresence(t *testing.T, st *store.PresenceStore, payload map[string]any) {
+	t.Helper()
+	user, _ := payload["user"].(map[string]any)
+	presence, userID, ok := lib.BuildPresenceFromRaw(payload, user, nil)
+	if !ok {
+		t.Fatal("failed to build presence from raw payload")
+	}
+	st.SetPresence(userID, presence)
+}
+
+func getSnapshot(t *testing.T, st *store.PresenceStore, userID, query string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/v1/users/"+userID+query, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("userID", userID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rec := httptest.NewRecorder()
+	api.SnapshotHandler{Store: st}.ServeHTTP(rec, req)
+
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	return rec.Code, body
+}
+
+func firstActivity(t *testing.T, body map[string]any) map[string]any {
+	t.Helper()
+	data, _ := body["data"].(map[string]any)
+	acts, _ := data["activities"].([]any)
+	if len(acts) == 0 {
+		t.Fatalf("expected activities, got %v", data)
+	}
+	act, _ := acts[0].(map[string]any)
+	return act
+}
+
+func TestActivitySchemaSelection(t *testing.T) {
+	st := store.NewPresenceStore()
+	storeRawPresence(t, st, map[string]any{
+		"user":   map[string]any{"id": "9", "username": "gamer"},
+		"status": "online",
+		"activities": []any{map[string]any{
+			"type":           float64(0),
+			"name":           "Some Game",
+			"details":        "Ranked",
+			"application_id": "123",
+			"timestamps":     map[string]any{"start": float64(1700000000000)},
+			"assets":         map[string]any{"large_image": "abc", "large_text": "Map"},
+			"party":          map[string]any{"id": "p1", "size": []any{float64(2), float64(5)}},
+			"buttons":        []any{"Join"},
+			"secret_field":   "passthrough",
+		}},
+	})
+
+	_, raw := getSnapshot(t, st, "9", "")
+	if act := firstActivity(t, raw); act["secret_field"] != "passthrough" || act["type_name"] != "playing" {
+		t.Fatalf("raw schema should pass Discord fields through with type_name, got %v", act)
+	}
+
+	code, typed := getSnapshot(t, st, "9", "?activity_schema=v1")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	act := firstActivity(t, typed)
+	if act["type_name"] != "playing" || act["details"] != "Ranked" || act["secret_field"] != nil {
+		t.Fatalf("unexpected typed activity: %v", act)
+	}
+	assets, _ := act["assets"].(map[string]any)
+	if assets["large_image_url"] != "https://cdn.discordapp.com/app-assets/123/abc.webp" {
+		t.Fatalf("expected resolved asset URL, got %v", assets)
+	}
+	party, _ := act["party"].(map[string]any)
+	if party["current_size"] != float64(2) || party["max_size"] != float64(5) {
+		t.Fatalf("unexpected party: %v", party)
+	}
+
+	if code, _ := getSnapshot(t, st, "9", "?activity_schema=v9"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown schema, got %d", code)
+	}
+}
+
+func TestCustomStatusExtraction(t *testing.T) {
+	st := store.NewPresenceStore()
+	storeRawPresence(t, st, map[string]any{
+		"user":   map[string]any{"id": "11"},
+		"status": "idle",
+		"activities": []any{map[string]any{
+			"type":       float64(4),
+			"name":       "Custom Status",
+			"state":      "brb",
+			"emoji":      map[string]any{"id": "555", "name": "wave", "animated": true},
+			"timestamps": map[string]any{"end": float64(1800000000000)},
+		}},
+	})
+
+	_, body := getSnapshot(t, st, "11", "")
+	data, _ := body["data"].(map[string]any)
+	status, ok := data["custom_status"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected custom_status, got %v", data)
+	}
+	emoji, _ := status["emoji"].(map[string]any)
+	if status["text"] != "brb" || status["expires_at"] != float64(1800000000000) ||
+		emoji["emoji_url"] != "https://cdn.discordapp.com/emojis/555.gif?size=32" {
+		t.Fatalf("unexpected custom_status: %v", status)
+	}
+	if act := firstActivity(t, body); act["type_name"] != "custom" {
+		t.Fatalf("expected custom type_name, got %v", act)
+	}
+
+	st.SetPrivacy("11", store.PrivacySettings{HideActivities: true})
+	_, body = getSnapshot(t, st, "11", "")
+	if data, _ := body["data"].(map[string]any); data["custom_status"] != nil {
+		t.Fatalf("custom_status should be hidden with activities, got %v", data["custom_status"])
+	}
+}
+
+type stubFetcher struct{ calls chan string }
+
+func (f stubFetcher) FetchApplication(_ context.Context, id string) (lib.Application, error) {
+	f.calls <- id
+	return lib.Application{Name: "Fetched Game", Icon: "fetchedicon"}, nil
+}
+
+func gameActivity(appID string) map[string]any {
+	return map[string]any{
+		"user":   map[string]any{"id": "12"},
+		"status": "online",
+		"activities": []any{map[string]any{
+			"type":           float64(0),
+			"name":           "game.exe",
+			"application_id": appID,
+		}},
+	}
+}
+
+func TestApplicationMetadataEnrichment(t *testing.T) {
+	t.Cleanup(func() { lib.SetApplicationCache(nil) })
+
+	seed := filepath.Join(t.TempDir(), "applications.json")
+	if err := os.WriteFile(seed, []byte(`[{"id":"111","name":"Seeded Game","icon":"abc","cover_image":"def"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fetcher := stubFetcher{calls: make(chan string, 4)}
+	cache := lib.NewApplicationCache(fetcher)
+	if err := cache.LoadFile(seed); err != nil {
+		t.Fatalf("load seed: %v", err)
+	}
+	lib.SetApplicationCache(cache)
+
+	st := store.NewPresenceStore()
+	storeRawPresence(t, st, gameActivity("111"))
+	_, body := getSnapshot(t, st, "12", "?activity_schema=v1")
+	app, _ := firstActivity(t, body)["application"].(map[string]any)
+	if app["name"] != "Seeded Game" ||
+		app["icon_url"] != "https://cdn.discordapp.com/app-icons/111/abc.png" ||
+		app["cover_image_url"] != "https://cdn.discordapp.com/app-icons/111/def.png" {
+		t.Fatalf("unexpected seeded application: %v", app)
+	}
+
+	// A miss schedules a background fetch; the next update is enriched.
+	storeRawPresence(t, st, gameActivity("222"))
+	select {
+	case id := <-fetcher.calls:
+		if id != "222" {
+			t.Fatalf("fetched unexpected application %q", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a fetch for the unknown application")
+	}
+	waitFor(t, "the fetched application to be cached", func() bool { return cache.Len() >= 2 })
+	storeRawPresence(t, st, gameActivity("222"))
+	_, body = getSnapshot(t, st, "12", "")
+	if app, _ := firstActivity(t, body)["application"].(map[string]any); app["name"] != "Fetched Game" {
+		t.Fatalf("expected fetched application on raw activity, got %v", app)
+	}
+}
+
+func TestActivityTimingComputedAtSendTime(t *testing.T) {
+	st := store.NewPresenceStore()
+	now := time.Now().UnixMilli()
+	storeRawPresence(t, st, map[string]any{
+		"user":   map[string]any{"id": "13"},
+		"status": "online",
+		"activities": []any{map[string]any{
+			"type":       float64(0),
+			"name":       "Timed",
+			"timestamps": map[string]any{"start": float64(now - 60_000), "end": float64(now + 60_000)},
+		}},
+	})
+
+	_, body := getSnapshot(t, st, "13", "")
+	serverTime, ok := body["server_time"].(float64)
+	if !ok || serverTime < float64(now) {
+		t.Fatalf("expected server_time on the envelope, got %v", body["server_time"])
+	}
+	act := firstActivity(t, body)
+	elapsed, _ := act["elapsed_ms"].(float64)
+	remaining, _ := act["remaining_ms"].(float64)
+	progress, _ := act["progress"].(float64)
+	if elapsed < 60_000 || elapsed > 65_000 || remaining > 60_000 || remaining < 55_000 || progress < 0.5 || progress > 0.55 {
+		t.Fatalf("unexpected timing: elapsed=%v remaining=%v progress=%v", elapsed, remaining, progress)
+	}
+
+	_, typed := getSnapshot(t, st, "13", "?activity_schema=v1")
+	if p, _ := firstActivity(t, typed)["progress"].(float64); p < 0.5 || p > 0.55 {
+		t.Fatalf("expected typed progress, got %v", firstActivity(t, typed))
+	}
+}
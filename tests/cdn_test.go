@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"tether/src/store"
+	"tether/src/utils"
+)
+
+func TestRewriteCDNURL(t *testing.T) {
+	no := false
+	cases := []struct {
+		name string
+		url  string
+		opts utils.CDNOptions
+		want string
+	}{
+		{"size and format", utils.BuildAvatarURL("1", "abc", ""), utils.CDNOptions{Size: 1024, Format: "png"},
+			"https://cdn.discordapp.com/avatars/1/abc.png?size=1024"},
+		{"animated keeps gif", utils.BuildAvatarURL("1", "a_abc", ""), utils.CDNOptions{Size: 512, Format: "png"},
+			"https://cdn.discordapp.com/avatars/1/a_abc.gif?size=512"},
+		{"static avatar", utils.BuildAvatarURL("1", "a_abc", ""), utils.CDNOptions{Animated: &no},
+			"https://cdn.discordapp.com/avatars/1/a_abc.webp?size=64"},
+		{"static emoji", "https://cdn.discordapp.com/emojis/7.gif?size=32", utils.CDNOptions{Animated: &no, Format: "jpeg"},
+			"https://cdn.discordapp.com/emojis/7.jpg?size=32"},
+		{"static decoration", "https://cdn.discordapp.com/avatar-decoration-presets/a_d.png?size=240&passthrough=true", utils.CDNOptions{Animated: &no, Format: "webp"},
+			"https://cdn.discordapp.com/avatar-decoration-presets/a_d.png?passthrough=false&size=240"},
+		{"default avatar stays png", "https://cdn.discordapp.com/embed/avatars/3.png?size=128", utils.CDNOptions{Size: 16, Format: "webp"},
+			"https://cdn.discordapp.com/embed/avatars/3.png?size=16"},
+		{"external untouched", "https://media.discordapp.net/external/x.png", utils.CDNOptions{Size: 64},
+			"https://media.discordapp.net/external/x.png"},
+	}
+	for _, c := range cases {
+		if got := utils.RewriteCDNURL(c.url, c.opts); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSnapshotCDNOptions(t *testing.T) {
+	st := store.NewPresenceStore()
+	storeRawPresence(t, st, map[string]any{
+		"user":   map[string]any{"id": "12", "username": "pic", "avatar": "a_hash"},
+		"status": "online",
+		"activities": []any{map[string]any{
+			"type":  float64(4),
+			"name":  "Custom Status",
+			"state": "hi",
+			"emoji": map[string]any{"id": "55", "name": "wave", "animated": true},
+		}},
+	})
+
+	_, body := getSnapshot(t, st, "12", "?avatar_size=1024&animated=false&avatar_format=png")
+	data, _ := body["data"].(map[string]any)
+	user, _ := data["discord_user"].(map[string]any)
+	if got := user["avatar_url"]; got != "https://cdn.discordapp.com/avatars/12/a_hash.png?size=1024" {
+		t.Fatalf("avatar_url = %v", got)
+	}
+	emoji, _ := firstActivity(t, body)["emoji"].(map[string]any)
+	if got := emoji["emoji_url"]; got != "https://cdn.discordapp.com/emojis/55.png?size=1024" {
+		t.Fatalf("emoji_url = %v", got)
+	}
+
+	// The stored snapshot must not be rewritten by a request.
+	_, body = getSnapshot(t, st, "12", "")
+	user, _ = body["data"].(map[string]any)["discord_user"].(map[string]any)
+	if got := user["avatar_url"]; got != "https://cdn.discordapp.com/avatars/12/a_hash.gif?size=64" {
+		t.Fatalf("default avatar_url = %v", got)
+	}
+
+	for _, query := range []string{"?avatar_size=100", "?avatar_size=big", "?avatar_format=gif", "?animated=maybe"} {
+		code, body := getSnapshot(t, st, "12", query)
+		errObj, _ := body["error"].(map[string]any)
+		if code != http.StatusBadRequest || errObj["code"] != "INVALID_IMAGE_OPTIONS" {
+			t.Fatalf("%s: expected 400 INVALID_IMAGE_OPTIONS, got %d %v", query, code, body)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tether/src/api"
+	"tether/src/lib"
+	"tether/src/store"
+)
+
+func TestChunkTrackerCompletesAndRemovesStaleMembers(t *testing.T) {
+	st := store.NewPresenceStore()
+	stale := store.PresenceData{GuildMembers: map[string]map[string]any{"10": {}}}
+	other := store.PresenceData{GuildMembers: map[string]map[string]any{"10": {}, "20": {}}}
+	st.SetPresenceQuiet("1", stale)
+	st.SetPresenceQuiet("2", stale)
+	st.SetPresenceQuiet("3", other)
+
+	tracker := lib.NewChunkTracker(st)
+	tracker.Begin("10", "n1")
+	if tracker.Ready() {
+		t.Fatal("tracker ready before any chunk arrived")
+	}
+
+	// Unrelated nonces are ignored.
+	tracker.Record(lib.ChunkInfo{GuildID: "10", Nonce: "other", ChunkIndex: 0, ChunkCount: 1})
+	if tracker.Ready() {
+		t.Fatal("chunk with foreign nonce completed the sequence")
+	}
+
+	tracker.Record(lib.ChunkInfo{GuildID: "10", Nonce: "n1", ChunkIndex: 1, ChunkCount: 2, UserIDs: []string{"2"}})
+	tracker.Record(lib.ChunkInfo{GuildID: "10", Nonce: "n1", ChunkIndex: 1, ChunkCount: 2, UserIDs: []string{"2"}})
+	progress := tracker.Progress()
+	if len(progress) != 1 || progress[0].Received != 1 || progress[0].Complete {
+		t.Fatalf("unexpected progress after duplicate chunk: %+v", progress)
+	}
+	if _, ok := st.GetPresence("1"); !ok {
+		t.Fatal("stale member removed before the sequence completed")
+	}
+
+	tracker.Record(lib.ChunkInfo{GuildID: "10", Nonce: "n1", ChunkIndex: 0, ChunkCount: 2})
+	if !tracker.Ready() {
+		t.Fatalf("tracker not ready after all chunks: %+v", tracker.Progress())
+	}
+	if _, ok := st.GetPresence("1"); ok {
+		t.Fatal("member absent from every chunk was not removed")
+	}
+	if _, ok := st.GetPresence("2"); !ok {
+		t.Fatal("member present in a chunk was removed")
+	}
+	p, ok := st.GetPresence("3")
+	if !ok {
+		t.Fatal("member of another guild was removed entirely")
+	}
+	if _, member := p.GuildMembers["10"]; member {
+		t.Fatal("stale guild membership was kept")
+	}
+}
+
+func TestChunkTrackerTimesOutSilentRequests(t *testing.T) {
+	st := store.NewPresenceStore()
+	st.SetPresenceQuiet("1", store.PresenceData{GuildMembers: map[string]map[string]any{"10": {}}})
+	tracker := lib.NewChunkTracker(st)
+	tracker.Timeout = 50 * time.Millisecond
+
+	tracker.Begin("10", "n1")
+	tracker.Record(lib.ChunkInfo{GuildID: "10", Nonce: "n1", ChunkIndex: 0, ChunkCount: 2})
+	if tracker.Ready() {
+		t.Fatal("tracker ready with a chunk outstanding")
+	}
+	time.Sleep(80 * time.Millisecond)
+	progress := tracker.Progress()
+	if len(progress) != 1 || !progress[0].TimedOut || progress[0].Complete {
+		t.Fatalf("expected a timed-out sequence, got %+v", progress)
+	}
+	if !tracker.Ready() {
+		t.Fatal("a timed-out sequence kept the tracker unready")
+	}
+
+	// A late chunk neither completes the sequence nor prunes members.
+	tracker.Record(lib.ChunkInfo{GuildID: "10", Nonce: "n1", ChunkIndex: 1, ChunkCount: 2})
+	if p := tracker.Progress(); p[0].Complete {
+		t.Fatalf("late chunk completed a timed-out sequence: %+v", p)
+	}
+	if _, ok := st.GetPresence("1"); !ok {
+		t.Fatal("a timed-out sequence removed members")
+	}
+
+	tracker.RemoveGuild("10")
+	if p := tracker.Progress(); len(p) != 0 {
+		t.Fatalf("removed guild still reported: %+v", p)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	tracker := lib.NewChunkTracker(store.NewPresenceStore())
+	tracker.Begin("10", "n1")
+
+	serve := func() (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		api.ReadinessHandler{Chunks: tracker}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		return rec.Code, body
+	}
+
+	if code, body := serve(); code != http.StatusServiceUnavailable || body["status"] != "syncing" {
+		t.Fatalf("expected 503 syncing, got %d %v", code, body)
+	}
+	tracker.Record(lib.ChunkInfo{GuildID: "10", Nonce: "n1", ChunkIndex: 0, ChunkCount: 1})
+	code, body := serve()
+	if code != http.StatusOK || body["status"] != "ready" {
+		t.Fatalf("expected 200 ready, got %d %v", code, body)
+	}
+	if _, ok := body["server_time"].(float64); !ok {
+		t.Fatalf("expected server_time, got %v", body)
+	}
+
+	rec := httptest.NewRecorder()
+	api.ReadinessHandler{}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("nil tracker should be ready, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	api.HealthHandler{}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if !strings.Contains(rec.Body.String(), `"server_time":`) {
+		t.Fatalf("expected server_time on /healthz, got %s", rec.Body.String())
+	}
+}
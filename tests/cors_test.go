@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tether/src/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestOriginPolicyMatching(t *testing.T) {
+	policy := middleware.ParseOriginPolicy("https://example.com, https://*.example.org, *.example.net")
+
+	cases := map[string]bool{
+		"":                            true,
+		"https://example.com":         true,
+		"https://EXAMPLE.com":         true,
+		"http://example.com":          false,
+		"https://evil.com":            false,
+		"https://app.example.org":     true,
+		"https://example.org":         false,
+		"http://app.example.org":      false,
+		"http://a.b.example.net":      true,
+		"https://example.com.evil.io": false,
+	}
+	for origin, want := range cases {
+		if got := policy.Allows(origin); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	if !middleware.ParseOriginPolicy("").AllowsAny() || !middleware.ParseOriginPolicy("*").AllowsAny() {
+		t.Error("expected empty and * specs to allow any origin")
+	}
+}
+
+func TestCORSOriginAllowlist(t *testing.T) {
+	r := chi.NewRouter()
+	middleware.Setup(r, false, middleware.ParseOriginPolicy("https://example.com"))
+	r.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("allowed origin is echoed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodOptions, "/test", nil)
+		req.RemoteAddr = "192.168.2.1:12345"
+		req.Header.Set("Origin", "https://example.com")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d", w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://example.com" {
+			t.Fatalf("unexpected Access-Control-Allow-Origin %q", got)
+		}
+	})
+
+	t.Run("disallowed origin is rejected", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = "192.168.2.2:12345"
+		req.Header.Set("Origin", "https://evil.com")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Fatalf("expected no Access-Control-Allow-Origin, got %q", got)
+		}
+	})
+}
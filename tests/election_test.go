@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tether/src/election"
+	"tether/src/store"
+)
+
+// stateLog records the latest state an elector reported.
+type stateLog struct {
+	mu   sync.Mutex
+	last election.State
+}
+
+func (l *stateLog) set(s election.State) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.last = s
+}
+
+func (l *stateLog) get() election.State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+func runElector(t *testing.T, e election.Elector) (*stateLog, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	log := &stateLog{}
+	go func() {
+		defer close(done)
+		e.Run(ctx, log.set)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return log, stop
+}
+
+func TestFileLockElection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	a, stopA := runElector(t, election.FileLock{Path: path, NodeID: "a", URL: "http://a:8080", Interval: 20 * time.Millisecond})
+	waitFor(t, "a to lead", func() bool { return a.get().Leader })
+
+	b, _ := runElector(t, election.FileLock{Path: path, NodeID: "b", URL: "http://b:8080", Interval: 20 * time.Millisecond})
+	waitFor(t, "b to follow a", func() bool { return b.get() == election.State{LeaderID: "a", LeaderURL: "http://a:8080"} })
+
+	stopA()
+	waitFor(t, "b to take over", func() bool { return b.get().Leader })
+}
+
+// leaseNode is an HTTPLease peer whose network can be cut.
+type leaseNode struct {
+	id      string
+	srv     *httptest.Server
+	cut     atomic.Bool
+	elector *election.HTTPLease
+	log     *stateLog
+}
+
+func (n *leaseNode) RoundTrip(r *http.Request) (*http.Response, error) {
+	if n.cut.Load() {
+		return nil, errors.New("network unreachable")
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestHTTPLeaseElectionFailover(t *testing.T) {
+	nodes := make([]*leaseNode, 3)
+	for i, id := range []string{"a", "b", "c"} {
+		n := &leaseNode{id: id}
+		n.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n.cut.Load() {
+				http.Error(w, "unreachable", http.StatusServiceUnavailable)
+				return
+			}
+			n.elector.ServeHTTP(w, r)
+		}))
+		t.Cleanup(n.srv.Close)
+		nodes[i] = n
+	}
+	for _, n := range nodes {
+		var peers []string
+		for _, p := range nodes {
+			if p != n {
+				peers = append(peers, p.srv.URL)
+			}
+		}
+		n.elector = election.NewHTTPLease(election.HTTPLeaseConfig{
+			NodeID: n.id,
+			URL:    n.srv.URL,
+			Peers:  peers,
+			Token:  "secret",
+			TTL:    300 * time.Millisecond,
+			Client: &http.Client{Transport: n},
+		})
+	}
+	for _, n := range nodes {
+		n.log, _ = runElector(t, n.elector)
+	}
+
+	// settled returns the only leader, once every reachable node agrees on it.
+	settled := func() *leaseNode {
+		var leader *leaseNode
+		for _, n := range nodes {
+			if !n.cut.Load() && n.log.get().Leader {
+				if leader != nil {
+					t.Fatalf("%s and %s lead at once", leader.id, n.id)
+				}
+				leader = n
+			}
+		}
+		if leader == nil {
+			return nil
+		}
+		for _, n := range nodes {
+			if n != leader && !n.cut.Load() && n.log.get() != (election.State{LeaderID: leader.id, LeaderURL: leader.srv.URL}) {
+				return nil
+			}
+		}
+		return leader
+	}
+	var first *leaseNode
+	waitFor(t, "a leader", func() bool { first = settled(); return first != nil })
+
+	// Cut the leader off: it must step down, and the others elect one of
+	// themselves.
+	first.cut.Store(true)
+	waitFor(t, "the old leader to step down", func() bool { return !first.log.get().Leader })
+	var second *leaseNode
+	waitFor(t, "failover", func() bool { second = settled(); return second != nil })
+	if second == first {
+		t.Fatal("the unreachable node kept leading")
+	}
+}
+
+func TestHTTPLeaseGraceAfterExpiry(t *testing.T) {
+	lease := election.NewHTTPLease(election.HTTPLeaseConfig{NodeID: "self", Token: "secret", TTL: time.Second})
+	ask := func(nodeID string) bool {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, election.LeasePath, strings.NewReader(`{"node_id":"`+nodeID+`"}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		lease.ServeHTTP(rec, req)
+		var body struct {
+			Data struct {
+				Granted bool `json:"granted"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode %q: %v", rec.Body, err)
+		}
+		return body.Data.Granted
+	}
+
+	start := time.Now()
+	if !ask("a") {
+		t.Fatal("expected the first candidate to get the lease")
+	}
+	// Just after the lease expires, the old leader may still be stopping.
+	time.Sleep(time.Until(start.Add(1050 * time.Millisecond)))
+	if ask("b") {
+		t.Fatal("lease granted to another node within the grace period")
+	}
+	time.Sleep(time.Until(start.Add(1300 * time.Millisecond)))
+	if !ask("b") {
+		t.Fatal("expected the lease to be free after the grace period")
+	}
+}
+
+func TestReceiverFollowSwitchesLeader(t *testing.T) {
+	a := store.NewPresenceStore()
+	setStatus(a, "1", "online")
+	setStatus(a, "2", "idle")
+	srvA := startHub(t, a, "a")
+	b := store.NewPresenceStore()
+	setStatus(b, "1", "dnd")
+	srvB := startHub(t, b, "b")
+
+	// A node that led before keeps nothing of its own once it follows.
+	local := store.NewPresenceStore()
+	setStatus(local, "3", "online")
+	rec := follow(t, local, "", "local")
+	if st := rec.Status(); st.Connected {
+		t.Fatalf("receiver without a source connected: %+v", st)
+	}
+	rec.Follow(srvA.URL)
+	waitFor(t, "sync from a", func() bool { return rec.Status().Synced && rec.Status().SourceNode == "a" })
+	if _, ok := local.GetPublicPresence("3"); ok {
+		t.Fatal("the leader's snapshot did not replace local data")
+	}
+
+	// b takes over with a new epoch, so its data wins over a's.
+	before := b.CurrentVersion()
+	b.NewEpoch()
+	if v := b.CurrentVersion(); !v.Newer(before) || v.Seq != 0 {
+		t.Fatalf("NewEpoch gave %+v after %+v", v, before)
+	}
+	setStatus(b, "1", "dnd")
+	rec.Follow(srvB.URL)
+	waitFor(t, "switch to b", func() bool {
+		p, _ := local.GetPublicPresence("1")
+		_, stale := local.GetPublicPresence("2")
+		return rec.Status().Synced && p.Status == "dnd" && !stale
+	})
+}
@@ -0,0 +1,28 @@
+package tests
+
+import (
+	"slices"
+	"testing"
+
+	"tether/src/utils"
+)
+
+func TestPublicFlagsRegistry(t *testing.T) {
+	flags := 1<<0 | 1<<6 | 1<<16 | 1<<19 | 1<<20 | 1<<21 | 1<<30
+	got := utils.PublicFlagsToNames(flags)
+	want := []string{"Discord_Employee", "House_Bravery", "Verified_Bot", "Bot_HTTP_Interactions", "Spammer", "Unknown_21", "Unknown_30"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("PublicFlagsToNames = %v, want %v", got, want)
+	}
+
+	badges := utils.PublicFlagsToBadges(flags)
+	if len(badges) != 2 || badges[0].ID != "Discord_Employee" || badges[1].ID != "House_Bravery" {
+		t.Fatalf("expected badges only for flags with icons, got %+v", badges)
+	}
+	if badges[1].IconURL != "https://cdn.discordapp.com/badge-icons/8a88d63823d8a71cd5e390baa45efa02.png" {
+		t.Fatalf("unexpected icon_url %q", badges[1].IconURL)
+	}
+	if got := utils.PublicFlagsToNames(0); got == nil || len(got) != 0 {
+		t.Fatalf("expected empty labels for no flags, got %#v", got)
+	}
+}
@@ -0,0 +1,301 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tether/src/api"
+	"tether/src/bot"
+	"tether/src/fakegateway"
+	"tether/src/store"
+	ws "tether/src/websocket"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// scenario runs the real bot against a fake Discord gateway and serves the
+// public REST and WebSocket API from the same store, so tests can script
+// gateway traffic and assert on what clients observe.
+type scenario struct {
+	t   *testing.T
+	gw  *fakegateway.Server
+	st  *store.PresenceStore
+	api *httptest.Server
+}
+
+func newScenario(t *testing.T, guilds ...fakegateway.Guild) *scenario {
+	t.Helper()
+	return newScenarioEnv(t, nil, guilds...)
+}
+
+// newScenarioEnv is newScenario with env applied before the bot launches.
+func newScenarioEnv(t *testing.T, env map[string]string, guilds ...fakegateway.Guild) *scenario {
+	t.Helper()
+	gw := fakegateway.New(guilds...)
+	gw.HeartbeatInterval = 100 * time.Millisecond
+	t.Cleanup(gw.Close)
+
+	t.Setenv("DISCORD_API_URL", gw.URL)
+	t.Setenv("DISCORD_GATEWAY_URL", "")
+	for _, key := range []string{"GUILD_IDS", "GUILD_ID", "SHARD_COUNT", "SHARD_IDS", "GATEWAY_RECORD", "ADMIN_USER_IDS"} {
+		t.Setenv(key, env[key])
+	}
+
+	st := store.NewPresenceStore()
+	shards, err := bot.Launch("fake-token", st)
+	if err != nil {
+		t.Fatalf("launch bot: %v", err)
+	}
+	t.Cleanup(func() { _ = shards.Close() })
+
+	wsServer := ws.NewServer(st, ws.Config{})
+	t.Cleanup(wsServer.Close)
+	r := chi.NewRouter()
+	r.Get("/v1/users/{userID}", api.SnapshotHandler{Store: st}.ServeHTTP)
+	r.Get("/readyz", api.ReadinessHandler{Chunks: shards.Chunks()}.ServeHTTP)
+	r.Handle("/socket", wsServer)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	return &scenario{t: t, gw: gw, st: st, api: srv}
+}
+
+// eventually polls cond until it holds or the deadline passes.
+func (s *scenario) eventually(what string, cond func() bool) {
+	s.t.Helper()
+	waitFor(s.t, what, cond)
+}
+
+func (s *scenario) get(path string) (int, map[string]any) {
+	s.t.Helper()
+	resp, err := http.Get(s.api.URL + path)
+	if err != nil {
+		s.t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var body map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func (s *scenario) dispatch(eventType string, data any) {
+	s.t.Helper()
+	if err := s.gw.Dispatch(eventType, data); err != nil {
+		s.t.Fatalf("dispatch %s: %v", eventType, err)
+	}
+}
+
+// readEvent reads socket messages until one of type want arrives.
+func readEvent(t *testing.T, conn *websocket.Conn, want string) map[string]any {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg struct {
+			T string         `json:"t"`
+			D map[string]any `json:"d"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for %s: %v", want, err)
+		}
+		if msg.T == want {
+			return msg.D
+		}
+	}
+}
+
+func fakeMember(id, username string) map[string]any {
+	return map[string]any{
+		"user":      map[string]any{"id": id, "username": username},
+		"roles":     []any{},
+		"joined_at": "2024-01-01T00:00:00Z",
+	}
+}
+
+func fakePresence(guildID, id, status string) map[string]any {
+	return map[string]any{
+		"guild_id":      guildID,
+		"user":          map[string]any{"id": id},
+		"status":        status,
+		"client_status": map[string]any{"desktop": status},
+		"activities":    []any{},
+	}
+}
+
+func presenceStatus(body map[string]any) string {
+	data, _ := body["data"].(map[string]any)
+	status, _ := data["status"].(string)
+	return status
+}
+
+func TestGatewayEndToEnd(t *testing.T) {
+	s := newScenario(t, fakegateway.Guild{
+		ID:        "100",
+		Members:   []map[string]any{fakeMember("1", "alice"), fakeMember("2", "bob")},
+		Presences: []map[string]any{fakePresence("", "1", "online")},
+	})
+
+	s.eventually("member sync", func() bool {
+		code, _ := s.get("/readyz")
+		return code == http.StatusOK
+	})
+	if reqs := s.gw.MemberRequests(); len(reqs) != 1 || reqs[0].GuildID != "100" || reqs[0].Nonce == "" {
+		t.Fatalf("expected one nonce-tagged member request for guild 100, got %+v", reqs)
+	}
+
+	code, body := s.get("/v1/users/1")
+	if code != http.StatusOK || presenceStatus(body) != "online" {
+		t.Fatalf("expected online presence from chunk, got %d %v", code, body)
+	}
+
+	conn := dialSocket(t, s.api)
+	if err := conn.WriteJSON(map[string]any{"op": 2, "d": map[string]any{"subscribe_to_id": "1"}}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	readEvent(t, conn, "INIT_STATE")
+
+	s.dispatch("PRESENCE_UPDATE", fakePresence("100", "1", "dnd"))
+	update := readEvent(t, conn, "PRESENCE_UPDATE")
+	if data, _ := update["data"].(map[string]any); data["status"] != "dnd" {
+		t.Fatalf("expected dnd over websocket, got %v", update)
+	}
+	if _, body := s.get("/v1/users/1"); presenceStatus(body) != "dnd" {
+		t.Fatalf("expected dnd over REST, got %v", body)
+	}
+
+	s.dispatch("GUILD_MEMBER_REMOVE", map[string]any{"guild_id": "100", "user": map[string]any{"id": "1"}})
+	removed := readEvent(t, conn, "PRESENCE_UPDATE")
+	if removed["removed"] != true {
+		t.Fatalf("expected removal event, got %v", removed)
+	}
+	if code, _ := s.get("/v1/users/1"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 after member remove, got %d", code)
+	}
+
+	registered := false
+	for _, req := range s.gw.Requests() {
+		if req.Method == http.MethodPut && strings.HasSuffix(req.Path, "/applications/"+fakegateway.BotUserID+"/commands") {
+			registered = strings.Contains(req.Body, `"privacy"`)
+		}
+	}
+	if !registered {
+		t.Fatalf("expected global command registration, got %+v", s.gw.Requests())
+	}
+	s.eventually("heartbeats", func() bool { return s.gw.Heartbeats() > 0 })
+}
+
+func TestGatewayLegacyGuildIDIsNotAnAllowlist(t *testing.T) {
+	s := newScenarioEnv(t, map[string]string{"GUILD_ID": "100"},
+		fakegateway.Guild{
+			ID:        "100",
+			Members:   []map[string]any{fakeMember("1", "alice")},
+			Presences: []map[string]any{fakePresence("", "1", "online")},
+		},
+		fakegateway.Guild{ID: "200", Members: []map[string]any{fakeMember("2", "bob")}},
+	)
+	s.eventually("member sync", func() bool {
+		code, _ := s.get("/readyz")
+		return code == http.StatusOK
+	})
+	if reqs := s.gw.MemberRequests(); len(reqs) != 1 || reqs[0].GuildID != "100" {
+		t.Fatalf("expected members requested from guild 100 only, got %+v", reqs)
+	}
+
+	s.dispatch("PRESENCE_UPDATE", fakePresence("200", "2", "idle"))
+	s.eventually("presence from guild 200", func() bool {
+		_, body := s.get("/v1/users/2")
+		return presenceStatus(body) == "idle"
+	})
+
+	s.eventually("command registration", func() bool {
+		for _, req := range s.gw.Requests() {
+			if req.Method == http.MethodPut && strings.HasSuffix(req.Path, "/guilds/100/commands") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestGatewayGuildDeleteDropsMembers(t *testing.T) {
+	s := newScenario(t,
+		fakegateway.Guild{
+			ID:        "100",
+			Members:   []map[string]any{fakeMember("1", "alice"), fakeMember("2", "bob")},
+			Presences: []map[string]any{fakePresence("", "1", "online"), fakePresence("", "2", "online")},
+		},
+		fakegateway.Guild{
+			ID:        "200",
+			Members:   []map[string]any{fakeMember("1", "alice")},
+			Presences: []map[string]any{fakePresence("", "1", "online")},
+		},
+	)
+	s.eventually("member sync", func() bool {
+		code, body := s.get("/readyz")
+		guilds, _ := body["guilds"].([]any)
+		return code == http.StatusOK && len(guilds) == 2
+	})
+
+	// An outage is not a removal.
+	s.dispatch("GUILD_DELETE", map[string]any{"id": "100", "unavailable": true})
+	s.dispatch("GUILD_DELETE", map[string]any{"id": "200"})
+	s.eventually("guild 200 forgotten", func() bool {
+		p, ok := s.st.GetPresence("1")
+		_, member := p.GuildMembers["200"]
+		return ok && !member
+	})
+	if _, ok := s.st.GetPresence("2"); !ok {
+		t.Fatal("an unavailable guild's members were removed")
+	}
+	_, body := s.get("/readyz")
+	if guilds, _ := body["guilds"].([]any); len(guilds) != 1 {
+		t.Fatalf("expected only guild 100's sync, got %v", body["guilds"])
+	}
+
+	s.dispatch("GUILD_DELETE", map[string]any{"id": "100"})
+	s.eventually("guild 100 members removed", func() bool {
+		_, one := s.st.GetPresence("1")
+		_, two := s.st.GetPresence("2")
+		return !one && !two
+	})
+}
+
+func TestGatewayResumeResyncsMembers(t *testing.T) {
+	s := newScenario(t, fakegateway.Guild{
+		ID:        "100",
+		Members:   []map[string]any{fakeMember("1", "alice")},
+		Presences: []map[string]any{fakePresence("", "1", "online")},
+	})
+	s.eventually("initial member sync", func() bool { return len(s.gw.MemberRequests()) == 1 })
+
+	if err := s.gw.Reconnect(); err != nil {
+		t.Fatalf("reconnect: %v", err)
+	}
+	s.eventually("resume", func() bool { return s.gw.Resumes() == 1 })
+	s.eventually("member resync after resume", func() bool { return len(s.gw.MemberRequests()) == 2 })
+	if s.gw.Identifies() != 1 {
+		t.Fatalf("expected resume without a fresh identify, got %d identifies", s.gw.Identifies())
+	}
+	// Wait for the resync's chunks to land so they cannot overwrite the
+	// update dispatched below.
+	nonce := s.gw.MemberRequests()[1].Nonce
+	s.eventually("resync completion", func() bool {
+		code, body := s.get("/readyz")
+		guilds, _ := body["guilds"].([]any)
+		if code != http.StatusOK || len(guilds) != 1 {
+			return false
+		}
+		g, _ := guilds[0].(map[string]any)
+		return g["nonce"] == nonce
+	})
+
+	s.dispatch("PRESENCE_UPDATE", fakePresence("100", "1", "idle"))
+	s.eventually("presence after resume", func() bool {
+		_, body := s.get("/v1/users/1")
+		return presenceStatus(body) == "idle"
+	})
+}
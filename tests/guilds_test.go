@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"tether/src/lib"
+	"tether/src/store"
+)
+
+func mustRaw(t *testing.T, v any) json.RawMessage {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return data
+}
+
+func memberUpdate(t *testing.T, guildID, avatar string) json.RawMessage {
+	return mustRaw(t, map[string]any{
+		"guild_id": guildID,
+		"roles":    []any{},
+		"avatar":   avatar,
+		"user":     map[string]any{"id": "500", "username": "multi", "avatar": "useravatar"},
+	})
+}
+
+func TestMultiGuildMergePolicy(t *testing.T) {
+	t.Cleanup(func() { lib.SetGuildPriority(nil) })
+	st := store.NewPresenceStore()
+
+	lib.MergeRawUser(st, memberUpdate(t, "200", "guildb"))
+	lib.MergeRawUser(st, memberUpdate(t, "100", "guilda"))
+
+	// Without a priority list the oldest (lowest) guild wins.
+	got, _ := st.GetPresence("500")
+	if got.DiscordUser.Avatar != "guilda" {
+		t.Fatalf("expected oldest guild avatar, got %q", got.DiscordUser.Avatar)
+	}
+	if len(got.GuildMembers) != 2 {
+		t.Fatalf("expected membership in 2 guilds, got %d", len(got.GuildMembers))
+	}
+
+	// The configured priority overrides snowflake order.
+	lib.SetGuildPriority([]string{"200", "100"})
+	lib.MergeRawUser(st, memberUpdate(t, "100", "guilda"))
+	got, _ = st.GetPresence("500")
+	if got.DiscordUser.Avatar != "guildb" {
+		t.Fatalf("expected priority guild avatar, got %q", got.DiscordUser.Avatar)
+	}
+
+	// Leaving one guild keeps the user and falls back to the remaining guild.
+	lib.RemoveGuildMember(st, "200", "500")
+	got, ok := st.GetPresence("500")
+	if !ok {
+		t.Fatalf("expected user to remain tracked via guild 100")
+	}
+	if got.DiscordUser.Avatar != "guilda" || !strings.Contains(got.DiscordUser.AvatarURL, "guilda") {
+		t.Fatalf("expected fallback to guild 100 avatar, got %q", got.DiscordUser.AvatarURL)
+	}
+
+	// Leaving the last guild removes the presence.
+	lib.RemoveGuildMember(st, "100", "500")
+	if _, ok := st.GetPresence("500"); ok {
+		t.Fatalf("expected presence removed after leaving all guilds")
+	}
+}
+
+func TestChunkPreservesOtherGuildMembership(t *testing.T) {
+	st := store.NewPresenceStore()
+	lib.MergeRawUser(st, memberUpdate(t, "100", "guilda"))
+
+	lib.UpsertChunkPresences(st, mustRaw(t, map[string]any{
+		"guild_id": "200",
+		"members": []any{map[string]any{
+			"roles": []any{},
+			"user":  map[string]any{"id": "500", "username": "multi"},
+		}},
+		"presences": []any{map[string]any{
+			"user":   map[string]any{"id": "500"},
+			"status": "online",
+		}},
+	}))
+
+	got, _ := st.GetPresence("500")
+	if got.DiscordStatus != "online" {
+		t.Fatalf("expected online status from chunk, got %q", got.DiscordStatus)
+	}
+	if _, ok := got.GuildMembers["100"]; !ok {
+		t.Fatalf("expected guild 100 membership to survive chunk from guild 200")
+	}
+	if got.DiscordUser.Avatar != "guilda" {
+		t.Fatalf("expected guild 100 avatar override to persist, got %q", got.DiscordUser.Avatar)
+	}
+}
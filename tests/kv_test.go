@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tether/src/api"
+	"tether/src/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func newKVRouter(st *store.PresenceStore) *chi.Mux {
+	r := chi.NewRouter()
+	r.Put("/v1/users/{userID}/kv/{key}", api.KVHandler{Store: st}.ServeHTTP)
+	r.Delete("/v1/users/{userID}/kv/{key}", api.KVHandler{Store: st}.ServeHTTP)
+	return r
+}
+
+func TestKVEndpointRequiresOwnersKey(t *testing.T) {
+	st := store.NewPresenceStore()
+	st.SetPresence("100", store.PresenceData{DiscordStatus: "online", DiscordUser: store.DiscordUser{ID: "100"}})
+	key, err := st.IssueAPIKey("100")
+	if err != nil {
+		t.Fatalf("issue key: %v", err)
+	}
+	otherKey, _ := st.IssueAPIKey("200")
+	r := newKVRouter(st)
+
+	do := func(method, path, auth, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := do(http.MethodPut, "/v1/users/100/kv/location", "", "Tokyo"); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without key, got %d", code)
+	}
+	if code := do(http.MethodPut, "/v1/users/100/kv/location", otherKey, "Tokyo"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 with another user's key, got %d", code)
+	}
+	if code := do(http.MethodPut, "/v1/users/100/kv/bad-key", key, "x"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid key, got %d", code)
+	}
+	if code := do(http.MethodPut, "/v1/users/100/kv/big", key, strings.Repeat("x", store.MaxKVValueLength+1)); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for oversized value, got %d", code)
+	}
+
+	_, ch, cancel := st.Subscribe()
+	t.Cleanup(cancel)
+	if code := do(http.MethodPut, "/v1/users/100/kv/location", "Bearer "+key, "Tokyo"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	select {
+	case evt := <-ch:
+		if evt.Presence.Public.KV["location"] != "Tokyo" {
+			t.Fatalf("expected kv in broadcast, got %+v", evt.Presence.Public.KV)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no broadcast received")
+	}
+
+	pub, _ := st.GetPublicPresence("100")
+	if pub.KV["location"] != "Tokyo" {
+		t.Fatalf("expected kv on public presence, got %+v", pub.KV)
+	}
+
+	if code := do(http.MethodDelete, "/v1/users/100/kv/location", key, ""); code != http.StatusOK {
+		t.Fatalf("expected 200 on delete, got %d", code)
+	}
+	if code := do(http.MethodDelete, "/v1/users/100/kv/location", key, ""); code != http.StatusNotFound {
+		t.Fatalf("expected 404 deleting missing key, got %d", code)
+	}
+}
+
+func TestKVKeyLimit(t *testing.T) {
+	st := store.NewPresenceStore()
+	for i := range store.MaxKVKeys {
+		if err := st.SetKV("1", "k"+strings.Repeat("x", i), "v"); err != nil {
+			t.Fatalf("set key %d: %v", i, err)
+		}
+	}
+	if err := st.SetKV("1", "overflow", "v"); err != store.ErrKVTooManyKeys {
+		t.Fatalf("expected ErrKVTooManyKeys, got %v", err)
+	}
+	// Overwriting an existing key stays within the limit.
+	if err := st.SetKV("1", "k", "updated"); err != nil {
+		t.Fatalf("overwrite existing key: %v", err)
+	}
+}
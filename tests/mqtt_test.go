@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tether/src/mqtt"
+	"tether/src/store"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// startBroker runs an embedded MQTT broker on a free local port.
+func startBroker(t *testing.T) (*mochi.Server, string) {
+	t.Helper()
+	addr := freeAddr(t)
+	server, _ := startBrokerAt(t, addr, nil)
+	return server, "tcp://" + addr
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("pick port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startBrokerAt runs an embedded MQTT broker on addr that starts out with
+// the retained messages given, as a broker restored from persistence would.
+// stop shuts it down early.
+func startBrokerAt(t *testing.T, addr string, retained map[string]string) (server *mochi.Server, stop func()) {
+	t.Helper()
+	server = mochi.New(&mochi.Options{InlineClient: true, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	_ = server.AddHook(new(auth.AllowHook), nil)
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: addr})); err != nil {
+		t.Fatalf("add listener: %v", err)
+	}
+	for topic, payload := range retained {
+		if err := server.Publish(topic, []byte(payload), true, 1); err != nil {
+			t.Fatalf("seed %s: %v", topic, err)
+		}
+	}
+	go func() { _ = server.Serve() }()
+	stop = sync.OnceFunc(func() { _ = server.Close() })
+	t.Cleanup(stop)
+	return server, stop
+}
+
+// topicRecorder keeps the latest payload and the full history per topic.
+type topicRecorder struct {
+	mu      sync.Mutex
+	latest  map[string]string
+	history map[string][]string
+}
+
+var observers atomic.Int64
+
+func subscribeAll(t *testing.T, broker, filter string) *topicRecorder {
+	t.Helper()
+	rec := &topicRecorder{latest: map[string]string{}, history: map[string][]string{}}
+	id := fmt.Sprintf("observer-%d", observers.Add(1))
+	client := paho.NewClient(paho.NewClientOptions().AddBroker(broker).SetClientID(id))
+	if tok := client.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("observer connect: %v", tok.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(100) })
+	tok := client.Subscribe(filter, 1, func(_ paho.Client, m paho.Message) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.latest[m.Topic()] = string(m.Payload())
+		rec.history[m.Topic()] = append(rec.history[m.Topic()], string(m.Payload()))
+	})
+	if !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("observer subscribe: %v", tok.Error())
+	}
+	return rec
+}
+
+func (r *topicRecorder) get(topic string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.latest[topic]
+	return v, ok
+}
+
+func (r *topicRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.latest)
+}
+
+func (r *topicRecorder) saw(topic, payload string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.history[topic] {
+		if v == payload {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMQTTBridgePublishesRetainedPresence(t *testing.T) {
+	_, broker := startBroker(t)
+	st := store.NewPresenceStore()
+	song, artist := "Song", "Artist"
+	st.SetPresence("1", store.PresenceData{
+		DiscordStatus: "dnd",
+		DiscordUser:   store.DiscordUser{ID: "1", Username: "gamer"},
+		Activities: []store.Activity{
+			{"type": 2, "name": "Spotify"},
+			{"type": 0, "name": "Factorio"},
+		},
+		Spotify: &store.Spotify{Song: &song, Artist: &artist},
+	})
+
+	bridge := mqtt.New(st, mqtt.Config{BrokerURL: broker, DiscoveryPrefix: "homeassistant"})
+	if err := bridge.Start(); err != nil {
+		t.Fatalf("start bridge: %v", err)
+	}
+	defer bridge.Close()
+
+	live := subscribeAll(t, broker, "#")
+	waitFor(t, "initial publish", func() bool {
+		_, ok := live.get("tether/1/spotify")
+		return ok
+	})
+	// A subscriber that arrives afterwards receives everything as retained.
+	rec := subscribeAll(t, broker, "#")
+	waitFor(t, "retained topics", func() bool { return rec.count() == live.count() })
+	if v, _ := rec.get("tether/bridge/state"); v != mqtt.StateOnline {
+		t.Errorf("bridge state = %q, want online", v)
+	}
+	for topic, want := range map[string]string{
+		"tether/1/status":  "dnd",
+		"tether/1/game":    "Factorio",
+		"tether/1/spotify": "Artist - Song",
+	} {
+		if got, _ := rec.get(topic); got != want {
+			t.Errorf("%s = %q, want %q", topic, got, want)
+		}
+	}
+	presence, _ := rec.get("tether/1/presence")
+	var snapshot map[string]any
+	if err := json.Unmarshal([]byte(presence), &snapshot); err != nil || snapshot["status"] != "dnd" {
+		t.Errorf("unexpected presence payload %q", presence)
+	}
+
+	var discovery map[string]any
+	config, _ := rec.get("homeassistant/sensor/tether_1/game/config")
+	if err := json.Unmarshal([]byte(config), &discovery); err != nil {
+		t.Fatalf("missing discovery config: %q", config)
+	}
+	if discovery["state_topic"] != "tether/1/game" || discovery["availability_topic"] != "tether/bridge/state" {
+		t.Errorf("unexpected discovery config %v", discovery)
+	}
+
+	// Live updates, and hidden users clear their retained topics.
+	st.SetPresence("1", store.PresenceData{DiscordStatus: "online", DiscordUser: store.DiscordUser{ID: "1", Username: "gamer"}})
+	waitFor(t, "status update", func() bool {
+		v, _ := rec.get("tether/1/game")
+		return v == "none"
+	})
+	st.RemovePresence("1")
+	waitFor(t, "cleared topics", func() bool {
+		v, ok := rec.get("tether/1/status")
+		return ok && v == ""
+	})
+}
+
+func TestMQTTBridgeOfflineState(t *testing.T) {
+	server, broker := startBroker(t)
+	bridge := mqtt.New(store.NewPresenceStore(), mqtt.Config{BrokerURL: broker, ClientID: "lwt"})
+	if err := bridge.Start(); err != nil {
+		t.Fatalf("start bridge: %v", err)
+	}
+	defer bridge.Close()
+	rec := subscribeAll(t, broker, "tether/bridge/state")
+	waitFor(t, "bridge online", func() bool { return rec.saw("tether/bridge/state", mqtt.StateOnline) })
+
+	// Dropping the connection without a DISCONNECT triggers the last will.
+	cl, ok := server.Clients.Get("lwt")
+	if !ok {
+		t.Fatal("bridge client not found on broker")
+	}
+	cl.Stop(errors.New("connection dropped"))
+	waitFor(t, "last will", func() bool { return rec.saw("tether/bridge/state", mqtt.StateOffline) })
+
+	// A graceful close leaves "offline" retained.
+	waitFor(t, "reconnect", func() bool {
+		v, _ := rec.get("tether/bridge/state")
+		return v == mqtt.StateOnline
+	})
+	bridge.Close()
+	after := subscribeAll(t, broker, "tether/bridge/state")
+	waitFor(t, "retained offline", func() bool {
+		v, _ := after.get("tether/bridge/state")
+		return v == mqtt.StateOffline
+	})
+}
+
+func TestMQTTBridgeClearsUsersRemovedWhileDisconnected(t *testing.T) {
+	addr := freeAddr(t)
+	broker := "tcp://" + addr
+	_, stop := startBrokerAt(t, addr, nil)
+	st := store.NewPresenceStore()
+	for _, id := range []string{"1", "2"} {
+		st.SetPresence(id, store.PresenceData{DiscordStatus: "online", DiscordUser: store.DiscordUser{ID: id}})
+	}
+	bridge := mqtt.New(st, mqtt.Config{BrokerURL: broker, DiscoveryPrefix: "homeassistant"})
+	if err := bridge.Start(); err != nil {
+		t.Fatalf("start bridge: %v", err)
+	}
+	defer bridge.Close()
+	rec := subscribeAll(t, broker, "#")
+	waitFor(t, "initial publish", func() bool {
+		_, ok := rec.get("tether/2/status")
+		return ok
+	})
+
+	// User 2 leaves while the broker is down, so the bridge cannot clear
+	// the topics the broker keeps across its restart.
+	stop()
+	// Let the bridge notice, so it does not queue the clear for resending.
+	time.Sleep(100 * time.Millisecond)
+	st.RemovePresence("2")
+	startBrokerAt(t, addr, map[string]string{
+		"tether/2/status": "online",
+		"homeassistant/sensor/tether_2/status/config": "{}",
+	})
+	after := subscribeAll(t, broker, "#")
+
+	// The resync after reconnecting clears them and keeps everyone else.
+	waitFor(t, "cleared topics", func() bool {
+		status, seen := after.get("tether/2/status")
+		config, _ := after.get("homeassistant/sensor/tether_2/status/config")
+		return seen && after.saw("tether/2/status", "online") && status == "" && config == ""
+	})
+	waitFor(t, "republished user", func() bool {
+		v, _ := after.get("tether/1/status")
+		return v == "online"
+	})
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tether/src/store"
+)
+
+func TestPrivacyRedactsPublicPresence(t *testing.T) {
+	st := store.NewPresenceStore()
+	st.SetPresence("42", store.PresenceData{
+		DiscordStatus:       "online",
+		ActiveClients:       []string{"desktop"},
+		PrimaryActiveClient: "desktop",
+		Activities:          []store.Activity{{"name": "Game", "type": float64(0)}},
+		Spotify:             &store.Spotify{},
+		DiscordUser:         store.DiscordUser{ID: "42"},
+	})
+
+	st.SetPrivacy("42", store.PrivacySettings{HideActivities: true, HideSpotify: true, HideClients: true})
+	pub, ok := st.GetPublicPresence("42")
+	if !ok {
+		t.Fatalf("expected presence to be visible")
+	}
+	if len(pub.Activities) != 0 || pub.Spotify != nil || len(pub.Clients.Active) != 0 || pub.Clients.Primary != "" {
+		t.Fatalf("expected redacted presence, got %+v", pub)
+	}
+
+	// Redaction must survive later gateway updates.
+	st.SetPresence("42", store.PresenceData{
+		DiscordStatus: "idle",
+		Activities:    []store.Activity{{"name": "Other", "type": float64(0)}},
+		DiscordUser:   store.DiscordUser{ID: "42"},
+	})
+	pub, _ = st.GetPublicPresence("42")
+	if len(pub.Activities) != 0 || pub.Status != "idle" {
+		t.Fatalf("expected redaction after update, got %+v", pub)
+	}
+}
+
+func TestPrivacyHiddenUserIsRemoved(t *testing.T) {
+	st := store.NewPresenceStore()
+	st.SetPresence("7", store.PresenceData{DiscordStatus: "online", DiscordUser: store.DiscordUser{ID: "7"}})
+
+	_, ch, cancel := st.Subscribe()
+	t.Cleanup(cancel)
+
+	st.SetPrivacy("7", store.PrivacySettings{Hidden: true})
+	if _, ok := st.GetPublicPresence("7"); ok {
+		t.Fatalf("expected hidden user to be absent")
+	}
+	select {
+	case evt := <-ch:
+		if !evt.Removed {
+			t.Fatalf("expected removal event for hidden user, got %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no broadcast received")
+	}
+}
+
+func TestPrivacyPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "privacy.json")
+
+	st := store.NewPresenceStore()
+	if err := st.LoadPrivacy(path); err != nil {
+		t.Fatalf("load empty privacy file: %v", err)
+	}
+	st.SetPrivacy("99", store.PrivacySettings{HideSpotify: true})
+
+	reloaded := store.NewPresenceStore()
+	if err := reloaded.LoadPrivacy(path); err != nil {
+		t.Fatalf("reload privacy file: %v", err)
+	}
+	if got := reloaded.GetPrivacy("99"); !got.HideSpotify || got.Hidden {
+		t.Fatalf("unexpected persisted settings %+v", got)
+	}
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"tether/src/lib"
+	"tether/src/store"
+)
+
+func TestProfileBannerAccentAndNameplate(t *testing.T) {
+	st := store.NewPresenceStore()
+	lib.MergeRawUser(st, mustRaw(t, map[string]any{
+		"guild_id": "100",
+		"roles":    []any{},
+		"user": map[string]any{
+			"id":           "600",
+			"username":     "profile",
+			"banner":       "a_userbanner",
+			"accent_color": 16711680,
+			"collectibles": map[string]any{"nameplate": map[string]any{
+				"sku_id": "1",
+				"asset":  "nameplates/nameplates_v3/bonsai/",
+				"label":  "Bonsai",
+			}},
+		},
+	}))
+
+	got, _ := st.GetPresence("600")
+	user := got.DiscordUser
+	if user.BannerURL != "https://cdn.discordapp.com/banners/600/a_userbanner.gif?size=1024" {
+		t.Fatalf("unexpected banner_url %q", user.BannerURL)
+	}
+	if user.AccentColor == nil || *user.AccentColor != 16711680 {
+		t.Fatalf("unexpected accent_color %v", user.AccentColor)
+	}
+	if user.NameplateURL != "https://cdn.discordapp.com/assets/collectibles/nameplates/nameplates_v3/bonsai/static.png" {
+		t.Fatalf("unexpected nameplate_url %q", user.NameplateURL)
+	}
+
+	// A later event without profile fields keeps them; a member banner
+	// overrides the user banner for its guild.
+	lib.MergeRawUser(st, mustRaw(t, map[string]any{
+		"guild_id": "100",
+		"roles":    []any{},
+		"banner":   "memberbanner",
+		"user":     map[string]any{"id": "600", "username": "profile"},
+	}))
+	got, _ = st.GetPresence("600")
+	if got.DiscordUser.BannerURL != "https://cdn.discordapp.com/guilds/100/users/600/banners/memberbanner.webp?size=1024" {
+		t.Fatalf("expected member banner, got %q", got.DiscordUser.BannerURL)
+	}
+	if got.BaseUser.Banner != "a_userbanner" || got.DiscordUser.AccentColor == nil || got.DiscordUser.NameplateURL == "" {
+		t.Fatalf("profile fields should survive a sparse update, got %+v", got.DiscordUser)
+	}
+}
+
+func TestMemberProfileAllowlist(t *testing.T) {
+	t.Cleanup(func() {
+		lib.SetMemberAllowlist(nil)
+		lib.SetRoleResolver(nil)
+	})
+	lib.SetRoleResolver(func(guildID, roleID string) (store.GuildRole, bool) {
+		if guildID == "100" && roleID == "7" {
+			return store.GuildRole{Name: "Mods", Color: 0x3498db}, true
+		}
+		return store.GuildRole{}, false
+	})
+	member := func(userID string) []byte {
+		return mustRaw(t, map[string]any{
+			"guild_id":      "100",
+			"nick":          "Nick",
+			"avatar":        "guildav",
+			"roles":         []any{"7", "8"},
+			"joined_at":     "2021-01-01T00:00:00+00:00",
+			"premium_since": "2022-02-02T00:00:00+00:00",
+			"user":          map[string]any{"id": userID, "username": "m" + userID},
+		})
+	}
+
+	st := store.NewPresenceStore()
+	// As split from MEMBER_PROFILE_ALLOWLIST="699, 700".
+	lib.SetMemberAllowlist(strings.Split("699, 700", ","))
+	lib.MergeRawUser(st, member("700"))
+	lib.MergeRawUser(st, member("701"))
+
+	if got, _ := st.GetPresence("701"); got.Public.Member != nil {
+		t.Fatalf("member data exposed for a user outside the allowlist: %+v", got.Public.Member)
+	}
+	got, _ := st.GetPresence("700")
+	m := got.Public.Member
+	if m == nil {
+		t.Fatal("expected member object for allowlisted user")
+	}
+	if m.GuildID != "100" || m.Nick != "Nick" || !m.Boosting || m.JoinedAt == "" {
+		t.Fatalf("unexpected member profile %+v", m)
+	}
+	if m.AvatarURL != "https://cdn.discordapp.com/guilds/100/users/700/avatars/guildav.webp?size=256" {
+		t.Fatalf("unexpected member avatar_url %q", m.AvatarURL)
+	}
+	want := []store.GuildRole{{ID: "7", Name: "Mods", Color: 0x3498db}, {ID: "8"}}
+	if len(m.Roles) != 2 || m.Roles[0] != want[0] || m.Roles[1] != want[1] {
+		t.Fatalf("unexpected roles %+v", m.Roles)
+	}
+}
@@ -13,7 +13,7 @@ import (
 
 func TestRateLimitMiddleware(t *testing.T) {
 	r := chi.NewRouter()
-	middleware.Setup(r, false) // false = not behind proxy for tests
+	middleware.Setup(r, false, middleware.OriginPolicy{}) // false = not behind proxy for tests
 
 	// Simple test handler
 	r.Get("/test", func(w http.ResponseWriter, r *http.Request) {
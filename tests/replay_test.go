@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tether/src/bot"
+	"tether/src/store"
+)
+
+func TestRecordAndReplayGatewayTraffic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.jsonl")
+	rec, err := bot.NewRecorder(path)
+	if err != nil {
+		t.Fatalf("open recorder: %v", err)
+	}
+	rec.Record(0, "GUILD_MEMBER_ADD", mustRaw(t, map[string]any{
+		"guild_id": "100",
+		"roles":    []any{},
+		"user":     map[string]any{"id": "42", "username": "replayed"},
+	}))
+	rec.Record(0, "PRESENCE_UPDATE", mustRaw(t, map[string]any{
+		"guild_id":      "100",
+		"status":        "online",
+		"client_status": map[string]any{"desktop": "online"},
+		"activities":    []any{},
+		"user":          map[string]any{"id": "42"},
+	}))
+	rec.Record(0, "GUILD_MEMBER_REMOVE", mustRaw(t, map[string]any{
+		"guild_id": "100",
+		"user":     map[string]any{"id": "7"},
+	}))
+	if err := rec.Close(); err != nil {
+		t.Fatalf("close recorder: %v", err)
+	}
+
+	st := store.NewPresenceStore()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bot.Replay(ctx, path, st, 0); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+
+	got, ok := st.GetPresence("42")
+	if !ok {
+		t.Fatal("replayed presence was not stored")
+	}
+	if got.DiscordUser.Username != "replayed" || got.DiscordStatus != "online" {
+		t.Fatalf("unexpected replayed presence: user=%q status=%q", got.DiscordUser.Username, got.DiscordStatus)
+	}
+}
+
+func TestReplayStopsOnCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.jsonl")
+	rec, err := bot.NewRecorder(path)
+	if err != nil {
+		t.Fatalf("open recorder: %v", err)
+	}
+	member := mustRaw(t, map[string]any{"guild_id": "100", "user": map[string]any{"id": "1"}})
+	rec.Record(0, "GUILD_MEMBER_ADD", member)
+	time.Sleep(50 * time.Millisecond)
+	rec.Record(0, "GUILD_MEMBER_ADD", member)
+	_ = rec.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// At 0.001x the 50ms gap would take almost a minute.
+	if err := bot.Replay(ctx, path, store.NewPresenceStore(), 0.001); err == nil {
+		t.Fatal("expected replay to stop when the context is cancelled")
+	}
+}
@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tether/src/api"
+	"tether/src/replication"
+	"tether/src/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func startHub(t *testing.T, st *store.PresenceStore, nodeID string) *httptest.Server {
+	t.Helper()
+	hub := replication.NewHub(st, nodeID, "secret")
+	st.AddReplicator(hub)
+	srv := httptest.NewServer(hub)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func follow(t *testing.T, st *store.PresenceStore, source, nodeID string) *replication.Receiver {
+	t.Helper()
+	rec := replication.NewReceiver(st, replication.ReceiverConfig{Source: source, Token: "secret", NodeID: nodeID})
+	rec.Start()
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func TestReplicationBootstrapAndLiveEvents(t *testing.T) {
+	leader := store.NewPresenceStore()
+	leader.SetPresence("1", store.PresenceData{
+		DiscordStatus:   "online",
+		DiscordUser:     store.DiscordUser{ID: "1"},
+		Activities:      []store.Activity{{"type": 0, "name": "Factorio"}},
+		TypedActivities: []store.PublicActivity{{Type: 0, Name: "Factorio"}},
+	})
+	setStatus(leader, "2", "idle")
+	srv := startHub(t, leader, "leader")
+
+	follower := store.NewPresenceStore()
+	rec := follow(t, follower, srv.URL, "follower")
+	waitFor(t, "snapshot", func() bool { return rec.Status().Synced })
+
+	got, ok := follower.GetPublicPresence("1")
+	if !ok || got.Status != "online" || len(got.Activities) != 1 {
+		t.Fatalf("bootstrap did not copy user 1: %+v", got)
+	}
+	if len(got.TypedActivities) != 1 || got.TypedActivities[0].Name != "Factorio" {
+		t.Fatalf("typed activities were not replicated: %+v", got.TypedActivities)
+	}
+	if st := rec.Status(); !st.Connected || st.SourceNode != "leader" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	setStatus(leader, "1", "dnd")
+	waitFor(t, "live update", func() bool {
+		p, _ := follower.GetPublicPresence("1")
+		return p.Status == "dnd"
+	})
+	leader.RemovePresence("2")
+	waitFor(t, "live removal", func() bool {
+		_, ok := follower.GetPublicPresence("2")
+		return !ok
+	})
+
+	// After a reconnect the new snapshot replaces the follower's state,
+	// including users removed while it was away.
+	setStatus(leader, "3", "online")
+	waitFor(t, "user 3", func() bool { _, ok := follower.GetPublicPresence("3"); return ok })
+	srv.CloseClientConnections()
+	leader.RemovePresence("3")
+	waitFor(t, "resync", func() bool {
+		_, ok := follower.GetPublicPresence("3")
+		return !ok && rec.Status().Synced
+	})
+	if _, ok := follower.GetPublicPresence("1"); !ok {
+		t.Fatal("resync dropped a user that is still present")
+	}
+}
+
+func TestApplyReplicatedOrdersByVersion(t *testing.T) {
+	st := store.NewPresenceStore()
+	event := func(status string, epoch int64, seq uint64) store.PresenceEvent {
+		return store.PresenceEvent{UserID: "1", Version: store.Version{Epoch: epoch, Seq: seq}, Presence: store.PresenceData{
+			Public: store.PublicPresence{Status: status},
+		}}
+	}
+
+	if !st.ApplyReplicated(event("online", 1, 5)) {
+		t.Fatal("first event was rejected")
+	}
+	if st.ApplyReplicated(event("idle", 1, 4)) {
+		t.Fatal("an older event was applied")
+	}
+	if p, _ := st.GetPublicPresence("1"); p.Status != "online" {
+		t.Fatalf("status = %q, want online", p.Status)
+	}
+	if !st.ApplyReplicated(store.PresenceEvent{UserID: "1", Removed: true, Version: store.Version{Epoch: 1, Seq: 6}}) {
+		t.Fatal("removal was rejected")
+	}
+	if st.ApplyReplicated(event("dnd", 1, 5)) {
+		t.Fatal("an event older than the removal resurrected the user")
+	}
+	// A restarted source has a later epoch, so its versions win.
+	if !st.ApplyReplicated(event("dnd", 2, 1)) {
+		t.Fatal("event from a newer epoch was rejected")
+	}
+}
+
+func TestReplicationLoopPrevention(t *testing.T) {
+	a := store.NewPresenceStore()
+	b := store.NewPresenceStore()
+	srvA := startHub(t, a, "a")
+	srvB := startHub(t, b, "b")
+	recB := follow(t, b, srvA.URL, "b")
+	recA := follow(t, a, srvB.URL, "a")
+	waitFor(t, "both streams", func() bool { return recA.Status().Synced && recB.Status().Synced })
+
+	_, events, unsubscribe := a.Subscribe()
+	defer unsubscribe()
+	setStatus(a, "1", "online")
+	waitFor(t, "replicated to b", func() bool { _, ok := b.GetPublicPresence("1"); return ok })
+
+	<-events // a's own mutation
+	select {
+	case evt := <-events:
+		t.Fatalf("event came back to its origin: %+v", evt)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// A node refuses to follow itself.
+	self := follow(t, store.NewPresenceStore(), srvA.URL, "a")
+	waitFor(t, "loop rejection", func() bool { return strings.Contains(self.Status().LastError, "409") })
+}
+
+func TestFollowerReadinessAndReadOnlyKV(t *testing.T) {
+	leader := store.NewPresenceStore()
+	srv := startHub(t, leader, "leader")
+
+	follower := store.NewPresenceStore()
+	rec := replication.NewReceiver(follower, replication.ReceiverConfig{Source: srv.URL, Token: "secret", NodeID: "follower"})
+	readiness := func() (int, map[string]any) {
+		w := httptest.NewRecorder()
+		api.ReadinessHandler{Replication: rec, MaxLag: time.Minute}.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		return w.Code, body
+	}
+
+	if code, body := readiness(); code != http.StatusServiceUnavailable || body["status"] != "disconnected" {
+		t.Fatalf("expected 503 disconnected before connecting, got %d %v", code, body)
+	}
+	rec.Start()
+	defer rec.Close()
+	waitFor(t, "ready", func() bool { code, _ := readiness(); return code == http.StatusOK })
+	_, body := readiness()
+	leaderInfo, _ := body["leader"].(map[string]any)
+	if leaderInfo["source_node"] != "leader" || leaderInfo["synced"] != true {
+		t.Fatalf("unexpected leader details %v", body["leader"])
+	}
+	if _, ok := leaderInfo["lag_ms"].(float64); !ok {
+		t.Fatalf("lag_ms missing from %v", leaderInfo)
+	}
+
+	// Staleness is measured on the follower's clock and keeps growing
+	// between heartbeats, so a tight limit trips on an idle stream.
+	waitFor(t, "lagging", func() bool {
+		w := httptest.NewRecorder()
+		api.ReadinessHandler{Replication: rec, MaxLag: 50 * time.Millisecond}.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code == http.StatusServiceUnavailable && strings.Contains(w.Body.String(), `"status":"lagging"`)
+	})
+
+	w := httptest.NewRecorder()
+	r := chi.NewRouter()
+	r.Put("/v1/users/{userID}/kv/{key}", api.KVHandler{Store: follower, ReadOnly: true}.ServeHTTP)
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/v1/users/1/kv/mood", strings.NewReader("happy")))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "READ_ONLY_NODE") {
+		t.Fatalf("expected a read-only rejection, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"tether/src/lib"
+	"tether/src/store"
+)
+
+func TestResyncRemovesMembersMissedWhileDisconnected(t *testing.T) {
+	st := store.NewPresenceStore()
+	guild := map[string]map[string]any{"100": nil}
+	st.SetPresence("1", store.PresenceData{
+		DiscordStatus: "online",
+		Activities:    []store.Activity{{"name": "Game", "type": float64(0)}},
+		GuildMembers:  guild,
+		DiscordUser:   store.DiscordUser{ID: "1", Username: "stale"},
+	})
+	st.SetPresence("2", store.PresenceData{DiscordStatus: "online", GuildMembers: guild})
+	st.SetPresence("3", store.PresenceData{DiscordStatus: "online", GuildMembers: map[string]map[string]any{"200": nil}})
+
+	// The reconnect re-requests members for guild 100.
+	tracker := lib.NewChunkTracker(st)
+	tracker.Begin("100", "resync")
+	time.Sleep(time.Millisecond)
+	// User 4 joins while the chunks are arriving and is in none of them.
+	st.SetPresence("4", store.PresenceData{DiscordStatus: "online", GuildMembers: guild})
+	// Only user 2 is still a member.
+	tracker.Record(lib.ChunkInfo{GuildID: "100", Nonce: "resync", ChunkIndex: 0, ChunkCount: 1, UserIDs: []string{"2"}})
+
+	if _, ok := st.GetPresence("1"); ok {
+		t.Fatal("expected the member missed while disconnected to be removed")
+	}
+	if fresh, ok := st.GetPresence("2"); !ok || fresh.DiscordStatus != "online" {
+		t.Fatalf("expected the refreshed member to stay online, got %+v", fresh)
+	}
+	if _, ok := st.GetPresence("4"); !ok {
+		t.Fatal("expected the member who joined during the resync to be kept")
+	}
+	if other, ok := st.GetPresence("3"); !ok || other.DiscordStatus != "online" {
+		t.Fatal("expected the user in another guild to be untouched")
+	}
+}
@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tether/src/bot"
+	"tether/src/rules"
+	"tether/src/store"
+)
+
+func loadRules(t *testing.T, body string) []rules.Rule {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	list, err := rules.LoadFile(path)
+	if err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	return list
+}
+
+func presenceEvent(userID, status string, activities ...store.Activity) store.PresenceEvent {
+	return store.PresenceEvent{UserID: userID, Presence: store.PresenceData{Public: store.PublicPresence{
+		Status:      status,
+		Activities:  activities,
+		DiscordUser: store.DiscordUser{ID: userID, Username: "user" + userID},
+	}}}
+}
+
+func TestRuleFiresOnActivityStart(t *testing.T) {
+	list := loadRules(t, `[{
+		"id": "playing",
+		"user_ids": ["1"],
+		"when": {"activity_name": "osu!"},
+		"actions": [{"type": "discord", "channel_id": "99", "message": "{{.Username}} is playing {{.Activity}}"}]
+	}]`)
+	var sent []string
+	engine := rules.New(list, rules.Actions{SendMessage: func(channelID, content string) error {
+		sent = append(sent, channelID+":"+content)
+		return nil
+	}}, false)
+
+	t0 := time.Unix(1_700_000_000, 0)
+	engine.Observe(presenceEvent("2", "online", store.Activity{"name": "osu!"}), t0)
+	engine.Observe(presenceEvent("1", "online", store.Activity{"name": "osu!"}), t0)
+	engine.Observe(presenceEvent("1", "online", store.Activity{"name": "osu!"}), t0.Add(time.Minute))
+
+	if len(sent) != 1 || sent[0] != "99:user1 is playing osu!" {
+		t.Fatalf("expected one message for user 1, got %q", sent)
+	}
+}
+
+func TestRuleDebounceAndCooldown(t *testing.T) {
+	list := loadRules(t, `[{
+		"id": "offline",
+		"when": {"status": "offline"},
+		"debounce": "30m",
+		"cooldown": "2h",
+		"actions": [{"type": "log"}]
+	}]`)
+	engine := rules.New(list, rules.Actions{}, false)
+
+	t0 := time.Unix(1_700_000_000, 0)
+	engine.Observe(presenceEvent("1", "offline"), t0)
+	engine.Advance(t0.Add(29 * time.Minute))
+	if n := len(engine.Matches()); n != 0 {
+		t.Fatalf("rule fired before its debounce: %d matches", n)
+	}
+	engine.Advance(t0.Add(30 * time.Minute))
+	if n := len(engine.Matches()); n != 1 {
+		t.Fatalf("expected one match after the debounce, got %d", n)
+	}
+
+	// Back online and offline again: the debounce elapses within the cooldown.
+	engine.Observe(presenceEvent("1", "online"), t0.Add(40*time.Minute))
+	engine.Observe(presenceEvent("1", "offline"), t0.Add(50*time.Minute))
+	engine.Advance(t0.Add(90 * time.Minute))
+	if n := len(engine.Matches()); n != 1 {
+		t.Fatalf("cooldown did not suppress the repeat: %d matches", n)
+	}
+
+	// An offline spell that starts after the cooldown fires again.
+	engine.Observe(presenceEvent("1", "online"), t0.Add(3*time.Hour))
+	engine.Observe(presenceEvent("1", "offline"), t0.Add(3*time.Hour))
+	engine.Advance(t0.Add(3*time.Hour + 30*time.Minute))
+	matches := engine.Matches()
+	if len(matches) != 2 {
+		t.Fatalf("expected a second match after the cooldown, got %d", len(matches))
+	}
+	if !matches[1].Since.Equal(t0.Add(3 * time.Hour)) {
+		t.Fatalf("unexpected match start %v", matches[1].Since)
+	}
+}
+
+func TestRuleValidation(t *testing.T) {
+	cases := map[string]string{
+		"missing condition": `[{"id": "a", "when": {}, "actions": [{"type": "log"}]}]`,
+		"missing channel":   `[{"id": "a", "when": {"status": "idle"}, "actions": [{"type": "discord"}]}]`,
+		"unknown action":    `[{"id": "a", "when": {"status": "idle"}, "actions": [{"type": "email"}]}]`,
+		"bad duration":      `[{"id": "a", "when": {"status": "idle"}, "debounce": 30, "actions": [{"type": "log"}]}]`,
+		"duplicate id": `[{"id": "a", "when": {"status": "idle"}, "actions": [{"type": "log"}]},
+			{"id": "a", "when": {"status": "dnd"}, "actions": [{"type": "log"}]}]`,
+	}
+	for name, body := range cases {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		_ = os.WriteFile(path, []byte(body), 0o644)
+		if _, err := rules.LoadFile(path); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestRuleDryRunOverRecording(t *testing.T) {
+	list := loadRules(t, `[{
+		"id": "offline",
+		"when": {"status": "offline"},
+		"debounce": "30m",
+		"actions": [{"type": "discord", "channel_id": "99"}]
+	}]`)
+
+	t0 := time.Unix(1_700_000_000, 0).UTC()
+	var lines []string
+	for _, ev := range []struct {
+		at     time.Duration
+		status string
+	}{{0, "offline"}, {10 * time.Minute, "online"}, {20 * time.Minute, "offline"}, {55 * time.Minute, "online"}} {
+		line, _ := json.Marshal(bot.RecordedEvent{At: t0.Add(ev.at), Type: "PRESENCE_UPDATE", Data: mustRaw(t, map[string]any{
+			"guild_id":   "100",
+			"status":     ev.status,
+			"activities": []any{},
+			"user":       map[string]any{"id": "42", "username": "recorded"},
+		})})
+		lines = append(lines, string(line))
+	}
+	path := filepath.Join(t.TempDir(), "gateway.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("write recording: %v", err)
+	}
+
+	sendCalled := false
+	engine := rules.New(list, rules.Actions{SendMessage: func(string, string) error {
+		sendCalled = true
+		return nil
+	}}, true)
+	if err := bot.ReplayPresenceEvents(path, engine.Observe); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+
+	matches := engine.Matches()
+	if len(matches) != 1 {
+		t.Fatalf("expected one match, got %+v", matches)
+	}
+	if !matches[0].Since.Equal(t0.Add(20*time.Minute)) || !matches[0].At.Equal(t0.Add(50*time.Minute)) {
+		t.Fatalf("unexpected match timing: %+v", matches[0])
+	}
+	if sendCalled {
+		t.Fatal("dry run must not run actions")
+	}
+}
+
+// blockingNotifier records webhook actions and holds the first one until
+// release is closed.
+type blockingNotifier struct {
+	started, release chan struct{}
+	once             sync.Once
+	mu               sync.Mutex
+	users            []string
+}
+
+func (n *blockingNotifier) Notify(_, userID, _ string, _ any) error {
+	n.once.Do(func() {
+		close(n.started)
+		<-n.release
+	})
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.users = append(n.users, userID)
+	return nil
+}
+
+func (n *blockingNotifier) notified(userID string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Contains(n.users, userID)
+}
+
+func TestRuleRunResyncsAfterDroppedEvents(t *testing.T) {
+	list := loadRules(t, `[{
+		"id": "dnd",
+		"when": {"status": "dnd"},
+		"actions": [{"type": "webhook", "webhook_id": "w"}]
+	}]`)
+	notifier := &blockingNotifier{started: make(chan struct{}), release: make(chan struct{})}
+	engine := rules.New(list, rules.Actions{Webhooks: notifier}, false)
+	st := store.NewPresenceStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.Run(ctx, st)
+
+	// Run subscribes asynchronously; repeat the change until it is seen.
+	for waiting := true; waiting; {
+		setStatus(st, "1", "dnd")
+		select {
+		case <-notifier.started:
+			waiting = false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	// The engine is stuck in user 1's action, so these overflow its buffer
+	// and user 2's change is dropped.
+	for i := range 2000 {
+		setStatus(st, "3", []string{"online", "idle"}[i%2])
+	}
+	setStatus(st, "2", "dnd")
+	close(notifier.release)
+
+	waitFor(t, "the dropped match for user 2", func() bool { return notifier.notified("2") })
+}
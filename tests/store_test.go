@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -38,3 +39,66 @@ func TestPresenceStoreBroadcast(t *testing.T) {
 		t.Fatalf("no broadcast received")
 	}
 }
+
+func TestPresenceStoreCountsDroppedEvents(t *testing.T) {
+	st := store.NewPresenceStore()
+	id, ch, cancel := st.SubscribeBuffered(2)
+	t.Cleanup(cancel)
+
+	for _, status := range []string{"online", "idle", "dnd", "offline"} {
+		st.SetPresence("abc", store.PresenceData{DiscordStatus: status, DiscordUser: store.DiscordUser{ID: "abc"}})
+	}
+	if len(ch) != 2 {
+		t.Fatalf("expected a full buffer, got %d events", len(ch))
+	}
+	if n := st.Dropped(id); n != 2 {
+		t.Fatalf("expected 2 dropped events, got %d", n)
+	}
+	if n := st.Dropped(id); n != 0 {
+		t.Fatalf("expected the count to reset, got %d", n)
+	}
+}
+
+func TestPresenceStoreReloadSettingsFromSharedFiles(t *testing.T) {
+	dir := t.TempDir()
+	load := func() *store.PresenceStore {
+		st := store.NewPresenceStore()
+		for _, err := range []error{
+			st.LoadPrivacy(filepath.Join(dir, "privacy.json")),
+			st.LoadKV(filepath.Join(dir, "kv.json")),
+			st.LoadAPIKeys(filepath.Join(dir, "api_keys.json")),
+		} {
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+		}
+		return st
+	}
+	leader, standby := load(), load()
+	_ = leader.SetKV("1", "old", "x")
+	if err := standby.ReloadSettings(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	// The leader changes settings after the standby last read them.
+	leader.SetPrivacy("1", store.PrivacySettings{HideSpotify: true})
+	_ = leader.DeleteKV("1", "old")
+	_ = leader.SetKV("1", "mood", "calm")
+	key, err := leader.IssueAPIKey("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := standby.ReloadSettings(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if !standby.GetPrivacy("1").HideSpotify {
+		t.Error("privacy change not reloaded")
+	}
+	if kv := standby.GetKV("1"); len(kv) != 1 || kv["mood"] != "calm" {
+		t.Errorf("expected the leader's KV only, got %v", kv)
+	}
+	if user, ok := standby.UserForAPIKey(key); !ok || user != "1" {
+		t.Error("api key not reloaded")
+	}
+}
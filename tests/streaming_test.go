@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"tether/src/lib"
+	"tether/src/store"
+	ws "tether/src/websocket"
+)
+
+func TestStreamPlatform(t *testing.T) {
+	cases := []struct {
+		url, platform, channel, thumbnail string
+	}{
+		{"https://www.twitch.tv/SomeStreamer", "twitch", "somestreamer", "https://static-cdn.jtvnw.net/previews-ttv/live_user_somestreamer-1280x720.jpg"},
+		{"https://kick.com/kicker", "kick", "kicker", ""},
+		{"https://www.youtube.com/watch?v=abc123", "youtube", "", "https://i.ytimg.com/vi/abc123/hqdefault_live.jpg"},
+		{"https://youtu.be/xyz", "youtube", "", "https://i.ytimg.com/vi/xyz/hqdefault_live.jpg"},
+		{"https://youtube.com/@creator", "youtube", "creator", ""},
+		{"https://example.com/live", "unknown", "", ""},
+	}
+	for _, tc := range cases {
+		platform, channel, thumbnail := lib.StreamPlatform(tc.url)
+		if platform != tc.platform || channel != tc.channel || thumbnail != tc.thumbnail {
+			t.Errorf("%s: got (%q, %q, %q)", tc.url, platform, channel, thumbnail)
+		}
+	}
+}
+
+func streamingPresence(streaming bool) map[string]any {
+	var acts []any
+	if streaming {
+		acts = append(acts, map[string]any{
+			"type":    float64(1),
+			"name":    "Twitch",
+			"url":     "https://twitch.tv/live_one",
+			"details": "Speedrun",
+			"state":   "Some Game",
+		})
+	}
+	return map[string]any{
+		"user":       map[string]any{"id": "77"},
+		"status":     "online",
+		"activities": acts,
+	}
+}
+
+func TestStreamStartedAndEndedEvents(t *testing.T) {
+	st := store.NewPresenceStore()
+	wsServer := ws.NewServer(st, ws.Config{})
+	t.Cleanup(wsServer.Close)
+	srv := httptest.NewServer(wsServer)
+	t.Cleanup(srv.Close)
+
+	storeRawPresence(t, st, streamingPresence(false))
+	conn := dialSocket(t, srv)
+	if err := conn.WriteJSON(map[string]any{"op": 2, "d": map[string]any{"subscribe_to_id": "77"}}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	readEvent(t, conn, "INIT_STATE")
+
+	storeRawPresence(t, st, streamingPresence(true))
+	started := readEvent(t, conn, "STREAM_STARTED")
+	stream, _ := started["data"].(map[string]any)
+	if stream["platform"] != "twitch" || stream["channel"] != "live_one" || stream["title"] != "Speedrun" {
+		t.Fatalf("unexpected STREAM_STARTED payload: %v", started)
+	}
+
+	storeRawPresence(t, st, streamingPresence(false))
+	ended := readEvent(t, conn, "STREAM_ENDED")
+	if ended["user_id"] != "77" {
+		t.Fatalf("unexpected STREAM_ENDED payload: %v", ended)
+	}
+}
+
+func TestStreamEventsUseConnectionCDNOptions(t *testing.T) {
+	st := store.NewPresenceStore()
+	wsServer := ws.NewServer(st, ws.Config{})
+	t.Cleanup(wsServer.Close)
+	srv := httptest.NewServer(wsServer)
+	t.Cleanup(srv.Close)
+
+	notStreaming := map[string]any{"user": map[string]any{"id": "78"}, "status": "online", "activities": []any{}}
+	storeRawPresence(t, st, notStreaming)
+	conn := dialSocket(t, srv)
+	if err := conn.WriteJSON(map[string]any{"op": 2, "d": map[string]any{"subscribe_to_id": "78", "avatar_format": "png", "avatar_size": 64}}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	readEvent(t, conn, "INIT_STATE")
+
+	storeRawPresence(t, st, map[string]any{
+		"user":   map[string]any{"id": "78"},
+		"status": "online",
+		"activities": []any{map[string]any{
+			"type":           float64(1),
+			"name":           "Live",
+			"url":            "https://example.com/live",
+			"application_id": "300",
+			"assets":         map[string]any{"large_image": "400"},
+		}},
+	})
+	want := "https://cdn.discordapp.com/app-assets/300/400.png?size=64"
+	started := readEvent(t, conn, "STREAM_STARTED")
+	if stream, _ := started["data"].(map[string]any); stream["thumbnail_url"] != want {
+		t.Fatalf("STREAM_STARTED thumbnail ignores the connection's options: %v", started)
+	}
+
+	storeRawPresence(t, st, notStreaming)
+	ended := readEvent(t, conn, "STREAM_ENDED")
+	if stream, _ := ended["data"].(map[string]any); stream["thumbnail_url"] != want {
+		t.Fatalf("STREAM_ENDED thumbnail ignores the connection's options: %v", ended)
+	}
+}
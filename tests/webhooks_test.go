@@ -0,0 +1,259 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tether/src/api"
+	"tether/src/store"
+	"tether/src/webhooks"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func setStatus(st *store.PresenceStore, userID, status string, activities ...store.Activity) {
+	st.SetPresence(userID, store.PresenceData{DiscordStatus: status, Activities: activities, DiscordUser: store.DiscordUser{ID: userID}})
+}
+
+func TestWebhookDeliveryAndFilters(t *testing.T) {
+	var mu sync.Mutex
+	var received []webhooks.Payload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sig := r.Header.Get("X-Tether-Signature")
+		if sig != "sha256="+webhooks.Sign("s3cret", r.Header.Get("X-Tether-Timestamp"), body) {
+			t.Errorf("bad signature %q", sig)
+		}
+		var p webhooks.Payload
+		_ = json.Unmarshal(body, &p)
+		mu.Lock()
+		received = append(received, p)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	st := store.NewPresenceStore()
+	d := webhooks.New(st, webhooks.Config{})
+	d.Start()
+	defer d.Close()
+	hook, err := d.Register(webhooks.Webhook{URL: receiver.URL, Secret: "s3cret", UserIDs: []string{"1"}, Events: []string{webhooks.EventStatusChange, webhooks.EventActivityStart}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	game := store.Activity{"type": float64(0), "name": "Game"}
+	setStatus(st, "1", "online")
+	setStatus(st, "2", "online")
+	setStatus(st, "2", "idle")         // filtered out by user
+	setStatus(st, "1", "online", game) // activity_start
+	setStatus(st, "1", "idle", game)   // status_change
+	setStatus(st, "1", "idle")         // activity_stop, filtered out by event
+
+	waitFor(t, "two deliveries", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	})
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	// Workers deliver concurrently, so order is not guaranteed.
+	events := []string{received[0].Event, received[1].Event}
+	slices.Sort(events)
+	if len(received) != 2 || events[0] != webhooks.EventActivityStart || events[1] != webhooks.EventStatusChange {
+		t.Fatalf("unexpected deliveries %+v", received)
+	}
+	deliveries, err := d.Deliveries(hook.ID)
+	if err != nil || len(deliveries) != 2 || deliveries[0].Status != webhooks.StatusDelivered {
+		t.Fatalf("unexpected delivery status %+v (%v)", deliveries, err)
+	}
+}
+
+func TestWebhookRetriesAndDeadLetter(t *testing.T) {
+	var attempts sync.Map
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := attempts.LoadOrStore("n", new(int))
+		*n.(*int)++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	deadLetters := filepath.Join(t.TempDir(), "dead.jsonl")
+	st := store.NewPresenceStore()
+	d := webhooks.New(st, webhooks.Config{MaxAttempts: 3, Backoff: 5 * time.Millisecond, DeadLetterFile: deadLetters})
+	d.Start()
+	defer d.Close()
+	hook, _ := d.Register(webhooks.Webhook{URL: receiver.URL})
+
+	setStatus(st, "1", "online")
+	setStatus(st, "1", "dnd")
+
+	waitFor(t, "failed delivery", func() bool {
+		ds, _ := d.Deliveries(hook.ID)
+		return len(ds) == 1 && ds[0].Status == webhooks.StatusFailed
+	})
+	ds, _ := d.Deliveries(hook.ID)
+	if ds[0].Attempts != 3 || ds[0].ResponseStatus != http.StatusInternalServerError {
+		t.Fatalf("expected 3 attempts ending in 500, got %+v", ds[0])
+	}
+	data, err := os.ReadFile(deadLetters)
+	if err != nil || !strings.Contains(string(data), `"event":"status_change"`) {
+		t.Fatalf("expected dead-letter entry, got %q (%v)", data, err)
+	}
+}
+
+func TestWebhookRetriesDoNotBlockOtherWebhooks(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	delivered := make(chan struct{}, 1)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer healthy.Close()
+
+	st := store.NewPresenceStore()
+	// One worker and a long backoff: the healthy webhook is only reached if
+	// the failing one's retry waits elsewhere.
+	d := webhooks.New(st, webhooks.Config{Workers: 1, Backoff: time.Minute})
+	d.Start()
+	defer d.Close()
+	bad, _ := d.Register(webhooks.Webhook{URL: failing.URL})
+	_, _ = d.Register(webhooks.Webhook{URL: healthy.URL})
+
+	setStatus(st, "1", "online")
+	setStatus(st, "1", "dnd")
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("healthy webhook was held up by the failing one's retry")
+	}
+	ds, _ := d.Deliveries(bad.ID)
+	if len(ds) != 1 || ds[0].Status != webhooks.StatusPending || ds[0].Attempts != 1 {
+		t.Fatalf("expected the failing delivery pending a retry, got %+v", ds)
+	}
+}
+
+func TestWebhookAdminAPI(t *testing.T) {
+	d := webhooks.New(store.NewPresenceStore(), webhooks.Config{File: filepath.Join(t.TempDir(), "webhooks.json")})
+	h := api.WebhooksHandler{Webhooks: d, AdminToken: "admin"}
+	r := chi.NewRouter()
+	r.Get("/v1/webhooks", h.ServeHTTP)
+	r.Post("/v1/webhooks", h.ServeHTTP)
+	r.Delete("/v1/webhooks/{webhookID}", h.ServeHTTP)
+	r.Get("/v1/webhooks/{webhookID}/deliveries", h.ServeHTTP)
+
+	do := func(method, path, token, body string) (int, map[string]any) {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		var out map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out
+	}
+
+	if code, _ := do(http.MethodGet, "/v1/webhooks", "wrong", ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the admin token, got %d", code)
+	}
+	if code, body := do(http.MethodPost, "/v1/webhooks", "admin", `{"url":"https://example.com/hook","events":["bogus"]}`); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown event, got %d %v", code, body)
+	}
+	code, body := do(http.MethodPost, "/v1/webhooks", "admin", `{"url":"https://example.com/hook","user_ids":["1"]}`)
+	created, _ := body["data"].(map[string]any)
+	if code != http.StatusCreated || created["secret"] == "" || created["id"] == "" {
+		t.Fatalf("expected created webhook with secret, got %d %v", code, body)
+	}
+	id := created["id"].(string)
+
+	_, body = do(http.MethodGet, "/v1/webhooks", "admin", "")
+	list, _ := body["data"].([]any)
+	if len(list) != 1 || list[0].(map[string]any)["secret"] != nil {
+		t.Fatalf("expected one redacted webhook, got %v", body)
+	}
+	if code, _ := do(http.MethodGet, "/v1/webhooks/"+id+"/deliveries", "admin", ""); code != http.StatusOK {
+		t.Fatalf("deliveries: got %d", code)
+	}
+	if code, _ := do(http.MethodDelete, "/v1/webhooks/"+id, "admin", ""); code != http.StatusOK {
+		t.Fatalf("delete: got %d", code)
+	}
+	if code, _ := do(http.MethodGet, "/v1/webhooks/"+id+"/deliveries", "admin", ""); code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", code)
+	}
+
+	// Followers list webhooks but leave changes to the leader.
+	follower := api.WebhooksHandler{Webhooks: d, AdminToken: "admin", ReadOnly: true}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req := httptest.NewRequest(method, "/v1/webhooks", bytes.NewBufferString(`{"url":"https://example.com/hook"}`))
+		req.Header.Set("Authorization", "Bearer admin")
+		rec := httptest.NewRecorder()
+		follower.ServeHTTP(rec, req)
+		if method == http.MethodGet && rec.Code != http.StatusOK {
+			t.Fatalf("follower list: got %d", rec.Code)
+		}
+		if method == http.MethodPost && (rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "READ_ONLY_NODE")) {
+			t.Fatalf("expected 503 READ_ONLY_NODE from a follower, got %d %s", rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestWebhookRegisterRollsBackOnSaveFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	d := webhooks.New(store.NewPresenceStore(), webhooks.Config{File: path})
+	if err := d.Load(); err != nil {
+		t.Fatalf("load missing file: %v", err)
+	}
+	// A directory in the file's place makes every save fail.
+	if err := os.Mkdir(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	h := api.WebhooksHandler{Webhooks: d, AdminToken: "admin"}
+	req := httptest.NewRequest(http.MethodPost, "/v1/webhooks", bytes.NewBufferString(`{"url":"https://example.com/hook"}`))
+	req.Header.Set("Authorization", "Bearer admin")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "INTERNAL_ERROR") {
+		t.Fatalf("expected 500 INTERNAL_ERROR, got %d %s", rec.Code, rec.Body)
+	}
+	if hooks := d.Webhooks(); len(hooks) != 0 {
+		t.Fatalf("expected the unsaved webhook to be dropped, got %+v", hooks)
+	}
+}
+
+func TestWebhookLoadGeneratesMissingSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	if err := os.WriteFile(path, []byte(`[{"url":"https://example.com/hook"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d := webhooks.New(store.NewPresenceStore(), webhooks.Config{File: path})
+	if err := d.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	hooks := d.Webhooks()
+	if len(hooks) != 1 || hooks[0].Secret == "" || hooks[0].ID == "" {
+		t.Fatalf("expected a generated ID and secret, got %+v", hooks)
+	}
+
+	// They are written back, so a restart signs with the same secret.
+	again := webhooks.New(store.NewPresenceStore(), webhooks.Config{File: path})
+	if err := again.Load(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if reloaded := again.Webhooks(); len(reloaded) != 1 || reloaded[0].Secret != hooks[0].Secret || reloaded[0].ID != hooks[0].ID {
+		t.Fatalf("expected the generated values to persist, got %+v", reloaded)
+	}
+}
@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tether/src/store"
+	ws "tether/src/websocket"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialSocket(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/socket"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// expectClose reads until the server closes the socket and returns the close code.
+func expectClose(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var ce *websocket.CloseError
+		if errors.As(err, &ce) {
+			return ce.Code
+		}
+		t.Fatalf("expected close frame, got %v", err)
+	}
+}
+
+func TestWebSocketConnectionLimitPerIP(t *testing.T) {
+	st := store.NewPresenceStore()
+	wsServer := ws.NewServer(st, ws.Config{MaxConnsPerIP: 1})
+	t.Cleanup(wsServer.Close)
+	srv := httptest.NewServer(wsServer)
+	t.Cleanup(srv.Close)
+
+	first := dialSocket(t, srv)
+	var hello map[string]any
+	if err := first.ReadJSON(&hello); err != nil {
+		t.Fatalf("expected hello on first connection: %v", err)
+	}
+
+	second := dialSocket(t, srv)
+	if code := expectClose(t, second); code != 4007 {
+		t.Fatalf("expected close code 4007, got %d", code)
+	}
+}
+
+func TestWebSocketSubscriptionLimit(t *testing.T) {
+	st := store.NewPresenceStore()
+	wsServer := ws.NewServer(st, ws.Config{MaxSubscriptions: 2})
+	t.Cleanup(wsServer.Close)
+	srv := httptest.NewServer(wsServer)
+	t.Cleanup(srv.Close)
+
+	conn := dialSocket(t, srv)
+	init := map[string]any{
+		"op": 2,
+		"d":  map[string]any{"subscribe_to_ids": []string{"1", "2", "3"}},
+	}
+	if err := conn.WriteJSON(init); err != nil {
+		t.Fatalf("write init: %v", err)
+	}
+	if code := expectClose(t, conn); code != 4009 {
+		t.Fatalf("expected close code 4009, got %d", code)
+	}
+}